@@ -0,0 +1,170 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// stateVersion is written in the header of every state file, files without a
+	// header are from before versioning and are treated as legacyStateVersion
+	stateVersion       int    = 3
+	offsetStateVersion int    = 2 //header but only offsets were persisted
+	legacyStateVersion int    = 1
+	stateMagic         string = `#filewatch state v`
+)
+
+var (
+	ErrStateVersion = errors.New("Unsupported state file version")
+)
+
+// FileState is what is persisted for each state entry, Id is the id of the file
+// the offset belongs to and is zero if it is not known.  Header is only kept when
+// WithHeaderCheck is enabled.
+type FileState struct {
+	Offset int64
+	Id     FileId
+	Header FileHeader
+}
+
+// StateCodec serializes the state map to and from the state file
+type StateCodec interface {
+	Encode(io.Writer, map[FileName]FileState) error
+	Decode(io.Reader, *map[FileName]FileState) error
+}
+
+// legacyStateDecoder is implemented by codecs whose older state files only
+// held offsets in a different shape than the current FileState map
+type legacyStateDecoder interface {
+	DecodeLegacy(io.Reader, *map[FileName]*int64) error
+}
+
+// GobCodec is the default state codec
+type GobCodec struct{}
+
+func (GobCodec) Encode(w io.Writer, states map[FileName]FileState) error {
+	return gob.NewEncoder(w).Encode(states)
+}
+
+func (GobCodec) Decode(r io.Reader, states *map[FileName]FileState) error {
+	return gob.NewDecoder(r).Decode(states)
+}
+
+// DecodeLegacy decodes the bare offset map written before version 3
+func (GobCodec) DecodeLegacy(r io.Reader, states *map[FileName]*int64) error {
+	return gob.NewDecoder(r).Decode(states)
+}
+
+// JSONCodec stores states as an indented JSON list so the state file can be
+// read and edited by hand
+type JSONCodec struct{}
+
+type jsonState struct {
+	BaseName string
+	FilePath string
+	Offset   int64
+	Id       FileId
+	Header   FileHeader
+}
+
+func (JSONCodec) Encode(w io.Writer, states map[FileName]FileState) error {
+	js := make([]jsonState, 0, len(states))
+	for k, v := range states {
+		js = append(js, jsonState{
+			BaseName: k.BaseName,
+			FilePath: k.FilePath,
+			Offset:   v.Offset,
+			Id:       v.Id,
+			Header:   v.Header,
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent(``, "\t")
+	return enc.Encode(js)
+}
+
+func (JSONCodec) Decode(r io.Reader, states *map[FileName]FileState) error {
+	var js []jsonState
+	if err := json.NewDecoder(r).Decode(&js); err != nil {
+		return err
+	}
+	if *states == nil {
+		*states = make(map[FileName]FileState, len(js))
+	}
+	for _, st := range js {
+		(*states)[FileName{BaseName: st.BaseName, FilePath: st.FilePath}] = FileState{
+			Offset: st.Offset,
+			Id:     st.Id,
+			Header: st.Header,
+		}
+	}
+	return nil
+}
+
+// encodeStates writes the version header followed by the encoded states
+func encodeStates(w io.Writer, codec StateCodec, states map[FileName]FileState) error {
+	if _, err := fmt.Fprintf(w, "%s%d\n", stateMagic, stateVersion); err != nil {
+		return err
+	}
+	return codec.Encode(w, states)
+}
+
+// decodeStates checks the version header and decodes the states that follow it,
+// ErrStateVersion is returned without decoding if the version is not one we can read
+func decodeStates(r io.Reader, codec StateCodec, states *map[FileName]FileState) (ver int, err error) {
+	br := bufio.NewReader(r)
+	ver = legacyStateVersion
+	if magic, _ := br.Peek(len(stateMagic)); bytes.Equal(magic, []byte(stateMagic)) {
+		var ln string
+		if ln, err = br.ReadString('\n'); err != nil {
+			return
+		}
+		if ver, err = strconv.Atoi(strings.TrimSpace(ln[len(stateMagic):])); err != nil {
+			err = fmt.Errorf("%w: bad header %q", ErrStateVersion, ln)
+			return
+		}
+	}
+	switch ver {
+	case stateVersion:
+		err = codec.Decode(br, states)
+	case legacyStateVersion, offsetStateVersion:
+		ld, ok := codec.(legacyStateDecoder)
+		if !ok {
+			//the codec did not change shape
+			err = codec.Decode(br, states)
+			return
+		}
+		var old map[FileName]*int64
+		if err = ld.DecodeLegacy(br, &old); err != nil {
+			return
+		}
+		if *states == nil {
+			*states = make(map[FileName]FileState, len(old))
+		}
+		for k, v := range old {
+			var st FileState
+			if v != nil {
+				st.Offset = *v
+			}
+			(*states)[k] = st
+		}
+	default:
+		err = fmt.Errorf("%w %d", ErrStateVersion, ver)
+	}
+	return
+}
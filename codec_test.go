@@ -0,0 +1,250 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestJSONStateCodec(t *testing.T) {
+	name, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	fm, err := NewFilterManager(name, WithStateCodec(JSONCodec{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//states for files that exist so they survive the startup clean
+	*fm.addSeekInfo(bName, name) = 0
+	*fm.addSeekInfo(`other`, name) = 0
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//the file has to be plain JSON
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte(stateMagic)) {
+		t.Fatalf("missing version header: %s", b)
+	}
+	b = b[bytes.IndexByte(b, '\n')+1:]
+	var js []jsonState
+	if err := json.Unmarshal(b, &js); err != nil {
+		t.Fatalf("state file is not JSON: %v\n%s", err, b)
+	} else if len(js) != 2 {
+		t.Fatalf("bad states: %+v", js)
+	}
+
+	//and load back in through both the manager and ReadStateFile
+	if fm, err = NewFilterManager(name, WithStateCodec(JSONCodec{})); err != nil {
+		t.Fatal(err)
+	} else if len(fm.states) != 2 {
+		t.Fatalf("bad states after reload: %v", fm.states)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if states, err := ReadStateFile(name, WithStateCodec(JSONCodec{})); err != nil {
+		t.Fatal(err)
+	} else if len(states) != 2 {
+		t.Fatalf("bad states from ReadStateFile: %v", states)
+	}
+	//the default codec must not accept it
+	if _, err := ReadStateFile(name); err == nil {
+		t.Fatal("gob codec decoded a JSON state file")
+	}
+}
+
+func TestStateFileVersion(t *testing.T) {
+	name, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+
+	//write a v1 file, which is just a bare gob map
+	var offset int64 = 5
+	legacy := map[FileName]*int64{
+		FileName{BaseName: bName, FilePath: name}: &offset,
+	}
+	var bb bytes.Buffer
+	if err := gob.NewEncoder(&bb).Encode(legacy); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(name, bb.Bytes(), 0660); err != nil {
+		t.Fatal(err)
+	}
+	fm, err := NewFilterManager(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if si := fm.seekInfo(bName, name); si == nil || *si != offset {
+		t.Fatal("v1 state not loaded", si)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	//closing upgrades the file
+	if b, err := ioutil.ReadFile(name); err != nil {
+		t.Fatal(err)
+	} else if !bytes.HasPrefix(b, []byte(fmt.Sprintf("%s%d\n", stateMagic, stateVersion))) {
+		t.Fatal("state file was not upgraded")
+	}
+
+	//a version from the future is ignored rather than failing startup
+	fout, err := os.OpenFile(name, os.O_WRONLY|os.O_TRUNC, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(fout, "%s%d\n", stateMagic, stateVersion+1)
+	fout.Write(bb.Bytes())
+	fout.Close()
+	if _, err := ReadStateFile(name); !errors.Is(err, ErrStateVersion) {
+		t.Fatal("future version not rejected", err)
+	}
+	if fm, err = NewFilterManager(name); err != nil {
+		t.Fatal(err)
+	} else if len(fm.states) != 0 {
+		t.Fatal("future version states were loaded", fm.states)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCorruptStateRecovery(t *testing.T) {
+	name, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	defer os.RemoveAll(name + corruptStateSuffix)
+	garbage := []byte("this is not a state file")
+	if err := ioutil.WriteFile(name, garbage, 0660); err != nil {
+		t.Fatal(err)
+	}
+	//the default is still a hard failure
+	if _, err := NewFilterManager(name); err == nil {
+		t.Fatal("corrupt state file accepted")
+	}
+	fm, err := NewFilterManager(name, WithRecoverFromCorruptState(true))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(fm.states) != 0 {
+		t.Fatal("states loaded from corrupt file", fm.states)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(name + corruptStateSuffix); err != nil {
+		t.Fatal("corrupt file not preserved", err)
+	} else if !bytes.Equal(b, garbage) {
+		t.Fatalf("bad corrupt file contents: %q", b)
+	}
+	//and the new state file is usable
+	if _, err := ReadStateFile(name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// deniedCodec fails every decode the way a codec backed by a protected store would
+type deniedCodec struct {
+	JSONCodec
+}
+
+func (deniedCodec) Decode(io.Reader, *map[FileName]FileState) error {
+	return fmt.Errorf("decode: %w", os.ErrPermission)
+}
+
+func TestStateLoadErrorWrapped(t *testing.T) {
+	name, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	if err := ioutil.WriteFile(name, []byte("{}"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	//the decode failure must survive the load wrapping
+	if _, err := NewFilterManager(name, WithStateCodec(deniedCodec{})); err == nil {
+		t.Fatal("failed decode accepted")
+	} else if !errors.Is(err, os.ErrPermission) {
+		t.Fatal("decode error not wrapped", err)
+	}
+}
+
+func TestStateReplacedFile(t *testing.T) {
+	name, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := ioutil.WriteFile(fname, []byte("one\ntwo\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	id, err := getFileIdFromName(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fm, err := NewFilterManager(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fm.mtx.Lock()
+	*fm.addSeekInfo(bName, fname) = 8
+	fm.stateIds[FileName{BaseName: bName, FilePath: fname}] = id
+	fm.mtx.Unlock()
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//the same file reopens at its offset
+	if fm, err = NewFilterManager(name); err != nil {
+		t.Fatal(err)
+	} else if si := fm.seekInfo(bName, fname); si == nil || *si != 8 {
+		t.Fatal("offset lost for unchanged file", si)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//rotate in a new, larger file at the same path
+	rotated := fname + `.1`
+	defer os.RemoveAll(rotated)
+	if err := os.Rename(fname, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fname, []byte("three\nfour\nfive\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if fm, err = NewFilterManager(name); err != nil {
+		t.Fatal(err)
+	} else if si := fm.seekInfo(bName, fname); si == nil || *si != 0 {
+		t.Fatal("offset not reset for replaced file", si)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
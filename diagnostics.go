@@ -0,0 +1,173 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"path/filepath"
+	"sort"
+)
+
+// SkipReason explains why a file that matches a filter is not being followed
+type SkipReason int
+
+const (
+	SkipNotLoaded  SkipReason = iota //no follower has been launched for the file yet
+	SkipPredicate                    //the filter predicate rejected the file
+	SkipHardlink                     //the file is another link to a followed file
+	SkipError                        //checking the file failed, see Err
+	SkipFirstMatch                   //an earlier filter already follows the file
+)
+
+func (sr SkipReason) String() string {
+	switch sr {
+	case SkipNotLoaded:
+		return `not loaded`
+	case SkipPredicate:
+		return `rejected by predicate`
+	case SkipHardlink:
+		return `hardlink to followed file`
+	case SkipError:
+		return `error`
+	case SkipFirstMatch:
+		return `followed by an earlier filter`
+	}
+	return `unknown`
+}
+
+// UnfollowedFile is a file on disk that matches a filter but has no active follower
+type UnfollowedFile struct {
+	Name   FileName
+	Reason SkipReason
+	Err    error
+}
+
+// UnfollowedMatches walks the location of every filter and returns the matching files
+// which do not have an active follower along with the reason they are not followed.
+// Nothing is launched or modified, but the walk happens under a shared lock which
+// blocks launching followers, so this can be expensive on large directories.
+func (fm *FilterManager) UnfollowedMatches() (r []UnfollowedFile) {
+	fm.mtx.RLock()
+	defer fm.mtx.RUnlock()
+	for _, v := range fm.filters {
+		fpaths, err := fm.existingFiles(v)
+		if err != nil {
+			r = append(r, UnfollowedFile{
+				Name:   FileName{BaseName: v.bname, FilePath: v.loc},
+				Reason: SkipError,
+				Err:    err,
+			})
+			continue
+		}
+		for _, fpath := range fpaths {
+			stid := FileName{
+				BaseName: v.bname,
+				FilePath: fpath,
+			}
+			if _, ok := fm.followers[stid]; ok {
+				continue
+			}
+			uf := UnfollowedFile{
+				Name: stid,
+			}
+			uf.Reason, uf.Err = fm.skipReason(v, stid.FilePath)
+			r = append(r, uf)
+		}
+	}
+	return
+}
+
+// skipReason applies the same decisions as launchFollowers without launching anything
+// Caller MUST HOLD THE LOCK
+func (fm *FilterManager) skipReason(v filter, fpath string) (SkipReason, error) {
+	id, err := fm.fileId(fpath)
+	if err != nil {
+		return SkipError, err
+	}
+	if v.pred != nil {
+		if ok, err := v.pred(fpath); err != nil {
+			return SkipError, err
+		} else if !ok {
+			return SkipPredicate, nil
+		}
+	}
+	if fm.dedupeLinks {
+		if _, ok := fm.linkedFollower(fpath, id); ok {
+			return SkipHardlink, nil
+		}
+	}
+	if fm.firstMatch && fm.pathFollowed(fpath) {
+		return SkipFirstMatch, nil
+	}
+	return SkipNotLoaded, nil
+}
+
+// Matches returns the base names of the filters whose location and patterns match
+// fpath, in install order, using the same matching as loading a file including
+// recursive, exclude, regex, and first match modes.  Nothing is launched and the
+// file does not have to exist, so filter predicates are not consulted.
+func (fm *FilterManager) Matches(fpath string) (r []string) {
+	fpath = filepath.Clean(fpath)
+	fm.mtx.RLock()
+	defer fm.mtx.RUnlock()
+	for _, v := range fm.filters {
+		if !fm.pathMatch(v, fpath) {
+			continue
+		}
+		r = append(r, v.bname)
+		if fm.firstMatch {
+			break
+		}
+	}
+	return
+}
+
+// DryRun walks the location of every filter and returns the paths of the files on
+// disk that match it right now, keyed by filter base name.  No files are opened and
+// nothing is launched or modified, so filter predicates are not consulted.  Filters
+// whose location cannot be read are logged and left out.
+func (fm *FilterManager) DryRun() map[string][]string {
+	fm.mtx.RLock()
+	defer fm.mtx.RUnlock()
+	r := make(map[string][]string, len(fm.filters))
+	for _, v := range fm.filters {
+		fpaths, err := fm.existingFiles(v)
+		if err != nil {
+			fm.logger.Warn("Filter %v failed to list %v: %v", v.bname, v.loc, err)
+			continue
+		}
+		r[v.bname] = append(r[v.bname], fpaths...)
+	}
+	return r
+}
+
+// DuplicateFileIds returns the file ids reported by followers of more than one distinct
+// path.  Files sharing an id, whether distinct files or links to the same file, cannot
+// be told apart by id so their renames are handled by path.
+func (fm *FilterManager) DuplicateFileIds() (r []FileId) {
+	fm.mtx.RLock()
+	defer fm.mtx.RUnlock()
+	paths := map[FileId]string{}
+	dups := map[FileId]bool{}
+	for k, v := range fm.followers {
+		id := v.FileId()
+		if p, ok := paths[id]; !ok {
+			paths[id] = k.FilePath
+		} else if p != k.FilePath && !dups[id] {
+			dups[id] = true
+			r = append(r, id)
+		}
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Major != r[j].Major {
+			return r[i].Major < r[j].Major
+		}
+		return r[i].Minor < r[j].Minor
+	})
+	return
+}
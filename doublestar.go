@@ -0,0 +1,51 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"path"
+	"strings"
+)
+
+const doublestar = `**`
+
+// doublestarMatch matches a slash separated name against a pattern where a ** segment
+// matches zero or more whole segments, every other segment is matched with path.Match.
+// Malformed patterns never match.
+func doublestarMatch(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, `/`), strings.Split(name, `/`))
+}
+
+func matchSegments(pats, segs []string) bool {
+	for len(pats) > 0 {
+		if pats[0] == doublestar {
+			//collapse runs of ** and try every possible split of the remaining segments
+			for len(pats) > 0 && pats[0] == doublestar {
+				pats = pats[1:]
+			}
+			if len(pats) == 0 {
+				return true
+			}
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pats, segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pats[0], segs[0]); err != nil || !ok {
+			return false
+		}
+		pats, segs = pats[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
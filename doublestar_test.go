@@ -0,0 +1,50 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"testing"
+)
+
+func TestDoublestarMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		//literal segments
+		{`app/a.log`, `app/a.log`, true},
+		{`app/a.log`, `app/b.log`, false},
+		{`app/a.log`, `other/a.log`, false},
+		//single star stays within a segment
+		{`app/*.log`, `app/a.log`, true},
+		{`app/*.log`, `app/x/a.log`, false},
+		{`*/a.log`, `app/a.log`, true},
+		{`*/a.log`, `a.log`, false},
+		//doublestar spans any number of segments
+		{`app/**/*.log`, `app/a.log`, true},
+		{`app/**/*.log`, `app/x/a.log`, true},
+		{`app/**/*.log`, `app/x/y/z/a.log`, true},
+		{`app/**/*.log`, `app/x/y/z/a.txt`, false},
+		{`app/**/*.log`, `other/x/a.log`, false},
+		{`**/a.log`, `a.log`, true},
+		{`**/a.log`, `x/y/a.log`, true},
+		{`app/**`, `app/x/y/a.log`, true},
+		{`app/**/**/a.log`, `app/a.log`, true},
+		{`app/**/2024/*.log`, `app/x/2024/a.log`, true},
+		{`app/**/2024/*.log`, `app/x/2023/a.log`, false},
+		//malformed patterns never match
+		{`app/[/a.log`, `app/[/a.log`, false},
+	}
+	for _, tt := range tests {
+		if r := doublestarMatch(tt.pattern, tt.name); r != tt.match {
+			t.Errorf("%s against %s: expected %v got %v", tt.pattern, tt.name, tt.match, r)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const utf8BOM = "\xef\xbb\xbf"
+
+var (
+	ErrUnknownEncoding = errors.New("Unknown character encoding")
+	ErrEncodingEngine  = errors.New("Encoded files can only be read uncompressed with the line engine")
+)
+
+// encodings that are not spelled the way the html index expects, or that the html
+// index maps to something else (it treats latin1 as windows-1252)
+var namedEncodings = map[string]encoding.Encoding{
+	`utf-16le`:   unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+	`utf-16be`:   unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+	`latin1`:     charmap.ISO8859_1,
+	`iso-8859-1`: charmap.ISO8859_1,
+}
+
+// lookupEncoding resolves an encoding name, names are not case sensitive
+func lookupEncoding(name string) (encoding.Encoding, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if enc, ok := namedEncodings[name]; ok {
+		return enc, nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q", ErrUnknownEncoding, name)
+	}
+	return enc, nil
+}
+
+// EncodedReader reads lines out of a file written in a character encoding other than
+// UTF-8 and hands them back converted to UTF-8.  Records are split on the encoded form
+// of the delimiter, so indexes are always offsets into the raw file and a restart can
+// seek straight back to them.  A byte order mark at the start of the file is dropped.
+type EncodedReader struct {
+	baseReader
+	brdr         *bufio.Reader
+	dec          *encoding.Decoder
+	delim        byte
+	rawDelim     []byte //the delimiter in the file encoding
+	width        int    //size of a code unit, records are always a whole number of units
+	keepDelim    bool
+	flushPartial bool
+	partial      []byte //raw bytes of a record that has not seen its delimiter
+}
+
+func NewEncodedReader(cfg ReaderConfig) (*EncodedReader, error) {
+	enc, err := lookupEncoding(cfg.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	delim, err := parseDelimiter(cfg.Delimiter)
+	if err != nil {
+		return nil, err
+	}
+	rawDelim, err := enc.NewEncoder().Bytes([]byte(string(rune(delim))))
+	if err != nil {
+		return nil, err
+	}
+	br, err := newBaseReader(cfg.Fin, cfg.MaxLineLen, cfg.StartIndex)
+	if err != nil {
+		return nil, err
+	}
+	return &EncodedReader{
+		baseReader:   br,
+		brdr:         bufio.NewReaderSize(cfg.Fin, readBufferSize(cfg.BufferSize)),
+		dec:          enc.NewDecoder(),
+		delim:        delim,
+		rawDelim:     rawDelim,
+		width:        len(rawDelim),
+		keepDelim:    cfg.KeepDelimiter,
+		flushPartial: cfg.FlushPartial,
+	}, nil
+}
+
+func (er *EncodedReader) ReadEntry() (ln []byte, ok bool, wasEOF bool, err error) {
+	unit := make([]byte, er.width)
+	for {
+		//only read what is missing from the current unit so records stay aligned
+		need := unit[:er.width-len(er.partial)%er.width]
+		var n int
+		if n, err = io.ReadFull(er.brdr, need); err != nil {
+			//hold on to a partial unit until the rest of it shows up
+			er.partial = append(er.partial, unit[:n]...)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = nil
+				wasEOF = true
+			}
+			return
+		}
+		er.partial = append(er.partial, need...)
+		if !bytes.HasSuffix(er.partial, er.rawDelim) {
+			continue
+		}
+		raw := er.partial
+		er.partial = nil
+		start := er.idx
+		er.idx += int64(len(raw))
+		if ln, err = er.decode(raw, start); err != nil {
+			return
+		} else if len(ln) > 0 {
+			ok = true
+			return
+		}
+		//empty line, try again
+	}
+}
+
+// decode converts a raw record that started at offset start to UTF-8
+func (er *EncodedReader) decode(raw []byte, start int64) ([]byte, error) {
+	ln, err := er.dec.Bytes(raw)
+	if err != nil {
+		return nil, err
+	}
+	if start == 0 {
+		ln = bytes.TrimPrefix(ln, []byte(utf8BOM))
+	}
+	if !er.keepDelim {
+		ln = trimDelim(ln, er.delim)
+	}
+	return ln, nil
+}
+
+// FlushEntry hands back a trailing partial record that is still waiting on its
+// delimiter, nothing is returned unless the reader was configured with FlushPartial
+func (er *EncodedReader) FlushEntry() (ln []byte, ok bool) {
+	whole := len(er.partial) - len(er.partial)%er.width
+	if !er.flushPartial || whole == 0 {
+		return
+	}
+	raw := er.partial[:whole]
+	er.partial = er.partial[whole:]
+	start := er.idx
+	er.idx += int64(len(raw))
+	var err error
+	if ln, err = er.decode(raw, start); err != nil || len(ln) == 0 {
+		return nil, false
+	}
+	return ln, true
+}
+
+func (er *EncodedReader) SeekFile(offset int64) error {
+	er.partial = nil
+	if err := er.baseReader.SeekFile(offset); err != nil {
+		return err
+	}
+	er.brdr.Reset(er.f)
+	return nil
+}
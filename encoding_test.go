@@ -0,0 +1,118 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"golang.org/x/text/encoding/unicode"
+)
+
+func newTestEncodedReader(t *testing.T, enc string, start int64) (Reader, *os.File, string) {
+	f, name, err := newFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr, err := NewReader(ReaderConfig{Fin: f, MaxLineLen: defMaxLine, StartIndex: start, Encoding: enc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rdr, w, name
+}
+
+func utf16le(t *testing.T, s string) []byte {
+	b, err := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestEncodedReaderUTF16(t *testing.T) {
+	rdr, w, name := newTestEncodedReader(t, `UTF-16LE`, 0)
+	defer cleanFile(name, t)
+	defer rdr.Close()
+	defer w.Close()
+	first := append([]byte{0xff, 0xfe}, utf16le(t, "héllo\r\n")...)
+	//the newline code unit is 0x0a 0x00, make sure 0x0a bytes inside other characters do not split
+	second := utf16le(t, "ਊ wörld\n")
+	partial := utf16le(t, "thir")
+	if _, err := w.Write(append(append(append([]byte{}, first...), second...), partial[:len(partial)-1]...)); err != nil {
+		t.Fatal(err)
+	}
+	if r := readEntries(t, rdr); !reflect.DeepEqual(r, []string{`héllo`, `ਊ wörld`}) {
+		t.Fatalf("bad records: %q", r)
+	}
+	//offsets are into the raw file
+	if rdr.Index() != int64(len(first)+len(second)) {
+		t.Fatal("bad index", rdr.Index())
+	}
+	if _, err := w.Write(append(partial[len(partial)-1:], utf16le(t, "d\n")...)); err != nil {
+		t.Fatal(err)
+	}
+	if r := readEntries(t, rdr); !reflect.DeepEqual(r, []string{`third`}) {
+		t.Fatalf("bad records after partial: %q", r)
+	}
+
+	//restarting at a saved offset picks up where we left off
+	rdr2, err := NewReader(ReaderConfig{Fin: mustOpen(t, name), MaxLineLen: defMaxLine, StartIndex: int64(len(first)), Encoding: `utf-16le`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdr2.Close()
+	if r := readEntries(t, rdr2); !reflect.DeepEqual(r, []string{`ਊ wörld`, `third`}) {
+		t.Fatalf("bad records after restart: %q", r)
+	}
+}
+
+func TestEncodedReaderLatin1(t *testing.T) {
+	rdr, w, name := newTestEncodedReader(t, `latin1`, 0)
+	defer cleanFile(name, t)
+	defer rdr.Close()
+	defer w.Close()
+	if _, err := w.Write([]byte("caf\xe9\n\xa9 2024\n")); err != nil {
+		t.Fatal(err)
+	}
+	if r := readEntries(t, rdr); !reflect.DeepEqual(r, []string{`café`, `© 2024`}) {
+		t.Fatalf("bad records: %q", r)
+	}
+	if rdr.Index() != 12 {
+		t.Fatal("bad index", rdr.Index())
+	}
+}
+
+func TestUnknownEncoding(t *testing.T) {
+	f, name, err := newFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	defer f.Close()
+	if _, err := NewReader(ReaderConfig{Fin: f, Encoding: `klingon`}); !errors.Is(err, ErrUnknownEncoding) {
+		t.Fatal("unknown encoding accepted", err)
+	}
+	if err := (FollowerEngineConfig{Encoding: `latin1`, Gzip: true}).validate(); !errors.Is(err, ErrEncodingEngine) {
+		t.Fatal("compressed encoded files accepted", err)
+	}
+}
+
+func mustOpen(t *testing.T, name string) *os.File {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
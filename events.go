@@ -0,0 +1,144 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultEventDepth int = 1024
+)
+
+type EventType int
+
+const (
+	EventStateCompacted        EventType = iota
+	EventCaughtUp                        //follower reached EOF for the first time
+	EventScanProgress                    //initial scan loaded another batch, Count is the running total
+	EventHardlinkSkipped                 //Path is another link to the followed file Name and was skipped
+	EventSymlinkRetargeted               //followed symlink was repointed, Path is the new target
+	EventStatePersistFailed              //writing the state file at Path failed, sent once until it recovers
+	EventStatePersistRecovered           //writing the state file at Path succeeded after failing
+	EventTruncated                       //followed file shrank below the read position and is read again from the start
+	EventStarted                         //a follower was started on Name
+	EventStopped                         //the follower on Name was stopped and is no longer managed
+	EventRenamed                         //the followed file moved from Path to Name
+	EventDeleted                         //the file at Path was removed, sent once before its Count followers are stopped
+	EventReplaced                        //a different file took the place of Name and is read from the start, see ReopenCheck
+)
+
+// FollowerEvent describes a change in the state of the manager or one of its followers.
+// Name is empty for events that are not tied to a specific file.
+type FollowerEvent struct {
+	Type  EventType
+	Name  FileName
+	Time  time.Time
+	Path  string //secondary path, e.g. the skipped link to a followed file
+	Count int    //number of items affected, e.g. state entries removed by a compaction
+	Err   error  //underlying error for failure events
+}
+
+func (et EventType) String() string {
+	switch et {
+	case EventStateCompacted:
+		return `state compacted`
+	case EventCaughtUp:
+		return `caught up`
+	case EventScanProgress:
+		return `scan progress`
+	case EventHardlinkSkipped:
+		return `hardlink skipped`
+	case EventSymlinkRetargeted:
+		return `symlink retargeted`
+	case EventStatePersistFailed:
+		return `state persist failed`
+	case EventStatePersistRecovered:
+		return `state persist recovered`
+	case EventTruncated:
+		return `truncated`
+	case EventStarted:
+		return `started`
+	case EventStopped:
+		return `stopped`
+	case EventRenamed:
+		return `renamed`
+	case EventDeleted:
+		return `deleted`
+	case EventReplaced:
+		return `replaced`
+	}
+	return `unknown`
+}
+
+// eventBus delivers events without ever blocking the emitter, if nobody asked
+// for events or the channel is full the event is dropped
+type eventBus struct {
+	dropped uint64 //events lost to a full channel, accessed atomically
+	mtx     *sync.Mutex
+	ch      chan FollowerEvent
+	closed  bool
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		mtx: &sync.Mutex{},
+	}
+}
+
+// channel returns the event channel, creating it on first use
+func (eb *eventBus) channel() <-chan FollowerEvent {
+	eb.mtx.Lock()
+	defer eb.mtx.Unlock()
+	if eb.ch == nil {
+		eb.ch = make(chan FollowerEvent, defaultEventDepth)
+		if eb.closed {
+			close(eb.ch)
+		}
+	}
+	return eb.ch
+}
+
+func (eb *eventBus) emit(evt FollowerEvent) {
+	if eb == nil {
+		return
+	}
+	eb.mtx.Lock()
+	defer eb.mtx.Unlock()
+	if eb.ch == nil || eb.closed {
+		return
+	}
+	if evt.Time.IsZero() {
+		evt.Time = time.Now()
+	}
+	select {
+	case eb.ch <- evt:
+	default:
+		atomic.AddUint64(&eb.dropped, 1)
+	}
+}
+
+// droppedEvents returns the number of events dropped because the channel was full
+func (eb *eventBus) droppedEvents() uint64 {
+	return atomic.LoadUint64(&eb.dropped)
+}
+
+func (eb *eventBus) close() {
+	eb.mtx.Lock()
+	defer eb.mtx.Unlock()
+	if eb.closed {
+		return
+	}
+	eb.closed = true
+	if eb.ch != nil {
+		close(eb.ch)
+	}
+}
@@ -0,0 +1,52 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"expvar"
+	"sync"
+)
+
+var (
+	expvarMtx sync.Mutex //makes checking for and publishing names atomic
+)
+
+// PublishExpvar publishes the manager counters as expvar variables named
+// <prefix>.followed, .files, .bytes_read, .records, .read_errors, .rotations,
+// .dropped_records, .dead_lettered, and .handler_panics.  Values are read on every scrape of
+// /debug/vars.  Expvars cannot be removed, so every manager in a process needs its
+// own prefix, ErrExpvarPublished is returned without publishing anything if any of
+// the names are taken.
+func (fm *FilterManager) PublishExpvar(prefix string) error {
+	vars := map[string]func(ManagerStats) interface{}{
+		`followed`:        func(ms ManagerStats) interface{} { return ms.Followed },
+		`files`:           func(ms ManagerStats) interface{} { return ms.Files },
+		`bytes_read`:      func(ms ManagerStats) interface{} { return ms.BytesRead },
+		`records`:         func(ms ManagerStats) interface{} { return ms.Records },
+		`read_errors`:     func(ms ManagerStats) interface{} { return ms.ReadErrors },
+		`rotations`:       func(ms ManagerStats) interface{} { return ms.Rotations },
+		`dropped_records`: func(ms ManagerStats) interface{} { return ms.DroppedRecords },
+		`dead_lettered`:   func(ms ManagerStats) interface{} { return ms.DeadLettered },
+		`handler_panics`:  func(ms ManagerStats) interface{} { return ms.HandlerPanics },
+	}
+	expvarMtx.Lock()
+	defer expvarMtx.Unlock()
+	for name := range vars {
+		if expvar.Get(prefix+`.`+name) != nil {
+			return ErrExpvarPublished
+		}
+	}
+	for name, fn := range vars {
+		fn := fn
+		expvar.Publish(prefix+`.`+name, expvar.Func(func() interface{} {
+			return fn(fm.Stats())
+		}))
+	}
+	return nil
+}
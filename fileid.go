@@ -0,0 +1,86 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"hash/fnv"
+	"io"
+	"os"
+)
+
+const (
+	DefaultHashIdBytes int64 = 1024
+)
+
+// FileIdStrategy derives the identity used to recognize a file across renames,
+// rotations, and restarts.  The id is stored in the state file, switching strategies
+// makes every saved state look like it belongs to a different file.
+type FileIdStrategy interface {
+	FileId(f *os.File) (FileId, error)
+}
+
+// InodeIdStrategy identifies files by device and inode (volume and file index on
+// Windows).  This is the default, it is free to compute and follows a file no matter
+// how its contents change, but it breaks on filesystems that reuse or do not keep
+// stable inodes such as some overlay, FUSE, and network mounts.
+type InodeIdStrategy struct{}
+
+func (InodeIdStrategy) FileId(f *os.File) (FileId, error) {
+	return getFileId(f)
+}
+
+// HashIdStrategy identifies files by a hash of their first Bytes bytes, a Bytes of
+// zero uses DefaultHashIdBytes.  Ids survive filesystems with unstable inodes but come
+// with tradeoffs:
+//   - a file shorter than Bytes changes id every time it grows, so it looks like a new
+//     file until it holds at least Bytes bytes
+//   - files with identical leading bytes, such as logs that start with the same
+//     header, are indistinguishable
+//   - a file rewritten in place with a different prefix is treated as a new file
+//   - every id costs an open and a read rather than a stat
+type HashIdStrategy struct {
+	Bytes int64
+}
+
+func (h HashIdStrategy) FileId(f *os.File) (id FileId, err error) {
+	sz := h.Bytes
+	if sz <= 0 {
+		sz = DefaultHashIdBytes
+	}
+	//a section reader uses ReadAt, the file offset is left alone for the next reader
+	hsh := fnv.New64a()
+	n, err := io.Copy(hsh, io.NewSectionReader(f, 0, sz))
+	if err != nil {
+		return
+	}
+	id.Major = uint64(n)
+	id.Minor = hsh.Sum64()
+	return
+}
+
+// fileIdFromName resolves the id of the file at fpath using strategy s, a nil strategy
+// is the inode strategy
+func fileIdFromName(s FileIdStrategy, fpath string) (FileId, error) {
+	if s == nil {
+		return getFileIdFromName(fpath)
+	} else if _, ok := s.(InodeIdStrategy); ok {
+		return getFileIdFromName(fpath) //no need to open the file
+	}
+	fin, err := openDeletableFile(fpath)
+	if err != nil {
+		return FileId{}, err
+	}
+	defer fin.Close()
+	return s.FileId(fin)
+}
+
+// fileId resolves the id of the file at fpath using the strategy of the manager
+func (fm *FilterManager) fileId(fpath string) (FileId, error) {
+	return fileIdFromName(fm.idStrat, fpath)
+}
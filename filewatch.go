@@ -9,9 +9,9 @@
 package filewatch
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -24,14 +24,32 @@ import (
 )
 
 var (
-	ErrNotReady         = errors.New("fsnotify watcher is not ready")
-	ErrLocationNotDir   = errors.New("Watched Location is not a directory")
-	ErrNoDirsWatched    = errors.New("No locations have been added to the watch list")
-	ErrInvalidStateFile = errors.New("State file exists and is not a regular file")
-	ErrAlreadyStarted   = errors.New("WatchManager already started")
-	ErrFailedSeek       = errors.New("Failed to seek to the start of the states file")
+	ErrNotReady          = errors.New("fsnotify watcher is not ready")
+	ErrLocationNotDir    = errors.New("Watched Location is not a directory")
+	ErrNoDirsWatched     = errors.New("No locations have been added to the watch list")
+	ErrInvalidStateFile  = errors.New("State file exists and is not a regular file")
+	ErrAlreadyStarted    = errors.New("WatchManager already started")
+	ErrFailedSeek        = errors.New("Failed to seek to the start of the states file")
+	ErrNotFollowed       = errors.New("File is not being followed")
+	ErrFilterNotFound    = errors.New("No filter with that name is installed")
+	ErrDuplicateFilter   = errors.New("Filter duplicates an existing filter")
+	ErrInvalidOffset     = errors.New("Offset must not be negative")
+	ErrDoublestarRegex   = errors.New("Doublestar cannot be used with regular expression matches")
+	ErrTooManyOpenFiles  = errors.New("Out of file descriptors, raise the open file limit to follow")
+	ErrOffsetBeyondEOF   = errors.New("Offset is beyond the end of the file")
+	ErrNoBoundary        = errors.New("No record boundary between the offset and the end of the file")
+	ErrSnapUnsupported   = errors.New("Record boundaries can only be found for plain files using the line engine")
+	ErrDuplicateFollower = errors.New("File is already being followed")
+	ErrMissingState      = errors.New("Failed to find the state for a followed file")
+	ErrExpvarPublished   = errors.New("An expvar with that name is already published")
 )
 
+// WatchManager is the directory watcher that drives a FilterManager.  It watches the
+// directory of every added WatchConfig rather than individual files and maps
+// filesystem events onto the manager: creates and writes load files, removes drop
+// their followers, and renames are tracked by file id.  Subdirectories of recursive
+// configs are watched as they are created.  Callers that use a FilterManager on its
+// own have to deliver those events themselves.
 type WatchManager struct {
 	mtx        *sync.Mutex
 	fman       *FilterManager
@@ -39,6 +57,10 @@ type WatchManager struct {
 	watched    map[string][]WatchConfig
 	routineRet chan error
 	logger     ingest.IngestLogger
+	scan       ScanConfig
+	pollIvl    time.Duration
+	pollStop   chan struct{}
+	pollWg     *sync.WaitGroup
 }
 
 type WatchConfig struct {
@@ -48,10 +70,30 @@ type WatchConfig struct {
 	FileFilter string
 	Hnd        handler
 	Recursive  bool
+	Predicate  FilePredicate
+	// DisableRenameTracking treats renamed files as deleted, see FilterConfig
+	DisableRenameTracking bool
+	// StartAtEnd skips the existing content of files without a saved state, see FilterConfig
+	StartAtEnd bool
+	// InitialLines starts existing files without a saved state near the end, see FilterConfig
+	InitialLines int
 }
 
-func NewWatcher(stateFilePath string) (*WatchManager, error) {
-	fman, err := NewFilterManager(stateFilePath)
+// same reports whether two configs describe the same watch, function hooks
+// cannot be compared so we only check that both or neither have one
+func (c WatchConfig) same(o WatchConfig) bool {
+	return c.FollowerEngineConfig == o.FollowerEngineConfig &&
+		c.ConfigName == o.ConfigName && c.BaseDir == o.BaseDir &&
+		c.FileFilter == o.FileFilter && c.Hnd == o.Hnd &&
+		c.Recursive == o.Recursive && (c.Predicate == nil) == (o.Predicate == nil) &&
+		c.DisableRenameTracking == o.DisableRenameTracking && c.StartAtEnd == o.StartAtEnd &&
+		c.InitialLines == o.InitialLines
+}
+
+// NewWatcher creates a WatchManager with a FilterManager using the given state file
+// and options, call Add for each directory and then Start
+func NewWatcher(stateFilePath string, opts ...ManagerOption) (*WatchManager, error) {
+	fman, err := NewFilterManager(stateFilePath, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -73,6 +115,147 @@ func (wm *WatchManager) SetMaxFilesWatched(max int) {
 	wm.fman.SetMaxFilesWatched(max)
 }
 
+func (wm *WatchManager) SetMaxStateSize(max int64, mode PruneMode) {
+	wm.fman.SetMaxStateSize(max, mode)
+}
+
+func (wm *WatchManager) SetCloseTimeout(to time.Duration) {
+	wm.fman.SetCloseTimeout(to)
+}
+
+func (wm *WatchManager) SetDedupeHardlinks(v bool) {
+	wm.fman.SetDedupeHardlinks(v)
+}
+
+func (wm *WatchManager) SetDuplicateMode(mode DuplicateMode) {
+	wm.fman.SetDuplicateMode(mode)
+}
+
+func (wm *WatchManager) SetFirstMatchOnly(v bool) {
+	wm.fman.SetFirstMatchOnly(v)
+}
+
+func (wm *WatchManager) SetMaxOpenFollowers(max int) {
+	wm.fman.SetMaxOpenFollowers(max)
+}
+
+func (wm *WatchManager) SetRenameSearchDepth(depth int) {
+	wm.fman.SetRenameSearchDepth(depth)
+}
+
+func (wm *WatchManager) PublishExpvar(prefix string) error {
+	return wm.fman.PublishExpvar(prefix)
+}
+
+func (wm *WatchManager) SetMaxBytesPerSecond(n int) {
+	wm.fman.SetMaxBytesPerSecond(n)
+}
+
+func (wm *WatchManager) SetReadBufferSize(n int) error {
+	return wm.fman.SetReadBufferSize(n)
+}
+
+func (wm *WatchManager) SetPanicPolicy(p PanicPolicy) {
+	wm.fman.SetPanicPolicy(p)
+}
+
+func (wm *WatchManager) SetRetryPolicy(p RetryPolicy) {
+	wm.fman.SetRetryPolicy(p)
+}
+
+func (wm *WatchManager) SetDeadLetter(lh handler) {
+	wm.fman.SetDeadLetter(lh)
+}
+
+func (wm *WatchManager) ReplaceHandler(bname string, lh handler) error {
+	wm.mtx.Lock()
+	defer wm.mtx.Unlock()
+	if err := wm.fman.ReplaceHandler(bname, lh); err != nil {
+		return err
+	}
+	//keep the watch configs in step so adding the same watch again is still a no-op
+	for _, cfgs := range wm.watched {
+		for i := range cfgs {
+			if cfgs[i].ConfigName == bname {
+				cfgs[i].Hnd = lh
+			}
+		}
+	}
+	return nil
+}
+
+func (wm *WatchManager) SetSkipHidden(skip bool) {
+	wm.fman.SetSkipHidden(skip)
+}
+
+func (wm *WatchManager) SetIgnorePatterns(globs []string) error {
+	return wm.fman.SetIgnorePatterns(globs)
+}
+
+func (wm *WatchManager) SetIgnoreRegexes(exprs []string) error {
+	return wm.fman.SetIgnoreRegexes(exprs)
+}
+
+func (wm *WatchManager) SetRotationDetector(rd RotationDetector) {
+	wm.fman.SetRotationDetector(rd)
+}
+
+func (wm *WatchManager) SetRotationDrain(d time.Duration) {
+	wm.fman.SetRotationDrain(d)
+}
+
+func (wm *WatchManager) LoadFileAt(fpath string, offset int64) error {
+	return wm.fman.LoadFileAt(fpath, offset)
+}
+
+func (wm *WatchManager) PauseFollower(fpath string) error {
+	return wm.fman.PauseFollower(fpath)
+}
+
+func (wm *WatchManager) ResumeFollower(fpath string) error {
+	return wm.fman.ResumeFollower(fpath)
+}
+
+func (wm *WatchManager) PauseAll() error {
+	return wm.fman.PauseAll()
+}
+
+func (wm *WatchManager) ResumeAll() error {
+	return wm.fman.ResumeAll()
+}
+
+func (wm *WatchManager) ResetFollower(fpath string) error {
+	return wm.fman.ResetFollower(fpath)
+}
+
+func (wm *WatchManager) ResetAll() error {
+	return wm.fman.ResetAll()
+}
+
+func (wm *WatchManager) SeekFollower(fpath string, offset int64, snap bool) error {
+	return wm.fman.SeekFollower(fpath, offset, snap)
+}
+
+func (wm *WatchManager) Sync() error {
+	return wm.fman.Sync()
+}
+
+func (wm *WatchManager) WaitCaughtUp(ctx context.Context, name FileName) error {
+	return wm.fman.WaitCaughtUp(ctx, name)
+}
+
+func (wm *WatchManager) SetLockInstrumentation(v bool) {
+	wm.fman.SetLockInstrumentation(v)
+}
+
+func (wm *WatchManager) Stats() ManagerStats {
+	return wm.fman.Stats()
+}
+
+func (wm *WatchManager) Events() <-chan FollowerEvent {
+	return wm.fman.Events()
+}
+
 func (wm *WatchManager) SetLogger(lgr ingest.IngestLogger) {
 	wm.mtx.Lock()
 	defer wm.mtx.Unlock()
@@ -94,6 +277,51 @@ func (wm *WatchManager) Followers() int {
 	return wm.fman.Followed()
 }
 
+func (wm *WatchManager) UniqueFiles() int {
+	wm.mtx.Lock()
+	defer wm.mtx.Unlock()
+	if wm.fman == nil {
+		return 0
+	}
+	return wm.fman.UniqueFiles()
+}
+
+func (wm *WatchManager) OpenFollowers() int {
+	wm.mtx.Lock()
+	defer wm.mtx.Unlock()
+	if wm.fman == nil {
+		return 0
+	}
+	return wm.fman.OpenFollowers()
+}
+
+func (wm *WatchManager) FollowerStatuses() []FollowerStatus {
+	wm.mtx.Lock()
+	defer wm.mtx.Unlock()
+	if wm.fman == nil {
+		return nil
+	}
+	return wm.fman.FollowerStatuses()
+}
+
+func (wm *WatchManager) DuplicateFileIds() []FileId {
+	wm.mtx.Lock()
+	defer wm.mtx.Unlock()
+	if wm.fman == nil {
+		return nil
+	}
+	return wm.fman.DuplicateFileIds()
+}
+
+func (wm *WatchManager) FollowedByFilter() map[string]int {
+	wm.mtx.Lock()
+	defer wm.mtx.Unlock()
+	if wm.fman == nil {
+		return nil
+	}
+	return wm.fman.FollowedByFilter()
+}
+
 func (wm *WatchManager) Filters() int {
 	wm.mtx.Lock()
 	defer wm.mtx.Unlock()
@@ -104,6 +332,13 @@ func (wm *WatchManager) Filters() int {
 }
 
 func (wm *WatchManager) Close() error {
+	return wm.CloseContext(context.Background())
+}
+
+// CloseContext closes the watcher and the filter manager, see FilterManager.CloseContext
+func (wm *WatchManager) CloseContext(ctx context.Context) error {
+	//the poller takes the lock, so it has to be stopped before we grab it
+	wm.stopPoller()
 	var retCh chan error
 	wm.mtx.Lock()
 	if wm.watcher != nil {
@@ -126,7 +361,7 @@ func (wm *WatchManager) Close() error {
 	wm.mtx.Lock()
 	defer wm.mtx.Unlock()
 	if wm.fman != nil {
-		if err := wm.fman.Close(); err != nil {
+		if err := wm.fman.CloseContext(ctx); err != nil {
 			return err
 		}
 	}
@@ -169,7 +404,7 @@ func (wm *WatchManager) addNoLock(c WatchConfig) error {
 	} else {
 		doAdd = true
 		for _, e := range existing {
-			if e == c {
+			if e.same(c) {
 				doAdd = false
 				break
 			}
@@ -183,7 +418,17 @@ func (wm *WatchManager) addNoLock(c WatchConfig) error {
 		wm.watched[c.BaseDir] = append(wm.watched[c.BaseDir], c)
 	}
 
-	if err := wm.fman.AddFilter(c.ConfigName, c.BaseDir, fltrs, c.Hnd, c.FollowerEngineConfig); err != nil {
+	fcfg := FilterConfig{
+		FollowerEngineConfig:  c.FollowerEngineConfig,
+		BaseName:              c.ConfigName,
+		Location:              c.BaseDir,
+		Matches:               fltrs,
+		Predicate:             c.Predicate,
+		DisableRenameTracking: c.DisableRenameTracking,
+		StartAtEnd:            c.StartAtEnd,
+		InitialLines:          c.InitialLines,
+	}
+	if err := wm.fman.AddFilterConfig(fcfg, c.Hnd); err != nil {
 		return err
 	}
 	// Now add the subdirectories
@@ -241,32 +486,10 @@ func (wm *WatchManager) Start() error {
 	//then kick off routine watching for new files
 	wm.routineRet = make(chan error, 1)
 	go wm.routine(wm.routineRet)
-
-	return nil
-}
-
-func (wm *WatchManager) initExisting() error {
-	//ready all files in the directory, this COULD potentially be millions
-	//if someone is dumb enough to drop that many files for follwing in a single directory
-	//we will slow down and most likely puke when we attempt to register fsnotify handlers
-	//this is an OS/user problem, not ours
-	for k := range wm.watched {
-		fis, err := ioutil.ReadDir(k)
-		if err != nil {
-			return fmt.Errorf("Failed to initialize %v: %v", k, err)
-		}
-		for i := range fis {
-			if !fis[i].Mode().IsRegular() {
-				continue
-			}
-			//check if we have a state for this file
-			fpath := filepath.Join(k, fis[i].Name())
-			//potentially load existing state
-			if _, err := wm.fman.LoadFile(fpath); err != nil {
-				return err
-			}
-		}
+	if wm.pollIvl > 0 {
+		wm.startPoller(wm.pollIvl)
 	}
+
 	return nil
 }
 
@@ -313,23 +536,7 @@ watchRoutine:
 					continue
 				}
 				if fi.IsDir() {
-					parents, ok := wm.watched[filepath.Dir(evt.Name)]
-					if !ok {
-						wm.logger.Error("file_follower failed to find parent directory for %s", evt.Name)
-						continue
-					}
-					for _, parent := range parents {
-						if !parent.Recursive {
-							wm.logger.Info("file_follower not adding watcher for subdirectory %v: parent not recusive", evt.Name)
-							continue
-						}
-						parent.BaseDir = evt.Name
-						wm.logger.Info("file_follower adding watcher for subdirectory %v, patterns = %v", evt.Name, parent.FileFilter)
-						if err := wm.Add(parent); err != nil {
-							wm.logger.Error("file_follower failed to add watcher for new directory %v: %v", evt.Name, err)
-							continue
-						}
-					}
+					wm.watchSubdirectory(evt.Name)
 				} else {
 					if ok, err := wm.watchNewFile(evt.Name); err != nil {
 						wm.logger.Error("file_follower failed to watch new file %s due to %v", evt.Name, err)
@@ -366,6 +573,30 @@ watchRoutine:
 	errch <- err
 }
 
+// watchSubdirectory adds watches for a newly created directory on behalf of the
+// recursive configs watching its parent
+func (wm *WatchManager) watchSubdirectory(dir string) {
+	wm.mtx.Lock()
+	parents, ok := wm.watched[filepath.Dir(dir)]
+	wm.mtx.Unlock()
+	if !ok {
+		wm.logger.Error("file_follower failed to find parent directory for %s", dir)
+		return
+	}
+	for _, parent := range parents {
+		if !parent.Recursive {
+			wm.logger.Info("file_follower not adding watcher for subdirectory %v: parent not recusive", dir)
+			continue
+		}
+		parent.BaseDir = dir
+		wm.logger.Info("file_follower adding watcher for subdirectory %v, patterns = %v", dir, parent.FileFilter)
+		if err := wm.Add(parent); err != nil {
+			wm.logger.Error("file_follower failed to add watcher for new directory %v: %v", dir, err)
+			continue
+		}
+	}
+}
+
 // Returns a string containing information about the WatchManager
 func (wm *WatchManager) Dump() string {
 	var b strings.Builder
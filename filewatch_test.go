@@ -692,3 +692,144 @@ func (h *safeTrackingLH) HandleLog(b []byte, ts time.Time) error {
 func (h *safeTrackingLH) Len() int {
 	return len(h.mp)
 }
+
+func BenchmarkInitialScan(b *testing.B) {
+	root, err := ioutil.TempDir(tempPath, `scanned`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	//build a synthetic tree, none of the files match so we only measure the scan
+	for i := 0; i < 64; i++ {
+		dir := filepath.Join(root, fmt.Sprintf("dir%d", i))
+		if err := os.Mkdir(dir, 0770); err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < 256; j++ {
+			if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d.txt", j)), nil, 0660); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		sfp, err := newFileName()
+		if err != nil {
+			b.Fatal(err)
+		}
+		w, err := NewWatcher(sfp)
+		if err != nil {
+			b.Fatal(err)
+		}
+		w.SetScanConfig(ScanConfig{BatchSize: 128, Concurrency: 4})
+		watchCfg := WatchConfig{
+			ConfigName: bName,
+			BaseDir:    root,
+			FileFilter: `*.log`,
+			Hnd:        &countingLH{},
+			Recursive:  true,
+		}
+		if err := w.Add(watchCfg); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		if err := w.Start(); err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+		if err := w.Close(); err != nil {
+			b.Fatal(err)
+		}
+		os.RemoveAll(sfp)
+	}
+}
+
+func TestPollWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir(tempPath, `polled`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	wm, err := NewWatcher(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wc := WatchConfig{
+		ConfigName: bName,
+		BaseDir:    dir,
+		FileFilter: `app.log*`,
+		Hnd:        &orderedLH{},
+		Recursive:  true,
+	}
+	if err := wm.Add(wc); err != nil {
+		t.Fatal(err)
+	}
+	//notifications are never started, polling alone has to find everything
+	wm.mtx.Lock()
+	known := wm.nolockPollSnapshot()
+	wm.mtx.Unlock()
+	fpath := filepath.Join(dir, `app.log`)
+	rotated := filepath.Join(dir, `app.log.1`)
+	if err := ioutil.WriteFile(fpath, []byte("one\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if known = wm.poll(known); !wm.fman.IsWatched(fpath) {
+		t.Fatal("new file was not picked up")
+	}
+	//rotate by rename and recreate the log at the same path
+	if err := os.Rename(fpath, rotated); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(fpath, []byte("two\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if known = wm.poll(known); !wm.fman.IsWatched(fpath) || !wm.fman.IsWatched(rotated) {
+		t.Fatal("rotation was not tracked")
+	} else if n := wm.Followers(); n != 2 {
+		t.Fatalf("bad follower count %d", n)
+	}
+	//files in new subdirectories show up once the directory is watched
+	sub := filepath.Join(dir, `sub`)
+	if err := os.Mkdir(sub, 0770); err != nil {
+		t.Fatal(err)
+	}
+	subpath := filepath.Join(sub, `app.log`)
+	if err := ioutil.WriteFile(subpath, []byte("three\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	known = wm.poll(known)
+	if known = wm.poll(known); !wm.fman.IsWatched(subpath) {
+		t.Fatal("file in new subdirectory was not picked up")
+	}
+	if err := os.Remove(rotated); err != nil {
+		t.Fatal(err)
+	}
+	if known = wm.poll(known); wm.fman.IsWatched(rotated) {
+		t.Fatal("removed file is still followed")
+	} else if n := wm.Followers(); n != 2 {
+		t.Fatalf("bad follower count %d", n)
+	}
+	if err := wm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//the poller is started and stopped with the manager
+	if wm, err = NewWatcher(name); err != nil {
+		t.Fatal(err)
+	}
+	wm.SetPollInterval(10 * time.Millisecond)
+	if err := wm.Add(wc); err != nil {
+		t.Fatal(err)
+	} else if err := wm.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := wm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -9,14 +9,23 @@
 package filewatch
 
 import (
-	"encoding/gob"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gravwell/ingest/v3"
+	"golang.org/x/time/rate"
 )
 
 type filter struct {
@@ -24,9 +33,124 @@ type filter struct {
 	bname string //name given to the config file
 	loc   string //location we are watching
 	mtchs []string
+	pred  FilePredicate
 	lh    handler
+	sem   chan struct{} //shared by every follower of the filter, nil is unlimited
+	lim   *rate.Limiter //read limit shared by every follower of the filter, nil is unlimited
+	lin   *lineage      //rotation chain handling, nil for plain filters
+
+	noRename bool             //renamed files are treated as deleted and re-followed as new files
+	regex    bool             //matches are regular expressions rather than globs
+	res      []*regexp.Regexp //compiled matches when the filter uses regular expressions
+	fold     bool             //match base names without regard to case
+	rec      bool             //files in subdirectories of loc also match
+	excl     []string         //files matching any of these are skipped even if they match mtchs
+	exres    []*regexp.Regexp //compiled excludes when the filter uses regular expressions
+	deep     bool             //matches with a separator are doublestar globs against the path relative to loc
+	relPath  bool             //every match is applied to the path relative to loc rather than the base name
+	atEnd    bool             //existing files without a saved state start at their current size
+	initial  int              //existing files without a saved state start this many lines from the end
+	inherit  bool             //files renamed in from another filter keep the offset they reached there
+}
+
+// FilePredicate is an optional hook consulted before a file that matches a filter
+// is followed.  Returning false skips the file, returning an error aborts the launch.
+type FilePredicate func(fpath string) (bool, error)
+
+// FilterConfig describes a single filter installed on a FilterManager
+type FilterConfig struct {
+	FollowerEngineConfig
+	BaseName  string        //name given to the config
+	Location  string        //directory being watched
+	Matches   []string      //file globs matched against the base name
+	Predicate FilePredicate //optional, nil means every matching file is followed
+	Lineage   LineageConfig //optional, treat numbered or dated rotations of matched files as one source
+	// DisableRenameTracking skips the directory walk used to find where a renamed
+	// file went, a renamed file is treated as deleted and its new name (if it still
+	// matches) is followed as a brand new file from offset zero.  This is only a win
+	// for append only workloads that never rename files, otherwise renamed data is
+	// delivered twice.
+	DisableRenameTracking bool
+	// RegexMatches compiles Matches as regular expressions which are matched against
+	// the base name of files (or the relative path with MatchRelativePath), expressions
+	// are not anchored unless they use ^ and $.
+	RegexMatches bool
+	// CaseInsensitive matches base names without regard to case, for globs both the
+	// pattern and the base name are lowercased before matching.
+	CaseInsensitive bool
+	// Recursive also matches files in any subdirectory of Location, the matches are
+	// still applied to the base name only.  Recursive filters cannot use Lineage.
+	Recursive bool
+	// Excludes skips files that match the filter but also match any of these patterns,
+	// excludes use the same syntax and case handling as Matches and apply to rotated
+	// chain members as well as the head.
+	Excludes []string
+	// Doublestar allows Matches and Excludes to contain path separators and ** segments,
+	// such as app/**/*.log.  Patterns with a separator are matched against the path
+	// relative to Location using / as the separator, ** matches zero or more directories.
+	// Patterns without a separator still match the base name unless MatchRelativePath
+	// is set.  Doublestar filters reach
+	// into subdirectories the same way Recursive filters do and cannot use RegexMatches.
+	Doublestar bool
+	// MatchRelativePath applies every pattern in Matches and Excludes to the path of the
+	// file relative to Location rather than its base name, so tenantA/*.log only matches
+	// logs in the tenantA directory and *.log only matches files directly in Location.
+	// Relative paths always use / as the separator regardless of platform.  Globs use the
+	// path.Match syntax where * does not cross a separator, combine with Doublestar for
+	// ** segments.  Regular expressions see the same slash separated relative path.
+	// These filters reach into subdirectories the same way Recursive filters do.
+	//
+	// Without MatchRelativePath or Doublestar every pattern applies to the base name only.
+	MatchRelativePath bool
+	// StartAtEnd starts following existing files that have no saved state at their
+	// current size so only new lines are delivered.  Saved states always take precedence
+	// and files created while the manager is running are still read from the start.
+	StartAtEnd bool
+	// InitialLines starts following existing files that have no saved state at the
+	// beginning of their last InitialLines lines, like tail -n.  Files with fewer lines
+	// are read from the start, a trailing partial line counts as a line and is delivered
+	// once it is completed.  InitialLines takes precedence over StartAtEnd and follows
+	// the same rules about saved states and files created while running.
+	InitialLines int
+	// InheritRenamedOffset lets this filter take over a followed file that is renamed
+	// out of another filter and into this one at the offset the old follower reached,
+	// instead of reading it as a new file.  The old handler gets every record read before
+	// the rename and this handler every record after it, so records are routed by when
+	// they were read rather than by the name the file had when they were written.  The
+	// old follower is stopped immediately instead of draining.  Without it the file is
+	// read again under the new filter, from the start unless StartAtEnd or InitialLines
+	// apply, and records already delivered under the old name are delivered again.
+	InheritRenamedOffset bool
 }
 
+// PruneMode controls how aggressively states are dropped when the state file
+// grows past the configured maximum size
+type PruneMode int
+
+const (
+	PruneMissing    PruneMode = iota //drop states for files that no longer exist
+	PruneUnfollowed                  //drop every state that does not have an active follower
+)
+
+// DuplicateMode controls what AddFilter does with a filter whose cleaned location
+// and set of matches are identical to a filter that is already installed
+type DuplicateMode int
+
+const (
+	DuplicateAllow  DuplicateMode = iota //install it anyway, matching files are followed once per filter
+	DuplicateReject                      //return ErrDuplicateFilter
+	DuplicateMerge                       //keep the existing filter and have it deliver to both handlers
+)
+
+// MissingFilePolicy controls what happens to the saved state of a file that does not
+// exist when the manager is created
+type MissingFilePolicy int
+
+const (
+	MissingDrop   MissingFilePolicy = iota //drop the state, a file that shows up later is read from the start
+	MissingRetain                          //keep the state until the file shows up again at the same path
+)
+
 //a unique name that allows multiple IDs pointing at the same file
 type FileName struct {
 	BaseName string
@@ -34,39 +158,126 @@ type FileName struct {
 }
 
 type FilterManager struct {
-	mtx             *sync.Mutex
+	mtx             *statMutex
 	filters         []filter
 	followers       map[FileName]*follower
 	states          map[FileName]*int64
+	stateIds        map[FileName]FileId //id of the file each state belongs to, if known
+	stateHdrs       map[FileName]stateHeader
+	hdrCheck        bool
 	stateFile       string
 	stateFout       *os.File
+	codec           StateCodec
 	maxFilesWatched int
+	maxStateSize    int64
+	pruneMode       PruneMode
+	closeTimeout    time.Duration
+	dedupeLinks     bool
+	rotation        RotationDetector
+	rotationDrain   time.Duration
+	draining        map[*follower]FileName //retired followers still reading a rotated file
+	dupMode         DuplicateMode
+	firstMatch      bool
+	maxOpen         int //cap on followers holding open files, see SetMaxOpenFollowers
+	renameDepth     int //directory levels searched for renamed files, zero is unlimited
+	skipHidden      bool
+	ignores         []string               //globs checked against every base name before the filters
+	ignoreRes       []*regexp.Regexp       //expressions checked alongside ignores
+	idStrat         FileIdStrategy         //set at creation, never changes
+	pending         map[FileName]time.Time //states of files missing at creation and when they are dropped, zero is never
+	retry           RetryPolicy
+	deadLetter      handler
+	panics          PanicPolicy
+	trace           traceConfig
+	readLimit       *rate.Limiter //manager wide read limit, infinite unless SetMaxBytesPerSecond was called
+	readBuf         int           //read buffer size for filters that do not set one, zero is the default
+	paused          bool
+	persistFailed   bool
 	logger          ingest.IngestLogger
+	events          *eventBus
+	flushStop       chan struct{}
+	flushWg         *sync.WaitGroup
+	flushOnce       *sync.Once
+	parkStop        chan struct{}
+	parkWg          *sync.WaitGroup
+	parkOnce        *sync.Once
+	dirty           *dirtyFlag
+	counters        *ioCounters
+	autoResume      bool
 }
 
-func NewFilterManager(stateFile string) (*FilterManager, error) {
-	fout, states, err := initStateFile(stateFile)
+// NewFilterManager creates a manager persisting its states to stateFile, it is a thin
+// wrapper around NewFilterManagerWithOptions.
+func NewFilterManager(stateFile string, opts ...ManagerOption) (*FilterManager, error) {
+	return NewFilterManagerWithOptions(stateFile, opts...)
+}
+
+// NewFilterManagerWithOptions creates a manager persisting its states to stateFile.
+// Creation time behavior such as the logger, state codec, flush interval, state file
+// mode, and follower cap is configured with ManagerOption values, see options.go,
+// everything else is set with the Set methods afterwards.
+func NewFilterManagerWithOptions(stateFile string, opts ...ManagerOption) (*FilterManager, error) {
+	mc := newManagerConfig(opts)
+	fout, persisted, err := initStateFile(stateFile, mc)
 	if err != nil {
 		return nil, err
 	}
-	if err := cleanStates(states); err != nil {
+	missing, err := cleanStates(persisted, mc.idStrat, mc.missingGrace > 0 || mc.missingPolicy == MissingRetain, mc.hdrCheck)
+	if err != nil {
 		fout.Close()
 		return nil, err
 	}
+	states := make(map[FileName]*int64, len(persisted))
+	ids := make(map[FileName]FileId, len(persisted))
+	hdrs := map[FileName]stateHeader{}
+	for k, v := range persisted {
+		offset := v.Offset
+		states[k] = &offset
+		ids[k] = v.Id
+		if v.Header.Len > 0 {
+			hdrs[k] = stateHeader{FileHeader: v.Header, off: v.Offset}
+		}
+	}
 
-	return &FilterManager{
-		mtx:       &sync.Mutex{},
-		stateFile: stateFile,
-		stateFout: fout,
-		states:    states,
-		followers: map[FileName]*follower{},
-		logger:    ingest.NoLogger(),
-	}, nil
+	fm := &FilterManager{
+		mtx:        newStatMutex(),
+		stateFile:  stateFile,
+		stateFout:  fout,
+		codec:      mc.codec,
+		dirty:      newDirtyFlag(true), //startup cleaning may have dropped states
+		counters:   &ioCounters{},
+		states:     states,
+		stateIds:   ids,
+		stateHdrs:  hdrs,
+		hdrCheck:   mc.hdrCheck,
+		autoResume: mc.autoResume,
+		followers:  map[FileName]*follower{},
+		logger:     mc.logger,
+		events:     newEventBus(),
+		ignores:    append([]string(nil), DefaultIgnorePatterns...),
+		idStrat:    mc.idStrat,
+		trace:      mc.trace,
+		readLimit:  rate.NewLimiter(rate.Inf, 0),
+		pending:    make(map[FileName]time.Time, len(missing)),
+
+		maxFilesWatched: mc.maxFiles,
+	}
+	var deadline time.Time //retained states never expire
+	if mc.missingPolicy != MissingRetain {
+		deadline = time.Now().Add(mc.missingGrace)
+	}
+	for _, k := range missing {
+		fm.pending[k] = deadline
+	}
+	if mc.flushInterval > 0 {
+		fm.startFlusher(mc.flushInterval)
+	}
+	return fm, nil
 }
 
 func (f *FilterManager) IsWatched(fpath string) bool {
-	f.mtx.Lock()
-	defer f.mtx.Unlock()
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
 	for _, v := range f.filters {
 		//check if we have an active follower
 		stid := FileName{
@@ -87,6 +298,91 @@ func (fm *FilterManager) SetMaxFilesWatched(max int) {
 	fm.maxFilesWatched = max
 }
 
+// SetMaxBytesPerSecond caps the combined read rate of every follower of the manager,
+// it applies to running followers immediately.  Filters can set a tighter cap of their
+// own with MaxBytesPerSecond, zero removes the manager wide cap.
+func (fm *FilterManager) SetMaxBytesPerSecond(n int) {
+	fm.mtx.Lock()
+	defer fm.mtx.Unlock()
+	if n <= 0 {
+		fm.readLimit.SetLimit(rate.Inf)
+		return
+	}
+	fm.readLimit.SetBurst(n)
+	fm.readLimit.SetLimit(rate.Limit(n))
+}
+
+// SetReadBufferSize sets how many bytes followers read from their file at a time for
+// filters that do not set ReadBufferSize, zero restores DefaultReadBufferSize.  Only
+// followers started after the call use the new size.
+func (fm *FilterManager) SetReadBufferSize(n int) error {
+	if err := checkReadBufferSize(n); err != nil {
+		return err
+	}
+	fm.mtx.Lock()
+	defer fm.mtx.Unlock()
+	fm.readBuf = n
+	return nil
+}
+
+// SetRenameSearchDepth limits how far below a filter location the search for the new
+// name of a renamed file goes.  A depth of 1 searches only the location itself, 2 adds
+// its immediate subdirectories, and so on.  Zero, the default, searches the whole tree.
+func (fm *FilterManager) SetRenameSearchDepth(depth int) {
+	fm.mtx.Lock()
+	defer fm.mtx.Unlock()
+	fm.renameDepth = depth
+}
+
+// SetMaxStateSize sets a threshold on the size of the serialized states, when
+// a flush would exceed max bytes the states are pruned according to mode
+// before being written.  A max of zero disables compaction.
+func (fm *FilterManager) SetMaxStateSize(max int64, mode PruneMode) {
+	fm.mtx.Lock()
+	defer fm.mtx.Unlock()
+	fm.maxStateSize = max
+	fm.pruneMode = mode
+}
+
+// SetDedupeHardlinks controls whether a file reachable through multiple matching paths
+// (hardlinks) is followed once.  The first path loaded becomes the canonical follower and
+// later paths with the same FileId are skipped with an EventHardlinkSkipped event.  If the
+// canonical path is removed the other paths are not picked up until they are loaded again.
+func (fm *FilterManager) SetDedupeHardlinks(v bool) {
+	fm.mtx.Lock()
+	defer fm.mtx.Unlock()
+	fm.dedupeLinks = v
+}
+
+// SetDuplicateMode sets how filters that duplicate an installed filter are handled,
+// the default is DuplicateAllow.  A merged filter only contributes its handler, the
+// existing filter delivers each record to both handlers and the base name and settings
+// of the merged filter are dropped.  Merging fails if the handlers cannot be delivered
+// to in the same mode under the settings of the existing filter.
+func (fm *FilterManager) SetDuplicateMode(mode DuplicateMode) {
+	fm.mtx.Lock()
+	defer fm.mtx.Unlock()
+	fm.dupMode = mode
+}
+
+// SetFirstMatchOnly routes each file to only the first filter, in the order filters
+// were added, that matches and admits it.  By default a file is followed once for
+// every filter that matches it.  Files that are already followed by any filter are
+// not picked up again, including by renames and filters added later.
+func (fm *FilterManager) SetFirstMatchOnly(v bool) {
+	fm.mtx.Lock()
+	defer fm.mtx.Unlock()
+	fm.firstMatch = v
+}
+
+// Events returns a channel delivering manager and follower events, including the
+// start, stop, rename, and truncation of followers.  Events are never allowed to block
+// the manager, they are dropped and counted in ManagerStats if the channel is full,
+// so consumers must drain it; the channel is closed when the manager is closed.
+func (fm *FilterManager) Events() <-chan FollowerEvent {
+	return fm.events.channel()
+}
+
 func (fm *FilterManager) SetLogger(lgr ingest.IngestLogger) {
 	fm.mtx.Lock()
 	defer fm.mtx.Unlock()
@@ -131,18 +427,91 @@ func (fm *FilterManager) expungeOldFiles() error {
 	return nil
 }
 
-func (fm *FilterManager) Close() (err error) {
+// CloseError is returned by Close when one or more followers failed to shut down
+// or handlers failed to flush within the close timeout.  Abandoned followers are left
+// running, leaking their goroutine and file descriptor, so that shutdown is never
+// blocked by a wedged follower.  Records an abandoned follower had not handed off are
+// not lost, its state was not advanced past them so they are read again on restart.
+// Data buffered inside an unflushed handler is lost.
+type CloseError struct {
+	Closed    []FileName //followers that shut down
+	Abandoned []FileName //followers that did not shut down within the timeout
+	Unflushed []string   //base names of filters whose handler did not flush within the timeout
+	Err       error      //errors returned by the followers that did shut down and by handler flushes
+}
+
+func (ce *CloseError) Error() string {
+	var s string
+	if len(ce.Abandoned) > 0 || len(ce.Closed) > 0 {
+		s = fmt.Sprintf("abandoned %d of %d followers: %v", len(ce.Abandoned), len(ce.Abandoned)+len(ce.Closed), ce.Abandoned)
+	}
+	if len(ce.Unflushed) > 0 {
+		if s != `` {
+			s += ", "
+		}
+		s += fmt.Sprintf("handlers failed to flush: %v", ce.Unflushed)
+	}
+	if ce.Err != nil {
+		s += ": " + ce.Err.Error()
+	}
+	return s
+}
+
+func (ce *CloseError) Unwrap() error {
+	return ce.Err
+}
+
+// SetCloseTimeout sets the maximum amount of time Close will wait on followers to
+// drain and handlers to flush before abandoning them, a zero timeout waits forever.
+func (fm *FilterManager) SetCloseTimeout(to time.Duration) {
 	fm.mtx.Lock()
 	defer fm.mtx.Unlock()
+	fm.closeTimeout = to
+}
 
-	//we have to actually close followers
-	for _, v := range fm.followers {
-		if lerr := v.Close(); lerr != nil {
-			err = appendErr(err, lerr)
-		}
+func (fm *FilterManager) Close() error {
+	return fm.CloseContext(context.Background())
+}
+
+// CloseContext closes the manager like Close but also gives up once ctx is done.
+// Followers that have not shut down and handlers that have not flushed by then are
+// abandoned and reported in a CloseError, whichever of ctx and the close timeout ends
+// first wins.  States are written either way, abandoned followers have not advanced
+// their state past records they did not hand off.
+func (fm *FilterManager) CloseContext(ctx context.Context) (err error) {
+	//the flusher takes the lock, so it has to be stopped before we grab it
+	fm.stopFlusher()
+	fm.stopParker()
+	fm.mtx.Lock()
+	defer fm.mtx.Unlock()
+
+	//we have to actually close followers, each follower drains what it can before exiting
+	//and then handlers that buffer are flushed, all of it within the close timeout
+	if fm.closeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, fm.closeTimeout)
+		defer cancel()
 	}
+	err = fm.nolockCloseFollowers(ctx.Done())
 	fm.followers = nil
 
+	unflushed, ferr := fm.nolockFlushHandlers(ctx.Done())
+	if len(unflushed) > 0 {
+		ce, ok := err.(*CloseError)
+		if !ok {
+			ce = &CloseError{Err: err}
+		}
+		ce.Unflushed = unflushed
+		err = ce
+	}
+	if ferr != nil {
+		if ce, ok := err.(*CloseError); ok {
+			ce.Err = appendErr(ce.Err, ferr)
+		} else {
+			err = appendErr(err, ferr)
+		}
+	}
+
 	//just shitcan filters, no need to close anything
 	fm.filters = nil
 
@@ -153,25 +522,314 @@ func (fm *FilterManager) Close() (err error) {
 		return err
 	}
 	fm.stateFout = nil
+	fm.events.close()
+	return
+}
+
+type flushResult struct {
+	name string
+	err  error
+}
+
+// nolockFlushHandlers calls Flush on every filter handler that buffers records, the base
+// names of filters whose handler did not finish flushing before the deadline are returned
+//caller MUST HOLD THE LOCK
+func (fm *FilterManager) nolockFlushHandlers(deadline <-chan struct{}) (unflushed []string, err error) {
+	pending := map[string]int{}
+	resCh := make(chan flushResult, len(fm.filters))
+	for _, v := range fm.filters {
+		fl, ok := v.lh.(flusher)
+		if !ok {
+			continue
+		}
+		pending[v.bname]++
+		go func(name string, fl flusher) {
+			resCh <- flushResult{name: name, err: fl.Flush()}
+		}(v.bname, fl)
+	}
+	for len(pending) > 0 {
+		select {
+		case r := <-resCh:
+			if pending[r.name]--; pending[r.name] == 0 {
+				delete(pending, r.name)
+			}
+			if r.err != nil {
+				err = appendErr(err, r.err)
+			}
+		case <-deadline:
+			for k := range pending {
+				fm.logger.Error("Handler for %v failed to flush before the close deadline", k)
+				unflushed = append(unflushed, k)
+			}
+			return
+		}
+	}
+	return
+}
+
+type closeResult struct {
+	fl  *follower
+	err error
+}
+
+// nolockCloseFollowers closes all followers concurrently, any follower that has not
+// closed by the time the deadline fires is abandoned
+// caller MUST HOLD THE LOCK
+func (fm *FilterManager) nolockCloseFollowers(deadline <-chan struct{}) (err error) {
+	//followers still draining a rotated file are closed along with everything else
+	pending := make(map[*follower]FileName, len(fm.followers)+len(fm.draining))
+	for k, v := range fm.followers {
+		pending[v] = k
+	}
+	for v, k := range fm.draining {
+		pending[v] = k
+	}
+	fm.draining = nil
+	resCh := make(chan closeResult, len(pending))
+	for v := range pending {
+		go func(v *follower) {
+			resCh <- closeResult{fl: v, err: v.Close()}
+		}(v)
+	}
+	var closed []FileName
+	for len(pending) > 0 {
+		select {
+		case r := <-resCh:
+			closed = append(closed, pending[r.fl])
+			delete(pending, r.fl)
+			if r.err != nil {
+				err = appendErr(err, r.err)
+			}
+		case <-deadline:
+			ce := &CloseError{
+				Closed: closed,
+				Err:    err,
+			}
+			for _, k := range pending {
+				fm.logger.Error("Abandoning follower %v, failed to close before the close deadline", k)
+				ce.Abandoned = append(ce.Abandoned, k)
+			}
+			return ce
+		}
+	}
 	return
 }
 
 // Followed returns the current number of following handles
 // if a file matches multiple filters, it will be followed multiple
-// times.  So this is NOT the number of files, but the number of follows
+// times unless SetFirstMatchOnly is enabled.  So this is NOT the number
+// of files, but the number of follows
 func (fm *FilterManager) Followed() int {
-	fm.mtx.Lock()
-	defer fm.mtx.Unlock()
+	fm.mtx.RLock()
+	defer fm.mtx.RUnlock()
 	return len(fm.followers)
 }
 
+// UniqueFiles returns the number of distinct files being followed, a file
+// followed by several filters is only counted once
+func (fm *FilterManager) UniqueFiles() int {
+	fm.mtx.RLock()
+	defer fm.mtx.RUnlock()
+	paths := make(map[string]struct{}, len(fm.followers))
+	for k := range fm.followers {
+		paths[k.FilePath] = struct{}{}
+	}
+	return len(paths)
+}
+
+// FollowedByFilter returns the number of follows for each filter keyed on the filter
+// base name, filters without any followers are not included
+func (fm *FilterManager) FollowedByFilter() map[string]int {
+	fm.mtx.RLock()
+	defer fm.mtx.RUnlock()
+	r := make(map[string]int, len(fm.filters))
+	for k := range fm.followers {
+		r[k.BaseName]++
+	}
+	return r
+}
+
+// FollowerBinding describes which filter a follower is bound to
+type FollowerBinding struct {
+	Name     FileName
+	FilterId int
+	Filter   string //base name of the filter at FilterId, empty if out of range
+	Valid    bool   //FilterId is in range and points at a filter with our base name
+}
+
+// FollowerBindings returns the filter binding for every active follower, this is
+// a debugging aid for tracking filter index remapping.  Followers with an invalid
+// binding are also logged as a warning.
+func (fm *FilterManager) FollowerBindings() (r []FollowerBinding) {
+	fm.mtx.RLock()
+	defer fm.mtx.RUnlock()
+	for k, v := range fm.followers {
+		fb := FollowerBinding{
+			Name:     k,
+			FilterId: v.FilterId(),
+		}
+		if fb.FilterId >= 0 && fb.FilterId < len(fm.filters) {
+			fb.Filter = fm.filters[fb.FilterId].bname
+			fb.Valid = fb.Filter == k.BaseName
+		}
+		if !fb.Valid {
+			fm.logger.Warn("Follower %v has invalid filter id %d", k, fb.FilterId)
+		}
+		r = append(r, fb)
+	}
+	return
+}
+
+// FollowerStatus is a snapshot of a single follower
+type FollowerStatus struct {
+	Name      FileName
+	Id        FileId
+	FilterId  int
+	Offset    int64     //index of the last record accepted by the handler
+	LastRead  time.Time //when records were last handed off, or when the follower was started
+	LastError error     //last error that stopped the follower, nil if it never failed
+	Parked    bool      //the file is closed to stay under the open follower cap
+}
+
+// FollowerStatuses returns the status of every active follower, this backs debug
+// endpoints and helps diagnose followers that stopped making progress
+func (fm *FilterManager) FollowerStatuses() (r []FollowerStatus) {
+	fm.mtx.RLock()
+	defer fm.mtx.RUnlock()
+	r = make([]FollowerStatus, 0, len(fm.followers))
+	for k, v := range fm.followers {
+		fs := FollowerStatus{
+			Name:      k,
+			Id:        v.FileId(),
+			FilterId:  v.FilterId(),
+			LastRead:  time.Unix(0, atomic.LoadInt64(&v.lastAct)),
+			LastError: v.lastError(),
+			Parked:    v.isParked(),
+		}
+		if st, ok := fm.states[k]; ok && st != nil {
+			fs.Offset = atomic.LoadInt64(st)
+		}
+		r = append(r, fs)
+	}
+	return
+}
+
+// FollowerInfo is the read only view of a follower handed to ForEachFollower
+type FollowerInfo struct {
+	FilePath string
+	FilterId int
+	Offset   int64 //index of the last record accepted by the handler
+}
+
+// ForEachFollower calls fn for every active follower until fn returns false, nothing
+// is copied up front so it is cheap when looking for a single follower.  The manager
+// lock is held while iterating, fn must not call back into the manager.
+func (fm *FilterManager) ForEachFollower(fn func(FileName, FollowerInfo) bool) {
+	fm.mtx.RLock()
+	defer fm.mtx.RUnlock()
+	for k, v := range fm.followers {
+		fi := FollowerInfo{
+			FilePath: k.FilePath,
+			FilterId: v.FilterId(),
+			Offset:   v.offset(),
+		}
+		if !fn(k, fi) {
+			return
+		}
+	}
+}
+
+// WaitCaughtUp blocks until the follower for name has handed everything up to the
+// current size of its file to the handler.  The size is sampled when the call is made,
+// data written after that does not extend the wait.  ErrNotFollowed is returned if
+// the follower goes away before catching up.
+func (fm *FilterManager) WaitCaughtUp(ctx context.Context, name FileName) error {
+	fm.mtx.RLock()
+	flw, ok := fm.followers[name]
+	fm.mtx.RUnlock()
+	if !ok {
+		return ErrNotFollowed
+	}
+	fi, err := os.Stat(flw.FilePath)
+	if err != nil {
+		return err
+	}
+	target := fi.Size()
+
+	tckr := time.NewTicker(waitPollInterval)
+	defer tckr.Stop()
+	for {
+		if flw.offset() >= target {
+			return nil
+		}
+		fm.mtx.RLock()
+		curr, ok := fm.followers[name]
+		fm.mtx.RUnlock()
+		if !ok || curr != flw || (!flw.Running() && !flw.isParked()) {
+			return ErrNotFollowed
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tckr.C:
+		}
+	}
+}
+
+// ListFilters returns the configuration of every installed filter, in install order.
+// The returned configs are copies and can be modified freely.
+func (fm *FilterManager) ListFilters() []FilterConfig {
+	fm.mtx.RLock()
+	defer fm.mtx.RUnlock()
+	r := make([]FilterConfig, 0, len(fm.filters))
+	for _, v := range fm.filters {
+		fc := FilterConfig{
+			FollowerEngineConfig:  v.FollowerEngineConfig,
+			BaseName:              v.bname,
+			Location:              v.loc,
+			Matches:               append([]string(nil), v.mtchs...),
+			Predicate:             v.pred,
+			DisableRenameTracking: v.noRename,
+			RegexMatches:          v.regex,
+			CaseInsensitive:       v.fold,
+			Recursive:             v.rec,
+			Excludes:              append([]string(nil), v.excl...),
+			Doublestar:            v.deep,
+			MatchRelativePath:     v.relPath,
+			StartAtEnd:            v.atEnd,
+			InitialLines:          v.initial,
+			InheritRenamedOffset:  v.inherit,
+		}
+		if v.lin != nil {
+			fc.Lineage = v.lin.cfg
+		}
+		r = append(r, fc)
+	}
+	return r
+}
+
 // Filters returns the current number of installed filters
 func (fm *FilterManager) Filters() int {
-	fm.mtx.Lock()
-	defer fm.mtx.Unlock()
+	fm.mtx.RLock()
+	defer fm.mtx.RUnlock()
 	return len(fm.filters)
 }
 
+// OffsetSnapshot returns a copy of every tracked offset, including files that are no
+// longer followed but still have a state.  The values are copied so the snapshot
+// does not change as followers advance.
+func (fm *FilterManager) OffsetSnapshot() map[FileName]int64 {
+	fm.mtx.RLock()
+	defer fm.mtx.RUnlock()
+	r := make(map[FileName]int64, len(fm.states))
+	for k, v := range fm.states {
+		if v != nil {
+			r[k] = atomic.LoadInt64(v)
+		}
+	}
+	return r
+}
+
 // FlushStates flushes the current state of followed files to the disk
 // periodically flushing states is a good idea, incase the device crashes, or the process is abruptly killed
 func (fm *FilterManager) FlushStates() error {
@@ -180,12 +838,148 @@ func (fm *FilterManager) FlushStates() error {
 	return fm.nolockDumpStates()
 }
 
+// Sync writes the current states and forces them to disk, for checkpointing without
+// closing the manager.  os.ErrClosed is returned once the manager is closed.
+func (fm *FilterManager) Sync() error {
+	fm.mtx.Lock()
+	defer fm.mtx.Unlock()
+	if fm.stateFout == nil {
+		return os.ErrClosed
+	}
+	if err := fm.nolockDumpStates(); err != nil {
+		return err
+	}
+	return fm.stateFout.Sync()
+}
+
+// startFlusher kicks off a routine that writes the states every interval
+func (fm *FilterManager) startFlusher(interval time.Duration) {
+	fm.flushStop = make(chan struct{})
+	fm.flushWg = &sync.WaitGroup{}
+	fm.flushOnce = &sync.Once{}
+	fm.flushWg.Add(1)
+	go func() {
+		defer fm.flushWg.Done()
+		tckr := time.NewTicker(interval)
+		defer tckr.Stop()
+		for {
+			select {
+			case <-tckr.C:
+			case <-fm.flushStop:
+				return
+			}
+			fm.mtx.Lock()
+			if err := fm.nolockDumpStates(); err != nil {
+				fm.logger.Error("Failed to flush states to %v: %v", fm.stateFile, err)
+			}
+			fm.mtx.Unlock()
+		}
+	}()
+}
+
+// dirtyFlag tracks whether the states changed since they were last written
+type dirtyFlag struct {
+	v int32
+}
+
+func newDirtyFlag(v bool) *dirtyFlag {
+	df := &dirtyFlag{}
+	if v {
+		df.v = 1
+	}
+	return df
+}
+
+// set marks the states as changed, it is safe to call on a nil flag
+func (df *dirtyFlag) set() {
+	if df != nil {
+		atomic.StoreInt32(&df.v, 1)
+	}
+}
+
+// take clears the flag and returns whether it was set, a nil flag is always set
+func (df *dirtyFlag) take() bool {
+	if df == nil {
+		return true
+	}
+	return atomic.SwapInt32(&df.v, 0) != 0
+}
+
+// stopFlusher stops the background flush routine and waits for it to exit
+// the caller MUST NOT hold the lock
+func (fm *FilterManager) stopFlusher() {
+	if fm.flushStop == nil {
+		return
+	}
+	fm.flushOnce.Do(func() { close(fm.flushStop) })
+	fm.flushWg.Wait()
+}
+
+// replacedWhileMissing checks if a file that was missing at creation came back as a
+// different file or shorter than the saved offset
+// caller MUST HOLD THE LOCK
+func (fm *FilterManager) replacedWhileMissing(skey FileName, fpath string, id FileId, offset int64) bool {
+	if prev, ok := fm.stateIds[skey]; ok && prev != (FileId{}) && prev != id {
+		return true
+	}
+	fi, err := os.Stat(fpath)
+	return err == nil && fi.Size() < offset
+}
+
+// nolockExpirePending drops the states of files that were missing at creation and
+// did not come back within the grace period
+// caller MUST HOLD THE LOCK
+func (fm *FilterManager) nolockExpirePending() {
+	now := time.Now()
+	for k, deadline := range fm.pending {
+		if deadline.IsZero() || now.Before(deadline) {
+			continue
+		}
+		delete(fm.pending, k)
+		if _, err := os.Stat(k.FilePath); os.IsNotExist(err) {
+			delete(fm.states, k)
+			delete(fm.stateIds, k)
+			fm.dirty.set()
+		}
+	}
+}
+
 //nolockDumpStates pushes the current set of states out to a file
+//a failure to persist states emits a single EventStatePersistFailed, repeated
+//failures are not reported again until a flush succeeds
 //caller MUST HOLD THE LOCK
 func (fm *FilterManager) nolockDumpStates() error {
 	if fm.stateFout == nil {
 		return nil
 	}
+	fm.nolockExpirePending()
+	if !fm.dirty.take() {
+		return nil //nothing moved since the last write
+	}
+	err := fm.nolockWriteStates()
+	if err != nil {
+		fm.dirty.set() //try again on the next flush
+	}
+	if err != nil && !fm.persistFailed {
+		fm.persistFailed = true
+		fm.logger.Error("Failed to persist states to %v: %v", fm.stateFile, err)
+		fm.events.emit(FollowerEvent{
+			Type: EventStatePersistFailed,
+			Path: fm.stateFile,
+			Err:  err,
+		})
+	} else if err == nil && fm.persistFailed {
+		fm.persistFailed = false
+		fm.logger.Info("Recovered persisting states to %v", fm.stateFile)
+		fm.events.emit(FollowerEvent{
+			Type: EventStatePersistRecovered,
+			Path: fm.stateFile,
+		})
+	}
+	return err
+}
+
+func (fm *FilterManager) nolockWriteStates() error {
 	n, err := fm.stateFout.Seek(0, 0)
 	if err != nil {
 		return err
@@ -193,30 +987,368 @@ func (fm *FilterManager) nolockDumpStates() error {
 	if n != 0 {
 		return ErrFailedSeek
 	}
+	var bb bytes.Buffer
+	if err := encodeStates(&bb, fm.codec, fm.nolockPersistedStates()); err != nil {
+		return err
+	}
+	if fm.maxStateSize > 0 && int64(bb.Len()) > fm.maxStateSize {
+		if cnt := fm.nolockPruneStates(); cnt > 0 {
+			fm.logger.Info("Compacted %d states from %v", cnt, fm.stateFile)
+			fm.events.emit(FollowerEvent{
+				Type:  EventStateCompacted,
+				Count: cnt,
+			})
+			bb.Reset()
+			if err := encodeStates(&bb, fm.codec, fm.nolockPersistedStates()); err != nil {
+				return err
+			}
+		}
+	}
 	if err := fm.stateFout.Truncate(0); err != nil {
 		return err
 	}
-	if err := gob.NewEncoder(fm.stateFout).Encode(fm.states); err != nil {
+	if _, err := bb.WriteTo(fm.stateFout); err != nil {
 		return err
 	}
 	return nil
 }
 
+// nolockPersistedStates builds the set of states written to the state file, the id
+// of a followed file comes from its follower so renames and reopens are picked up
+// caller MUST HOLD THE LOCK
+func (fm *FilterManager) nolockPersistedStates() map[FileName]FileState {
+	active := make(map[*int64]FileId, len(fm.followers))
+	for _, v := range fm.followers {
+		active[v.state] = v.FileId()
+	}
+	r := make(map[FileName]FileState, len(fm.states))
+	for k, v := range fm.states {
+		st := FileState{
+			Id: fm.stateIds[k],
+		}
+		if v != nil {
+			st.Offset = atomic.LoadInt64(v)
+		}
+		if id, ok := active[v]; ok {
+			st.Id = id
+		}
+		r[k] = st
+	}
+	if fm.hdrCheck {
+		fm.nolockAttachHeaders(r)
+	}
+	return r
+}
+
+// nolockPruneStates drops states that are not attached to an active follower
+// according to the prune mode, returning the number of states removed
+// caller MUST HOLD THE LOCK
+func (fm *FilterManager) nolockPruneStates() (cnt int) {
+	active := make(map[*int64]bool, len(fm.followers))
+	for _, v := range fm.followers {
+		active[v.state] = true
+	}
+	for k, st := range fm.states {
+		if active[st] {
+			continue
+		}
+		if fm.pruneMode == PruneMissing {
+			p := k.FilePath
+			if dir, ok := lineageStateDir(k); ok {
+				p = dir
+			}
+			if _, err := os.Stat(p); err == nil || !os.IsNotExist(err) {
+				continue
+			}
+		}
+		delete(fm.states, k)
+		delete(fm.stateIds, k)
+		cnt++
+	}
+	return
+}
+
 func (f *FilterManager) AddFilter(bname, loc string, mtchs []string, lh handler, ecfg FollowerEngineConfig) error {
+	return f.AddFilterConfig(FilterConfig{
+		FollowerEngineConfig: ecfg,
+		BaseName:             bname,
+		Location:             loc,
+		Matches:              mtchs,
+	}, lh)
+}
+
+// AddFilterConfig installs a new filter using the full filter configuration
+func (f *FilterManager) AddFilterConfig(cfg FilterConfig, lh handler) error {
+	lin, err := newLineage(cfg.Lineage)
+	if err != nil {
+		return err
+	} else if err = cfg.FollowerEngineConfig.validate(); err != nil {
+		return err
+	} else if lin != nil && (cfg.Recursive || cfg.Doublestar || cfg.MatchRelativePath) {
+		return ErrRecursiveLineage
+	} else if cfg.RegexMatches && cfg.Doublestar {
+		return ErrDoublestarRegex
+	}
+	var res, exres []*regexp.Regexp
+	if cfg.RegexMatches {
+		if res, err = compileMatches(cfg.Matches, cfg.CaseInsensitive); err != nil {
+			return err
+		} else if exres, err = compileMatches(cfg.Excludes, cfg.CaseInsensitive); err != nil {
+			return err
+		}
+	}
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
+	if !cfg.RegexMatches {
+		//bad patterns never match, matching swallows the error so report it once here
+		for _, m := range badPatterns(cfg.Matches, cfg.Excludes) {
+			f.logger.Warn("Filter %v has invalid pattern %q which will never match", cfg.BaseName, m)
+		}
+	}
 
 	fltr := filter{
-		FollowerEngineConfig: ecfg,
-		bname:                bname,
-		loc:                  filepath.Clean(loc),
-		mtchs:                mtchs,
+		FollowerEngineConfig: cfg.FollowerEngineConfig,
+		bname:                cfg.BaseName,
+		loc:                  filepath.Clean(cfg.Location),
+		mtchs:                append([]string(nil), cfg.Matches...),
+		pred:                 cfg.Predicate,
 		lh:                   lh,
+		lin:                  lin,
+		noRename:             cfg.DisableRenameTracking,
+		regex:                cfg.RegexMatches,
+		res:                  res,
+		fold:                 cfg.CaseInsensitive,
+		rec:                  cfg.Recursive,
+		excl:                 append([]string(nil), cfg.Excludes...),
+		exres:                exres,
+		deep:                 cfg.Doublestar,
+		relPath:              cfg.MatchRelativePath,
+		atEnd:                cfg.StartAtEnd,
+		initial:              cfg.InitialLines,
+		inherit:              cfg.InheritRenamedOffset,
+	}
+	if cfg.MaxConcurrentHandlers > 0 {
+		fltr.sem = make(chan struct{}, cfg.MaxConcurrentHandlers)
+	}
+	if cfg.MaxBytesPerSecond > 0 {
+		fltr.lim = rate.NewLimiter(rate.Limit(cfg.MaxBytesPerSecond), cfg.MaxBytesPerSecond)
+	}
+	if f.dupMode != DuplicateAllow {
+		for i, v := range f.filters {
+			if !v.duplicates(fltr) {
+				continue
+			}
+			if f.dupMode == DuplicateReject {
+				return fmt.Errorf("%w: %v %v", ErrDuplicateFilter, fltr.loc, fltr.mtchs)
+			}
+			//the existing filter delivers to both handlers from now on
+			mlh, err := mergeHandlers(v.FollowerEngineConfig, v.lh, lh)
+			if err != nil {
+				return err
+			} else if err = f.nolockReplaceHandler([]int{i}, mlh); err != nil {
+				return err
+			}
+			f.logger.Info("Merged filter %v into duplicate filter %v", fltr.bname, v.bname)
+			return nil
+		}
 	}
 	f.filters = append(f.filters, fltr)
+	if f.autoResume {
+		return f.nolockResumeFilter(len(f.filters) - 1)
+	}
 	return nil
 }
 
+// RemoveFilter removes every filter with the given base name, their followers are
+// closed and their states dropped.  Followers of the remaining filters are renumbered
+// so they keep pointing at the right filter.
+func (f *FilterManager) RemoveFilter(bname string) (err error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	//build the old index to new index mapping, -1 is removed
+	remap := make([]int, len(f.filters))
+	kept := make([]filter, 0, len(f.filters))
+	for i, v := range f.filters {
+		if v.bname == bname {
+			remap[i] = -1
+			continue
+		}
+		remap[i] = len(kept)
+		kept = append(kept, v)
+	}
+	if len(kept) == len(f.filters) {
+		return ErrFilterNotFound
+	}
+	for k, flw := range f.followers {
+		id := flw.FilterId()
+		if id < 0 || id >= len(remap) {
+			continue
+		}
+		if remap[id] >= 0 {
+			flw.setFilterId(remap[id])
+			continue
+		}
+		delete(f.followers, k)
+		f.deleteState(k, flw.state)
+		f.events.emit(FollowerEvent{
+			Type: EventStopped,
+			Name: k,
+		})
+		if lerr := flw.Close(); lerr != nil {
+			err = appendErr(err, lerr)
+		}
+	}
+	f.filters = kept
+	return
+}
+
+// ReplaceHandler swaps the handler of every filter with the given base name without
+// restarting their followers.  Running followers switch to lh before their next record,
+// records already handed off or batched finish with the old handler so nothing is
+// reordered or read again.  The old handler is not flushed, it still belongs to the caller.
+func (f *FilterManager) ReplaceHandler(bname string, lh handler) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	var idxs []int
+	for i, v := range f.filters {
+		if v.bname == bname {
+			idxs = append(idxs, i)
+		}
+	}
+	if len(idxs) == 0 {
+		return ErrFilterNotFound
+	}
+	return f.nolockReplaceHandler(idxs, lh)
+}
+
+// nolockReplaceHandler swaps the handler of the filters at idxs and queues it on their
+// followers, nothing changes unless lh works with every one of the filters
+// caller MUST HOLD THE LOCK
+func (f *FilterManager) nolockReplaceHandler(idxs []int, lh handler) error {
+	modes := make(map[int]DeliveryMode, len(idxs))
+	for _, i := range idxs {
+		mode, err := handlerMode(f.filters[i].FollowerEngineConfig, lh)
+		if err != nil {
+			return err
+		}
+		modes[i] = mode
+	}
+	for i := range modes {
+		f.filters[i].lh = lh
+	}
+	for _, flw := range f.followers {
+		if mode, ok := modes[flw.FilterId()]; ok {
+			flw.setHandler(lh, mode)
+		}
+	}
+	return nil
+}
+
+// nolockResumeFilter starts followers for the files that already exist in the location
+// of a filter, files with a saved state pick up where they left off
+// caller MUST HOLD THE LOCK
+func (f *FilterManager) nolockResumeFilter(i int) error {
+	v := f.filters[i]
+	fpaths, err := f.existingFiles(v)
+	if err != nil {
+		return err
+	}
+	for _, fpath := range fpaths {
+		if _, ok := f.followers[FileName{BaseName: v.bname, FilePath: fpath}]; ok {
+			continue //already pulled in as an older member of a chain
+		} else if f.firstMatch && f.pathFollowed(fpath) {
+			continue //an earlier filter owns the file
+		}
+		id, err := f.fileId(fpath)
+		if err != nil {
+			return err
+		}
+		if v.lin != nil {
+			if err := f.launchOlderMembers(i, v, filepath.Base(fpath)); err != nil {
+				return err
+			}
+		}
+		if _, err := f.launchFollower(i, v, fpath, id, false, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// existingFiles returns the paths of regular files that currently match a filter,
+// recursive filters walk every subdirectory of the location
+func (f *FilterManager) existingFiles(v filter) (fpaths []string, err error) {
+	if !v.recursive() {
+		var fis []os.FileInfo
+		if fis, err = ioutil.ReadDir(v.loc); err != nil {
+			return
+		}
+		for _, fi := range fis {
+			if fpath := filepath.Join(v.loc, fi.Name()); fi.Mode().IsRegular() && f.pathMatch(v, fpath) {
+				fpaths = append(fpaths, fpath)
+			}
+		}
+		return
+	}
+	err = filepath.Walk(v.loc, func(fpath string, fi os.FileInfo, lerr error) error {
+		if lerr != nil {
+			f.walkWarn(v, fpath, lerr)
+			return nil
+		} else if fi == nil || !fi.Mode().IsRegular() {
+			return nil
+		}
+		if f.pathMatch(v, fpath) {
+			fpaths = append(fpaths, fpath)
+		}
+		return nil
+	})
+	return
+}
+
+// recursive returns true if the filter reaches into subdirectories of its location
+func (fl filter) recursive() bool {
+	return fl.rec || fl.deep || fl.relPath
+}
+
+// covers checks if files in directory dir fall under the location of the filter
+func (fl filter) covers(dir string) bool {
+	if fl.loc == dir {
+		return true
+	}
+	if !fl.recursive() {
+		return false
+	}
+	rel, err := filepath.Rel(fl.loc, dir)
+	return err == nil && rel != `..` && !strings.HasPrefix(rel, `..`+string(filepath.Separator))
+}
+
+// duplicates returns true if both filters watch the same location with the same set of matches
+func (fl filter) duplicates(o filter) bool {
+	if fl.loc != o.loc || fl.rec != o.rec || fl.deep != o.deep || fl.relPath != o.relPath {
+		return false
+	}
+	return sameSet(fl.mtchs, o.mtchs) && sameSet(fl.excl, o.excl)
+}
+
+// sameSet returns true if a and b hold the same strings regardless of order
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]int, len(a))
+	for _, m := range a {
+		set[m]++
+	}
+	for _, m := range b {
+		if set[m] == 0 {
+			return false
+		}
+		set[m]--
+	}
+	return true
+}
+
 func (f *FilterManager) RemoveFollower(fpath string) (bool, error) {
 	//get file path and base name
 	f.mtx.Lock()
@@ -224,7 +1356,155 @@ func (f *FilterManager) RemoveFollower(fpath string) (bool, error) {
 	return f.nolockRemoveFollower(fpath, true)
 }
 
+// PauseFollower stops reading the file at fpath for every filter following it.  The
+// file stays open and its state is kept, so renames are still tracked and
+// ResumeFollower picks up at the last record the handler accepted.  Paused followers
+// are torn down normally by RemoveFollower and Close.
+func (f *FilterManager) PauseFollower(fpath string) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.nolockForPath(fpath, (*follower).pause)
+}
+
+// ResumeFollower restarts reading a file paused by PauseFollower
+func (f *FilterManager) ResumeFollower(fpath string) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.nolockForPath(fpath, (*follower).resume)
+}
+
+// PauseAll pauses every follower for a maintenance window, see PauseFollower.  Files
+// can still be loaded and removed while paused but new followers start out paused.
+func (f *FilterManager) PauseAll() (err error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.paused = true
+	for _, fl := range f.followers {
+		//parked followers and followers whose file went away are not reading anyway
+		if lerr := fl.pause(); lerr != nil && lerr != ErrNotRunning {
+			err = appendErr(err, lerr)
+		}
+	}
+	return
+}
+
+// ResumeAll resumes every paused follower, including followers that were paused
+// individually with PauseFollower
+func (f *FilterManager) ResumeAll() (err error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.paused = false
+	for _, fl := range f.followers {
+		if lerr := fl.resume(); lerr != nil {
+			err = appendErr(err, lerr)
+		}
+	}
+	return
+}
+
+// Paused returns true between PauseAll and ResumeAll
+func (f *FilterManager) Paused() bool {
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+	return f.paused
+}
+
+// ResetFollower rewinds every follower of fpath to the start of the file so the whole
+// file is delivered again, files that are being written to are read through to the
+// current end as usual
+func (f *FilterManager) ResetFollower(fpath string) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.nolockForPath(fpath, func(fl *follower) error {
+		return fl.seek(0)
+	})
+}
+
+// ResetAll rewinds every follower to the start of its file, see ResetFollower
+func (f *FilterManager) ResetAll() (err error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	for _, fl := range f.followers {
+		if lerr := fl.seek(0); lerr != nil {
+			err = appendErr(err, lerr)
+		}
+	}
+	return
+}
+
+// SeekFollower moves every follower of fpath to offset, the next record delivered
+// starts there.  Offsets past the end of the file are rejected with ErrOffsetBeyondEOF.
+// With snap set an offset in the middle of a record is moved up to the start of the
+// next record so that no partial record is delivered, this is only supported for
+// plain files read with the line engine.
+func (f *FilterManager) SeekFollower(fpath string, offset int64, snap bool) error {
+	if offset < 0 {
+		return ErrInvalidOffset
+	}
+	fi, err := os.Stat(fpath)
+	if err != nil {
+		return err
+	} else if offset > fi.Size() {
+		return ErrOffsetBeyondEOF
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.nolockForPath(fpath, func(fl *follower) error {
+		off := offset
+		if snap {
+			var err error
+			if off, err = fl.nextBoundary(offset); err != nil {
+				return err
+			}
+		}
+		return fl.seek(off)
+	})
+}
+
+// nolockForPath calls fn on every follower of fpath, ErrNotFollowed is returned if
+// there are none
+// caller MUST HOLD THE LOCK
+func (f *FilterManager) nolockForPath(fpath string, fn func(*follower) error) (err error) {
+	var hit bool
+	for _, v := range f.filters {
+		fl, ok := f.followers[FileName{BaseName: v.bname, FilePath: fpath}]
+		if !ok {
+			continue
+		}
+		hit = true
+		if lerr := fn(fl); lerr != nil {
+			err = appendErr(err, lerr)
+		}
+	}
+	if !hit {
+		return ErrNotFollowed
+	}
+	return
+}
+
 func (f *FilterManager) nolockRemoveFollower(fpath string, purgeState bool) (removed bool, err error) {
+	if purgeState {
+		//the file is gone, say so once no matter how many filters were following it
+		var first FileName
+		var cnt int
+		for _, v := range f.filters {
+			stid := FileName{BaseName: v.bname, FilePath: fpath}
+			if _, ok := f.followers[stid]; ok {
+				if cnt == 0 {
+					first = stid
+				}
+				cnt++
+			}
+		}
+		if cnt > 0 {
+			f.events.emit(FollowerEvent{
+				Type:  EventDeleted,
+				Name:  first,
+				Path:  fpath,
+				Count: cnt,
+			})
+		}
+	}
 	//check filters
 	for _, v := range f.filters {
 		//check if we have an active follower
@@ -236,8 +1516,12 @@ func (f *FilterManager) nolockRemoveFollower(fpath string, purgeState bool) (rem
 		if ok {
 			delete(f.followers, stid)
 			if purgeState {
-				delete(f.states, stid)
+				f.deleteState(stid, fl.state)
 			}
+			f.events.emit(FollowerEvent{
+				Type: EventStopped,
+				Name: stid,
+			})
 			if err = fl.Close(); err != nil {
 				return
 			}
@@ -247,42 +1531,83 @@ func (f *FilterManager) nolockRemoveFollower(fpath string, purgeState bool) (rem
 	return
 }
 
+// errWalkDone stops a directory walk that found what it was looking for
+var errWalkDone = errors.New("walk complete")
+
 //walk the directory looking for files, pull the file ID and check if it matches the current file ID
-func (f *FilterManager) findFileId(base string, mtchs []string, id FileId) (p string, ok bool, err error) {
+//the walk stops at the first match or when ctx is done
+func (f *FilterManager) findFileId(ctx context.Context, v filter, id FileId) (p string, ok bool, err error) {
 	var lid FileId
+	base := v.loc
 	//walk the the directory
 	err = filepath.Walk(base, func(fpath string, fi os.FileInfo, lerr error) (rerr error) {
-		if lerr != nil || fi == nil || ok || !fi.Mode().IsRegular() {
+		if rerr = ctx.Err(); rerr != nil {
+			return
+		} else if lerr != nil {
+			f.walkWarn(v, fpath, lerr)
+			return
+		} else if fi == nil {
 			//is fi is nil then the file isn't there and we can continue
 			return
-		}
-
-		if filepath.Dir(fpath) != base {
+		} else if fi.IsDir() {
+			if f.renameDepth > 0 && fpath != base && walkDepth(base, fpath) >= f.renameDepth {
+				rerr = filepath.SkipDir
+			}
+			return
+		} else if !fi.Mode().IsRegular() {
 			return
 		}
 
 		//check if the file matches any filters
-		if f.matchFile(mtchs, filepath.Base(fpath)) {
+		if f.pathMatch(v, fpath) {
 			//matches the filter, see if it matches the ID
-			if lid, rerr = getFileIdFromName(fpath); rerr != nil {
+			if lid, rerr = f.fileId(fpath); rerr != nil {
 				return
 			}
 			if lid == id {
 				p = fpath
 				ok = true
+				rerr = errWalkDone
 			}
 		}
 		return
 	})
+	if err == errWalkDone {
+		err = nil
+	}
 	return
 }
 
+// walkDepth returns how many directory levels fpath is below base
+func walkDepth(base, fpath string) int {
+	rel, err := filepath.Rel(base, fpath)
+	if err != nil || rel == `.` {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// walkWarn reports a directory walk entry that could not be read, entries that
+// vanished during the walk are normal churn and are not reported
+func (f *FilterManager) walkWarn(v filter, fpath string, err error) {
+	if !os.IsNotExist(err) {
+		f.logger.Warn("Filter %v failed to walk %v: %v", v.bname, fpath, err)
+	}
+}
+
 // RenameFollower is designed to rename a file that is currently being followed
 // We first grab the file id that matches the given fpath
 // Then we scan the base directory for ALL files and attempt to match the fileId
 // if a match is found, we check if it matches the current filter, if not, we delete the follower
 // if it does, we update the name and leave.  If no match is found, we delete the follower
 func (f *FilterManager) RenameFollower(fpath string) error {
+	return f.RenameFollowerContext(context.Background(), fpath)
+}
+
+// RenameFollowerContext handles a rename like RenameFollower but gives up searching
+// for the new name once ctx is done.  An abandoned search leaves the follower alone
+// and returns the context error.
+func (f *FilterManager) RenameFollowerContext(ctx context.Context, fpath string) error {
 	//get file path and base name
 	stid := FileName{
 		FilePath: fpath,
@@ -303,6 +1628,15 @@ func (f *FilterManager) RenameFollower(fpath string) error {
 	if !hit {
 		return nil
 	}
+	f.dirty.set()
+	//a search by id could land on another file with the same id
+	if paths := f.idCollision(fpath, id); len(paths) > 0 {
+		f.logger.Warn("Followers of %v share file id %v with %v, not searching for its new name", paths, id, fpath)
+		if _, err := os.Stat(fpath); err == nil {
+			return nil //still there under the old name
+		}
+		return f.retirePath(fpath)
+	}
 	//check filters and their base locations to see if the file showed up anywhere else
 	var found bool
 	for i, v := range f.filters {
@@ -312,10 +1646,25 @@ func (f *FilterManager) RenameFollower(fpath string) error {
 		if !ok {
 			continue
 		}
+		if v.noRename {
+			//rename tracking is off, the old name is just gone
+			delete(f.followers, stid)
+			f.deleteState(stid, flw.state)
+			f.events.emit(FollowerEvent{
+				Type: EventStopped,
+				Name: stid,
+			})
+			if err := flw.Close(); err != nil {
+				return err
+			}
+			continue
+		}
 
 		//check base directory and pattern match
-		p, ok, err := f.findFileId(v.loc, v.mtchs, id)
-		if err != nil {
+		p, ok, err := f.findFileId(ctx, v, id)
+		if cerr := ctx.Err(); cerr != nil {
+			return cerr
+		} else if err != nil {
 			flw.Close()
 			delete(f.states, stid)
 			delete(f.followers, stid)
@@ -327,16 +1676,41 @@ func (f *FilterManager) RenameFollower(fpath string) error {
 			if p == fpath {
 				return nil
 			}
+			chg := RotationChange{
+				Name:    stid,
+				Id:      id,
+				NewPath: p,
+				Matches: true,
+			}
+			if f.rotationDetector().Detect(chg) == RotationRelease {
+				//the release is applied by checkRename when the new name is loaded
+				continue
+			}
 			//different filter but we must keep tracking
-			if flw.FilterId() != i {
+			if flw.FilterId() != i && f.firstMatch && f.pathFollowed(p) {
+				//another filter already owns the new name
+				delete(f.followers, stid)
+				f.deleteState(stid, flw.state)
+				f.events.emit(FollowerEvent{
+					Type: EventStopped,
+					Name: stid,
+				})
+				if err := flw.Close(); err != nil {
+					return err
+				}
+			} else if flw.FilterId() != i {
 				st, ok := f.states[stid]
 				if !ok {
 					flw.Close()
 					delete(f.followers, stid)
-					return errors.New("Failed to find old state")
+					return fmt.Errorf("%w on filter change for %v", ErrMissingState, stid.FilePath)
 				}
 				delete(f.followers, stid)
 				delete(f.states, stid)
+				f.events.emit(FollowerEvent{
+					Type: EventStopped,
+					Name: stid,
+				})
 				if err := flw.Close(); err != nil {
 					return err
 				}
@@ -355,23 +1729,47 @@ func (f *FilterManager) RenameFollower(fpath string) error {
 			} else if v.loc == p {
 				//just update the names
 				delete(f.followers, stid)
-				flw.FileName = stid
+				flw.rename(stid)
 				st, ok := f.states[stid]
 				if !ok {
 					flw.Close()
-					return errors.New("failed to find state on rename")
+					return fmt.Errorf("%w on rename of %v", ErrMissingState, stid.FilePath)
 				}
+				old := stid.FilePath
 				stid.FilePath = p
 				f.states[stid] = st
 				f.followers[stid] = flw
+				f.events.emit(FollowerEvent{
+					Type: EventRenamed,
+					Name: stid,
+					Path: old,
+				})
+				f.counters.rotation()
 				//return nil
 			}
 		}
 	}
-	//filename was never found, remove it
+	//filename was never found, the file was rotated away so retire its followers
 	if !found {
-		if _, err := f.nolockRemoveFollower(fpath, true); err != nil {
-			return err
+		return f.retirePath(fpath)
+	}
+	return nil
+}
+
+// retirePath retires the followers of fpath under every filter
+// Caller MUST HOLD THE LOCK
+func (f *FilterManager) retirePath(fpath string) error {
+	for _, v := range f.filters {
+		stid := FileName{
+			BaseName: v.bname,
+			FilePath: fpath,
+		}
+		if fl, ok := f.followers[stid]; ok {
+			delete(f.followers, stid)
+			f.deleteState(stid, fl.state)
+			if err := f.retire(stid, fl); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -386,47 +1784,91 @@ func (f *FilterManager) NewFollower(fpath string) (bool, error) {
 //addFollower gets a new follower, adds it to our list, and launches its routine
 //the caller MUST hold the lock
 func (f *FilterManager) addFollower(fcfg FollowerConfig) error {
-	f.expungeOldFiles()
+	if err := f.expungeOldFiles(); err != nil {
+		f.logger.Warn("Failed to expunge old files: %v", err)
+	}
 	stid := FileName{
 		BaseName: fcfg.BaseName,
 		FilePath: fcfg.FilePath,
 	}
-	id, err := getFileIdFromName(fcfg.FilePath)
+	id, err := f.fileId(fcfg.FilePath)
 	if err != nil {
 		return err
 	}
+	fcfg.bus = f.events
+	fcfg.dirty = f.dirty
+	fcfg.counters = f.counters
+	fcfg.idStrat = f.idStrat
+	fcfg.retry = f.retry
+	fcfg.dlq = f.deadLetter
+	fcfg.panics = f.panics
+	fcfg.logger = f.logger
+	fcfg.trace = f.trace
+	if fcfg.FilterID >= 0 && fcfg.FilterID < len(f.filters) {
+		fcfg.sem = f.filters[fcfg.FilterID].sem
+		fcfg.flim = f.filters[fcfg.FilterID].lim
+	}
+	fcfg.glim = f.readLimit
+	if fcfg.ReadBufferSize == 0 {
+		fcfg.ReadBufferSize = f.readBuf
+	}
 	if flw, ok := f.followers[stid]; ok {
 		if flw.FileId() != id {
 			//delete the old follower
 			delete(f.followers, stid)
 			delete(f.states, stid)
+			f.dirty.set()
 			if err := flw.Close(); err != nil {
 				return err
 			}
 		} else {
-			return errors.New("duplicate follower")
+			return ErrDuplicateFollower
 		}
 	}
+	f.nolockMakeRoom(1)
 	fl, err := NewFollower(fcfg)
 	if err != nil {
 		return err
 	}
-	if err := fl.Start(); err != nil {
+	if f.paused {
+		fl.paused = true //started by ResumeAll
+	} else if err := fl.Start(); err != nil {
+		f.logger.Warn("Failed to start follower on %v for filter %v: %v", fcfg.FilePath, fcfg.BaseName, err)
 		fl.Close()
 		return err
 	}
 	f.followers[stid] = fl
+	f.events.emit(FollowerEvent{
+		Type: EventStarted,
+		Name: stid,
+	})
 	return nil
 }
 
-//look for seek infor for the filename, caller MUST HOLD LOCK
-func (f *FilterManager) seekInfo(bname, fpath string) *int64 {
-	for k, v := range f.states {
-		if k.BaseName == bname && k.FilePath == fpath {
-			return v
+// deleteState drops the state for a follower, states that are not keyed on the
+// follower name are found by pointer
+// Caller MUST HOLD THE LOCK
+func (f *FilterManager) deleteState(k FileName, st *int64) {
+	f.dirty.set()
+	if v, ok := f.states[k]; ok && v == st {
+		delete(f.states, k)
+		delete(f.stateIds, k)
+		return
+	}
+	for sk, sv := range f.states {
+		if sv == st {
+			delete(f.states, sk)
+			delete(f.stateIds, sk)
 		}
 	}
-	return nil
+}
+
+//look for seek infor for the filename, caller MUST HOLD LOCK
+func (f *FilterManager) seekInfo(bname, fpath string) *int64 {
+	return f.states[FileName{
+		BaseName: bname,
+		FilePath: fpath,
+	}]
 }
 
 func (f *FilterManager) addSeekInfo(bname, fpath string) *int64 {
@@ -436,58 +1878,233 @@ func (f *FilterManager) addSeekInfo(bname, fpath string) *int64 {
 	}
 	si := new(int64)
 	f.states[stid] = si
+	f.dirty.set()
 	return si
 }
 
 //actually kick off the file follower
 func (f *FilterManager) launchFollowers(fpath string, deleteState bool) (ok bool, err error) {
 	//get ID
-	id, err := getFileIdFromName(fpath)
+	id, err := f.fileId(fpath)
 	if err != nil {
 		return false, err
 	}
 
+	//check if this is just another link to a file we are already following
+	if f.dedupeLinks {
+		if canon, ok := f.linkedFollower(fpath, id); ok {
+			f.logger.Info("Skipping %v, it is a link to followed file %v", fpath, canon.FilePath)
+			f.events.emit(FollowerEvent{
+				Type: EventHardlinkSkipped,
+				Name: canon,
+				Path: fpath,
+			})
+			return false, nil
+		}
+	}
+
 	//check if this is just a renaming
-	isRename, err := f.checkRename(fpath, id)
+	isRename, released, carry, err := f.checkRename(fpath, id)
 	if err != nil {
 		return false, err
 	} else if isRename {
 		return true, nil //just a file renaming, continue
+	} else if released {
+		return false, nil //rotation detector released the file
+	}
+
+	if f.firstMatch && f.pathFollowed(fpath) {
+		return false, nil //some filter already owns the file
 	}
 
-	//get base dir
 	fname := filepath.Base(fpath)
-	fdir := filepath.Dir(fpath)
-	var si *int64
 
 	//swing through all filters and launch a follower for each one that matches
 	for i, v := range f.filters {
 		//check base directory and pattern match
-		if v.loc != fdir || !f.matchFile(v.mtchs, fname) {
+		if !f.pathMatch(v, fpath) {
 			continue
 		}
-		si = nil
-		if !deleteState {
-			//see if we have state information for this file
-			si = f.seekInfo(v.bname, fpath)
+		if v.lin != nil {
+			//rotation chains launch any older unfollowed members first so they are read in order
+			if err = f.launchOlderMembers(i, v, fname); err != nil {
+				return false, err
+			}
 		}
-		//if not add it
-		if si == nil {
-			si = f.addSeekInfo(v.bname, fpath)
+		var launched bool
+		if launched, err = f.launchFollower(i, v, fpath, id, deleteState, carry); err != nil {
+			return false, err
+		} else if launched {
+			ok = true
+			if f.firstMatch {
+				break
+			}
 		}
-		fcfg := FollowerConfig{
-			FollowerEngineConfig: v.FollowerEngineConfig,
-			BaseName:             v.bname,
-			FilePath:             fpath,
-			State:                si,
-			FilterID:             i,
-			Handler:              v.lh,
+	}
+	return
+}
+
+// pathFollowed returns true if any filter has a follower on fpath
+// Caller MUST HOLD THE LOCK
+func (f *FilterManager) pathFollowed(fpath string) bool {
+	for _, v := range f.filters {
+		if _, ok := f.followers[FileName{BaseName: v.bname, FilePath: fpath}]; ok {
+			return true
 		}
-		if err := f.addFollower(fcfg); err != nil {
+	}
+	return false
+}
+
+// launchFollower starts a follower for a file that matched filter v, carry is the offset
+// reached by a follower in another filter the file was just renamed out of, if any
+// Caller MUST HOLD THE LOCK
+func (f *FilterManager) launchFollower(i int, v filter, fpath string, id FileId, deleteState bool, carry *int64) (bool, error) {
+	if v.pred != nil {
+		if admit, err := v.pred(fpath); err != nil {
 			return false, err
+		} else if !admit {
+			return false, nil
+		}
+	}
+	fcfg := FollowerConfig{
+		FollowerEngineConfig: v.FollowerEngineConfig,
+		BaseName:             v.bname,
+		FilePath:             fpath,
+		FilterID:             i,
+		Handler:              v.lh,
+	}
+	skey := f.stateKey(v, fpath, id)
+	if v.lin != nil {
+		//chain members wait on the member rotated out before them
+		head, pos, _ := v.lin.member(f.headMatcher(v), filepath.Base(fpath))
+		fcfg.gate = f.lineageGate(i, v, head, pos)
+	}
+	if !deleteState {
+		//see if we have state information for this file
+		fcfg.State = f.seekInfo(skey.BaseName, skey.FilePath)
+	}
+	if _, ok := f.pending[skey]; ok {
+		//the file came back, make sure it is the same file and was not truncated
+		delete(f.pending, skey)
+		if fcfg.State != nil && (f.replacedWhileMissing(skey, fpath, id, *fcfg.State) || f.nolockHeaderChanged(skey, fpath, fcfg.State)) {
+			*fcfg.State = 0
+			f.dirty.set()
+		}
+	}
+	//if not add it
+	if fcfg.State == nil {
+		fcfg.State = f.addSeekInfo(skey.BaseName, skey.FilePath)
+		if carry != nil && v.inherit && !deleteState {
+			*fcfg.State = *carry
+		} else if v.initial > 0 && !deleteState {
+			delim, err := parseDelimiter(v.Delimiter)
+			if err != nil {
+				return false, err
+			}
+			off, err := tailOffset(fpath, v.initial, delim)
+			if err != nil {
+				return false, err
+			}
+			*fcfg.State = off
+		} else if v.atEnd && !deleteState {
+			fi, err := os.Stat(fpath)
+			if err != nil {
+				return false, err
+			}
+			*fcfg.State = fi.Size()
+		}
+	} else if v.lin != nil {
+		//ids can be reused after a member is deleted, a state past the end is stale
+		if fi, err := os.Stat(fpath); err == nil && fi.Size() < *fcfg.State {
+			*fcfg.State = 0
+			f.dirty.set()
+		}
+	}
+	if err := f.addFollower(fcfg); err != nil {
+		return false, err
+	}
+	f.stateIds[skey] = id
+	return true, nil
+}
+
+// stateKey returns the key the state for fpath is stored under in filter v,
+// rotation chain members are tracked by id rather than path
+func (f *FilterManager) stateKey(v filter, fpath string, id FileId) FileName {
+	if v.lin != nil {
+		head, _, _ := v.lin.member(f.headMatcher(v), filepath.Base(fpath))
+		return lineageStateKey(v, head, id)
+	}
+	return FileName{
+		BaseName: v.bname,
+		FilePath: fpath,
+	}
+}
+
+// launchOlderMembers starts followers for members of a rotation chain that are older
+// than fname and are not being followed yet, oldest first
+// Caller MUST HOLD THE LOCK
+func (f *FilterManager) launchOlderMembers(i int, v filter, fname string) error {
+	head, pos, _ := v.lin.member(f.headMatcher(v), fname)
+	mbrs, err := f.olderMembers(v, head, pos)
+	if err != nil {
+		return err
+	}
+	for _, m := range mbrs {
+		id, err := f.fileId(m.path)
+		if err != nil {
+			return err
+		}
+		if f.followedId(id) {
+			continue //renamed follower that has not been re-keyed yet
+		}
+		if _, err := f.launchFollower(i, v, m.path, id, false, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// followedId returns true if any follower is reading the file with the given id
+// Caller MUST HOLD THE LOCK
+func (f *FilterManager) followedId(id FileId) bool {
+	for _, v := range f.followers {
+		if v.FileId() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// linkedFollower looks for a follower of the same file under a different path that
+// still exists, which means fpath is a link rather than a rename
+// Caller MUST HOLD THE LOCK
+func (f *FilterManager) linkedFollower(fpath string, id FileId) (FileName, bool) {
+	for k, v := range f.followers {
+		if v.FileId() != id || k.FilePath == fpath {
+			continue
+		}
+		if lid, err := f.fileId(k.FilePath); err == nil && lid == id {
+			return k, true
 		}
-		ok = true
 	}
+	return FileName{}, false
+}
+
+// idCollision returns the paths of followers that report id but are still on disk under
+// their own name with that id, so a file at fpath with the same id is a different file
+// Caller MUST HOLD THE LOCK
+func (f *FilterManager) idCollision(fpath string, id FileId) (paths []string) {
+	seen := map[string]bool{}
+	for k, v := range f.followers {
+		if v.FileId() != id || k.FilePath == fpath || seen[k.FilePath] {
+			continue
+		}
+		seen[k.FilePath] = true
+		if lid, err := f.fileId(k.FilePath); err == nil && lid == id {
+			paths = append(paths, k.FilePath)
+		}
+	}
+	sort.Strings(paths)
 	return
 }
 
@@ -498,41 +2115,204 @@ func (f *FilterManager) launchFollowers(fpath string, deleteState bool) (ok bool
 //if
 //we update the state base name and close out the follower.  If it match
 //Caller MUST HOLD THE LOCK
-func (f *FilterManager) checkRename(fpath string, id FileId) (isRename bool, err error) {
-	var fname string
-	var fdir string
+func (f *FilterManager) checkRename(fpath string, id FileId) (isRename, released bool, carry *int64, err error) {
+	if paths := f.idCollision(fpath, id); len(paths) > 0 {
+		//the old name is still there, this is a different file that reports the same id
+		f.logger.Warn("%v shares file id %v with followed files %v, treating it as a new file", fpath, id, paths)
+		return
+	}
 	for k, v := range f.followers {
-		var removeFollower bool
-		if v.FileId() == id {
-			fname = filepath.Base(fpath)
-			fdir = filepath.Dir(fpath)
-			//check if the new name still matches the filter
-			filterId := v.FilterId()
-			if filterId >= len(f.filters) || filterId < 0 {
-				//filter outside of range, delete the follower
-				removeFollower = true
-			}
+		if v.FileId() != id {
+			continue
+		}
+		f.dirty.set()
+		if filterId := v.FilterId(); filterId >= 0 && filterId < len(f.filters) && f.filters[filterId].noRename {
+			continue //the new name is treated as a new file
+		}
+		//check if the new name still matches the filter
+		chg := RotationChange{
+			Name:    k,
+			Id:      id,
+			NewPath: fpath,
+		}
+		if filterId := v.FilterId(); filterId >= 0 && filterId < len(f.filters) {
 			//check the filter glob against the new name
-			if f.filters[filterId].loc == fdir && f.matchFile(f.filters[filterId].mtchs, fname) {
-				//this is just a rename, update the fpath in the follower
+			chg.Matches = f.pathMatch(f.filters[filterId], fpath)
+		}
+		act := f.rotationDetector().Detect(chg)
+		if act == RotationFollow && chg.Matches {
+			//this is just a rename, update the fpath in the follower
+			//states that are not keyed on the path (rotation chains) stay put
+			pathState := f.states[k] == v.state
+			if pathState {
 				delete(f.states, k)
-				delete(f.followers, k)
-				k.FilePath = fpath
-				v.FilePath = fpath
+			}
+			delete(f.followers, k)
+			k.FilePath = fpath
+			v.rename(k)
+			if pathState {
 				f.states[k] = v.state
-				f.followers[k] = v
-				isRename = true
-			} else {
-				removeFollower = true
+				f.stateIds[k] = id
 			}
-			if removeFollower {
-				//this is a move away from the current filter, so delete the follower
-				//and delete the state
-				if err = v.Close(); err != nil {
-					return
-				}
-				delete(f.states, k)
-				delete(f.followers, k)
+			f.followers[k] = v
+			f.events.emit(FollowerEvent{
+				Type: EventRenamed,
+				Name: k,
+				Path: chg.Name.FilePath,
+			})
+			f.counters.rotation()
+			isRename = true
+			continue
+		}
+		//this is a move away from the current filter or the detector released it
+		//so delete the follower and delete the state
+		f.deleteState(k, v.state)
+		delete(f.followers, k)
+		if act == RotationRelease {
+			if err = f.retire(k, v); err != nil {
+				return
+			}
+			released = true //released files are not picked back up under the new name
+			continue
+		} else if !f.inheritTarget(fpath) {
+			if err = f.retire(k, v); err != nil {
+				return
+			}
+			continue
+		}
+		//another filter takes over where this follower stopped
+		f.events.emit(FollowerEvent{
+			Type: EventStopped,
+			Name: k,
+		})
+		f.counters.rotation()
+		if err = v.Close(); err != nil {
+			return
+		}
+		if off := v.offset(); carry == nil || off > *carry {
+			carry = &off
+		}
+	}
+	return
+}
+
+// inheritTarget returns whether a filter that takes over renamed files matches fpath
+// Caller MUST HOLD THE LOCK
+func (f *FilterManager) inheritTarget(fpath string) bool {
+	for _, v := range f.filters {
+		if v.inherit && f.pathMatch(v, fpath) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathMatch checks if the file at fpath falls under the location of filter v and matches it
+func (f *FilterManager) pathMatch(v filter, fpath string) bool {
+	if f.ignored(fpath) || !v.covers(filepath.Dir(fpath)) {
+		return false
+	} else if !v.deep && !v.relPath {
+		return f.filterMatch(v, filepath.Base(fpath))
+	}
+	rel, err := filepath.Rel(v.loc, fpath)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	return f.relMatchAny(v, v.mtchs, v.res, rel) && !f.relMatchAny(v, v.excl, v.exres, rel)
+}
+
+// relMatchAny checks a slash separated path relative to the filter location against a
+// set of patterns, unless the filter matches relative paths patterns without a
+// separator are checked against the base name
+func (f *FilterManager) relMatchAny(v filter, mtchs []string, res []*regexp.Regexp, rel string) bool {
+	if v.regex {
+		return f.matchAny(v, mtchs, res, rel)
+	}
+	if v.fold {
+		rel = strings.ToLower(rel)
+	}
+	base := path.Base(rel)
+	for _, m := range mtchs {
+		if v.fold {
+			m = strings.ToLower(m)
+		}
+		var ok bool
+		if !v.relPath && !strings.Contains(m, `/`) {
+			ok, _ = path.Match(m, base)
+		} else if v.deep {
+			ok = doublestarMatch(m, rel)
+		} else {
+			ok, _ = path.Match(m, rel)
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// headMatch checks a base name against the matches of a filter, ignoring rotation chains
+func (f *FilterManager) headMatch(v filter, fname string) bool {
+	return f.matchAny(v, v.mtchs, v.res, fname)
+}
+
+// excluded checks a base name against the excludes of a filter
+func (f *FilterManager) excluded(v filter, fname string) bool {
+	return len(v.excl) > 0 && f.matchAny(v, v.excl, v.exres, fname)
+}
+
+// matchAny checks a base name against a set of patterns using the match mode of filter v
+func (f *FilterManager) matchAny(v filter, mtchs []string, res []*regexp.Regexp, fname string) bool {
+	if v.regex {
+		for _, re := range res {
+			if re.MatchString(fname) {
+				return true
+			}
+		}
+		return false
+	}
+	if v.fold {
+		fname = strings.ToLower(fname)
+		for _, m := range mtchs {
+			if ok, err := filepath.Match(strings.ToLower(m), fname); err == nil && ok {
+				return true
+			}
+		}
+		return false
+	}
+	return f.matchFile(mtchs, fname)
+}
+
+// compileMatches compiles regular expression matches, fold makes them case insensitive
+func compileMatches(mtchs []string, fold bool) (res []*regexp.Regexp, err error) {
+	for _, m := range mtchs {
+		expr := m
+		if fold {
+			expr = `(?i)` + m
+		}
+		var re *regexp.Regexp
+		if re, err = regexp.Compile(expr); err != nil {
+			return nil, fmt.Errorf("Invalid match %q: %v", m, err)
+		}
+		res = append(res, re)
+	}
+	return
+}
+
+// headMatcher binds headMatch to a filter
+func (f *FilterManager) headMatcher(v filter) func(string) bool {
+	return func(fname string) bool {
+		return f.headMatch(v, fname)
+	}
+}
+
+// badPatterns returns the glob patterns that are malformed
+func badPatterns(sets ...[]string) (r []string) {
+	for _, mtchs := range sets {
+		for _, m := range mtchs {
+			if _, err := filepath.Match(m, ``); err != nil {
+				r = append(r, m)
 			}
 		}
 	}
@@ -560,14 +2340,184 @@ func (f *FilterManager) LoadFile(fpath string) (bool, error) {
 	return ok, nil
 }
 
+// LoadFileAt (re)starts following fpath at the given byte offset for every filter that
+// matches it, existing followers for the path are stopped first.  An offset past the
+// end of the file is handled the same way a stale state is on startup, the file is
+// assumed to have been truncated and is read from the start.  ErrNotFollowed is
+// returned if no filter picked the file up.
+func (f *FilterManager) LoadFileAt(fpath string, offset int64) error {
+	if offset < 0 {
+		return ErrInvalidOffset
+	}
+	fi, err := os.Stat(fpath)
+	if err != nil {
+		return err
+	}
+	if offset > fi.Size() {
+		offset = 0
+	}
+	id, err := f.fileId(fpath)
+	if err != nil {
+		return err
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if _, err = f.nolockRemoveFollower(fpath, false); err != nil {
+		return err
+	}
+	//seed the states so the followers pick them up
+	for _, v := range f.filters {
+		if !f.pathMatch(v, fpath) {
+			continue
+		}
+		skey := f.stateKey(v, fpath, id)
+		st := f.seekInfo(skey.BaseName, skey.FilePath)
+		if st == nil {
+			st = f.addSeekInfo(skey.BaseName, skey.FilePath)
+		}
+		atomic.StoreInt64(st, offset)
+		f.stateIds[skey] = id
+		f.dirty.set()
+	}
+	ok, err := f.launchFollowers(fpath, false)
+	if err != nil {
+		return err
+	} else if !ok {
+		return ErrNotFollowed
+	}
+	return nil
+}
+
+// ScanExisting starts following every file that already exists in the location of
+// an installed filter, picking up at the saved offsets.  Files that are already
+// followed are left alone, so it is safe to call while a watcher is also loading
+// files.  A filter that fails to scan does not stop the rest, see also WithAutoResume.
+func (f *FilterManager) ScanExisting() (err error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	for i := range f.filters {
+		if lerr := f.nolockResumeFilter(i); lerr != nil {
+			err = appendErr(err, lerr)
+		}
+	}
+	return
+}
+
+// LoadFiles loads a set of files while taking the lock once for the whole batch,
+// which keeps lock churn down during startup scans and large rotations.  A file that
+// fails to load does not stop the rest, the errors of every file that failed are
+// returned together and can be matched with errors.Is.  Files that could not be
+// followed because the process ran out of file descriptors are reported with
+// ErrTooManyOpenFiles.  Launches are serialized on the manager lock so there is no
+// launch concurrency to tune, use SetMaxOpenFollowers to bound the descriptors a
+// large batch can hold open.
+func (f *FilterManager) LoadFiles(fpaths []string) (err error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	for _, p := range fpaths {
+		if _, lerr := f.launchFollowers(p, false); lerr != nil {
+			err = appendErr(err, launchError(p, lerr))
+		}
+	}
+	return
+}
+
+// RemoveFollowers stops following a set of files and drops their states while taking
+// the lock once for the whole batch.  A path that fails does not stop the rest, paths
+// that were not being followed are reported with ErrNotFollowed and the errors of
+// every path are returned together.
+func (f *FilterManager) RemoveFollowers(fpaths []string) (err error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	for _, p := range fpaths {
+		if ok, rerr := f.nolockRemoveFollower(p, true); rerr != nil {
+			err = appendErr(err, fmt.Errorf("%s: %w", p, rerr))
+		} else if !ok {
+			err = appendErr(err, fmt.Errorf("%w: %s", ErrNotFollowed, p))
+		}
+	}
+	return
+}
+
+// launchError attaches the path to a failed launch, running out of descriptors is
+// called out so it is not mistaken for a problem with the file
+func launchError(fpath string, err error) error {
+	if isFdLimit(err) {
+		return fmt.Errorf("%w %s: %v", ErrTooManyOpenFiles, fpath, err)
+	}
+	return fmt.Errorf("%s: %w", fpath, err)
+}
+
+// loadBatch loads a set of files while only acquiring the lock once, files that
+// cannot be followed because we are out of file descriptors are logged and skipped
+func (f *FilterManager) loadBatch(fpaths []string) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	for _, p := range fpaths {
+		if _, err := f.launchFollowers(p, false); err != nil {
+			if isFdLimit(err) {
+				f.logger.Error("%v", launchError(p, err))
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// appendErr combines errors without flattening them, errors.Is and errors.As
+// still find every error that went in
 func appendErr(err, nerr error) error {
 	if err == nil {
 		return nerr
+	} else if nerr == nil {
+		return err
+	}
+	if el, ok := err.(errList); ok {
+		return append(el[:len(el):len(el)], nerr)
+	}
+	return errList{err, nerr}
+}
+
+// errList is a set of errors combined by appendErr, it behaves like errors.Join
+// but keeps working on toolchains older than go 1.20
+type errList []error
+
+func (el errList) Error() string {
+	strs := make([]string, 0, len(el))
+	for _, err := range el {
+		strs = append(strs, err.Error())
+	}
+	return strings.Join(strs, " : ")
+}
+
+// Unwrap exposes the errors to the go 1.20+ errors package
+func (el errList) Unwrap() []error {
+	return el
+}
+
+func (el errList) Is(target error) bool {
+	for _, err := range el {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (el errList) As(target interface{}) bool {
+	for _, err := range el {
+		if errors.As(err, target) {
+			return true
+		}
 	}
-	return fmt.Errorf("%v : %v", err, nerr)
+	return false
 }
 
-func ReadStateFile(p string) (states map[string]int64, err error) {
+// ReadStateFile loads a state file without a manager, keys are the file path joined
+// with the base name.  A WithStateCodec option selects the codec, the rest are ignored.
+func ReadStateFile(p string, opts ...ManagerOption) (states map[string]int64, err error) {
+	codec := newManagerConfig(opts).codec
 	var fi os.FileInfo
 	if fi, err = os.Stat(p); err != nil {
 		return
@@ -582,20 +2532,16 @@ func ReadStateFile(p string) (states map[string]int64, err error) {
 		fin.Close()
 		return
 	} else if fi.Size() > 0 {
-		temp := map[FileName]*int64{}
-		if err = gob.NewDecoder(fin).Decode(&temp); err != nil {
-			err = fmt.Errorf("Failed to load existing states: %v", err)
+		temp := map[FileName]FileState{}
+		if _, err = decodeStates(fin, codec, &temp); err != nil {
+			err = fmt.Errorf("Failed to load existing states: %w", err)
 			fin.Close()
 			return
 		}
 		if len(temp) > 0 {
 			states = make(map[string]int64, len(temp))
 			for k, v := range temp {
-				var offset int64
-				if v != nil {
-					offset = *v
-				}
-				states[filepath.Join(k.FilePath, k.BaseName)] = offset
+				states[filepath.Join(k.FilePath, k.BaseName)] = v.Offset
 			}
 		}
 	}
@@ -603,19 +2549,19 @@ func ReadStateFile(p string) (states map[string]int64, err error) {
 	return
 }
 
-func initStateFile(p string) (fout *os.File, states map[FileName]*int64, err error) {
+func initStateFile(p string, mc managerConfig) (fout *os.File, states map[FileName]FileState, err error) {
 	var fi os.FileInfo
-	states = map[FileName]*int64{}
+	states = map[FileName]FileState{}
 	//attempt to open state file
 	fi, err = os.Stat(p)
 	if err != nil {
 		//ensure error is a "not found" error
 		if !os.IsNotExist(err) {
-			err = fmt.Errorf("state file path is invalid: %v", err)
+			err = fmt.Errorf("state file path is invalid: %w", err)
 			return
 		}
 		//attempt to create the file and get a handle, states will be empty
-		fout, err = os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0660)
+		fout, err = os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mc.fileMode)
 		if err != nil {
 			return
 		}
@@ -627,31 +2573,57 @@ func initStateFile(p string) (fout *os.File, states map[FileName]*int64, err err
 		return
 	}
 	//is a regular file, attempt to open it RW
-	fout, err = os.OpenFile(p, os.O_RDWR, 0550) //u+rw and g+rw but no nothing else
+	fout, err = os.OpenFile(p, os.O_RDWR, mc.fileMode)
 	if err != nil {
-		err = fmt.Errorf("Failed to open state file RW: %v", err)
+		err = fmt.Errorf("Failed to open state file RW: %w", err)
 		return
 	}
 	//we have a valid file, attempt to load states if the file isn't empty
 	fi, err = fout.Stat()
 	if err != nil {
-		err = fmt.Errorf("Failed to stat open file: %v", err)
+		err = fmt.Errorf("Failed to stat open file: %w", err)
 		return
 	}
 	if fi.Size() > 0 {
-		if err = gob.NewDecoder(fout).Decode(&states); err != nil {
-			err = fmt.Errorf("Failed to load existing states: %v", err)
+		if _, err = decodeStates(fout, mc.codec, &states); errors.Is(err, ErrStateVersion) {
+			//do not wedge an upgrade or downgrade, just start over
+			mc.logger.Warn("Ignoring state file %v, starting with empty states: %v", p, err)
+			states = map[FileName]FileState{}
+			err = nil
+		} else if err != nil && mc.recoverCorrupt {
+			//move the bad file out of the way so it can be inspected and start fresh
+			mc.logger.Error("State file %v is corrupt, moving it to %v: %v", p, p+corruptStateSuffix, err)
+			states = map[FileName]FileState{}
+			if err = fout.Close(); err != nil {
+				return
+			}
+			if err = os.Rename(p, p+corruptStateSuffix); err != nil {
+				return
+			}
+			fout, err = os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mc.fileMode)
+		} else if err != nil {
+			err = fmt.Errorf("Failed to load existing states: %w", err)
 			return
 		}
 	}
 	return
 }
 
-func cleanStates(states map[FileName]*int64) error {
+func cleanStates(states map[FileName]FileState, strat FileIdStrategy, keepMissing, hdrCheck bool) (missing []FileName, err error) {
 	for k, v := range states {
+		if dir, ok := lineageStateDir(k); ok {
+			//chain members are keyed by id, keep them as long as the directory is there
+			if _, err := os.Stat(dir); os.IsNotExist(err) {
+				delete(states, k)
+			}
+			continue
+		}
 		fi, err := os.Stat(k.FilePath)
 		if err != nil {
-			if os.IsNotExist(err) {
+			if os.IsNotExist(err) && keepMissing {
+				//file may come back, the caller decides how long to wait
+				missing = append(missing, k)
+			} else if os.IsNotExist(err) {
 				//file is gone, delete it
 				delete(states, k)
 			} else {
@@ -659,15 +2631,27 @@ func cleanStates(states map[FileName]*int64) error {
 				//return err
 			}
 		} else {
-			if v == nil {
-				v = new(int64)
-			}
 			//if file shrank, we have to assume this was a truncation, so remove the state
-			if fi.Size() < *v {
-				*v = 0 //reset the size
+			if fi.Size() < v.Offset {
+				v.Offset = 0 //reset the size
+			}
+			//a different file at the same path was rotated in, start it from the top
+			if v.Id != (FileId{}) {
+				if id, err := fileIdFromName(strat, k.FilePath); err == nil && id != v.Id {
+					v.Offset = 0
+					v.Id = id
+				}
 			}
+			//same id and size but different contents, the inode was reused
+			if hdrCheck && v.Offset > 0 && headerChanged(k.FilePath, v.Header) {
+				v.Offset = 0
+			}
+			if v.Offset == 0 || !hdrCheck {
+				v.Header = FileHeader{}
+			}
+			states[k] = v
 		}
 		//all other cases are just fine, roll
 	}
-	return nil
+	return
 }
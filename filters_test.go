@@ -0,0 +1,4377 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestFilterManager(t *testing.T) (*FilterManager, string) {
+	name, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fm, err := NewFilterManager(name)
+	if err != nil {
+		cleanFile(name, t)
+		t.Fatal(err)
+	}
+	return fm, name
+}
+
+func TestStateCompaction(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+
+	//load up a pile of states for files that do not exist
+	for i := 0; i < 128; i++ {
+		fm.addSeekInfo(bName, filepath.Join(tempPath, fmt.Sprintf("missing_%d", i)))
+	}
+	evts := fm.Events()
+	fm.SetMaxStateSize(64, PruneMissing)
+	if err := fm.FlushStates(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fm.states) != 0 {
+		t.Fatalf("states not compacted: %d", len(fm.states))
+	}
+	select {
+	case evt := <-evts:
+		if evt.Type != EventStateCompacted || evt.Count != 128 {
+			t.Fatalf("bad compaction event: %+v", evt)
+		}
+	default:
+		t.Fatal("missing compaction event")
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-evts; ok {
+		t.Fatal("event channel not closed")
+	}
+}
+
+func TestFollowerBindings(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := fm.AddFilter(bName, filepath.Dir(fname), []string{filepath.Base(fname)}, &countingLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(fname); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("file not followed")
+	}
+	fbs := fm.FollowerBindings()
+	if len(fbs) != 1 || !fbs[0].Valid || fbs[0].Filter != bName || fbs[0].FilterId != 0 {
+		t.Fatalf("bad bindings: %+v", fbs)
+	}
+	//break the binding and make sure it gets flagged
+	for _, v := range fm.followers {
+		v.filterId = 5
+	}
+	if fbs = fm.FollowerBindings(); len(fbs) != 1 || fbs[0].Valid || fbs[0].Filter != `` {
+		t.Fatalf("invalid binding not flagged: %+v", fbs)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLifecycleEvents(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved := fname + `.moved`
+	defer cleanFile(fname, t)
+	defer cleanFile(moved, t)
+	evts := fm.Events()
+	mtchs := []string{filepath.Base(fname) + `*`}
+	if err := fm.AddFilter(bName, filepath.Dir(fname), mtchs, &orderedLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	next := func(et EventType) FollowerEvent {
+		for {
+			select {
+			case evt := <-evts:
+				if evt.Type == et {
+					return evt
+				} else if evt.Type != EventCaughtUp {
+					t.Fatalf("expected %v event, got %+v", et, evt)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("missing %v event", et)
+			}
+		}
+	}
+	if ok, err := fm.LoadFile(fname); err != nil || !ok {
+		t.Fatal("failed to load file", ok, err)
+	}
+	if evt := next(EventStarted); evt.Name.FilePath != fname || evt.Name.BaseName != bName || evt.Time.IsZero() {
+		t.Fatalf("bad start event %+v", evt)
+	}
+	if err := os.Rename(fname, moved); err != nil {
+		t.Fatal(err)
+	} else if _, err := fm.LoadFile(moved); err != nil {
+		t.Fatal(err)
+	}
+	if evt := next(EventRenamed); evt.Name.FilePath != moved || evt.Path != fname {
+		t.Fatalf("bad rename event %+v", evt)
+	}
+	if ok, err := fm.RemoveFollower(moved); err != nil || !ok {
+		t.Fatal("failed to remove follower", ok, err)
+	}
+	if evt := next(EventDeleted); evt.Path != moved || evt.Count != 1 {
+		t.Fatalf("bad delete event %+v", evt)
+	}
+	if evt := next(EventStopped); evt.Name.FilePath != moved {
+		t.Fatalf("bad stop event %+v", evt)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if st := fm.Stats(); st.DroppedEvents != 0 {
+		t.Fatalf("dropped %d events", st.DroppedEvents)
+	}
+}
+
+func TestThroughputStats(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved := fname + `.moved`
+	defer cleanFile(fname, t)
+	defer cleanFile(moved, t)
+	data := "one\ntwo\nthree\n"
+	if err := ioutil.WriteFile(fname, []byte(data), 0660); err != nil {
+		t.Fatal(err)
+	}
+	mtchs := []string{filepath.Base(fname) + `*`}
+	if err := fm.AddFilter(bName, filepath.Dir(fname), mtchs, &orderedLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(fname); err != nil || !ok {
+		t.Fatal("failed to load file", ok, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: fname}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(fname, moved); err != nil {
+		t.Fatal(err)
+	} else if _, err := fm.LoadFile(moved); err != nil {
+		t.Fatal(err)
+	}
+	st := fm.Stats()
+	if st.BytesRead != uint64(len(data)) || st.Records != 3 || st.ReadErrors != 0 || st.Rotations != 1 {
+		t.Fatalf("bad stats %+v", st)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForEachFollower(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	dir, err := ioutil.TempDir(tempPath, `foreach`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := fm.AddFilter(bName, dir, []string{`*.log`}, &orderedLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		p := filepath.Join(dir, fmt.Sprintf("%d.log", i))
+		if err := ioutil.WriteFile(p, []byte("line\n"), 0660); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := fm.LoadFile(p); err != nil || !ok {
+			t.Fatal("failed to load file", ok, err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: p})
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var cnt int
+	fm.ForEachFollower(func(name FileName, fi FollowerInfo) bool {
+		if name.BaseName != bName || fi.FilePath != name.FilePath || fi.FilterId != 0 || fi.Offset != 5 {
+			t.Fatalf("bad follower %v %+v", name, fi)
+		}
+		cnt++
+		return true
+	})
+	if cnt != 4 {
+		t.Fatalf("visited %d followers", cnt)
+	}
+	//returning false stops the iteration
+	cnt = 0
+	fm.ForEachFollower(func(FileName, FollowerInfo) bool {
+		cnt++
+		return false
+	})
+	if cnt != 1 {
+		t.Fatalf("iteration did not stop, visited %d", cnt)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPauseFollower(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	lh := &orderedLH{}
+	if err := fm.AddFilter(bName, filepath.Dir(fname), []string{filepath.Base(fname)}, lh, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.PauseFollower(fname); err != ErrNotFollowed {
+		t.Fatalf("paused a file that is not followed: %v", err)
+	}
+	if ok, err := fm.LoadFile(fname); err != nil || !ok {
+		t.Fatal("failed to load file", ok, err)
+	}
+	fout, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fout.Close()
+	waitLines := func(n int) (lines []string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for len(lines) < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d lines, got %v", n, lines)
+			}
+			time.Sleep(10 * time.Millisecond)
+			lines = append(lines, lh.take()...)
+		}
+		return
+	}
+	var want, got []string
+	for i := 0; i < 3; i++ {
+		ln := fmt.Sprintf("before pause %d", i)
+		if _, err := fout.WriteString(ln + "\n"); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, ln)
+		got = append(got, waitLines(1)...)
+		if err := fm.PauseFollower(fname); err != nil {
+			t.Fatal(err)
+		}
+		ln = fmt.Sprintf("while paused %d", i)
+		if _, err := fout.WriteString(ln + "\n"); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, ln)
+		time.Sleep(250 * time.Millisecond)
+		if lines := lh.take(); len(lines) != 0 {
+			t.Fatalf("paused follower delivered %v", lines)
+		}
+		if err := fm.ResumeFollower(fname); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, waitLines(1)...)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("bad lines %v != %v", got, want)
+	}
+	//paused followers are torn down by close
+	if err := fm.PauseFollower(fname); err != nil {
+		t.Fatal(err)
+	} else if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPauseAll(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	dir, err := ioutil.TempDir(tempPath, `pauseall`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lh := &orderedLH{}
+	if err := fm.AddFilter(bName, dir, []string{`*.log`}, lh, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	first := filepath.Join(dir, `first.log`)
+	second := filepath.Join(dir, `second.log`)
+	if err := ioutil.WriteFile(first, []byte("one\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(first); err != nil || !ok {
+		t.Fatal("failed to load file", ok, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: first}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.PauseAll(); err != nil {
+		t.Fatal(err)
+	} else if !fm.Paused() {
+		t.Fatal("manager is not paused")
+	}
+	//data written while paused is held in the files, new files start paused
+	fout, err := os.OpenFile(first, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fout.WriteString("two\n"); err != nil {
+		t.Fatal(err)
+	}
+	fout.Close()
+	if err := ioutil.WriteFile(second, []byte("three\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(second); err != nil || !ok {
+		t.Fatal("failed to load file while paused", ok, err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if lines := lh.take(); !reflect.DeepEqual(lines, []string{`one`}) {
+		t.Fatalf("paused manager delivered %v", lines)
+	}
+	if err := fm.ResumeAll(); err != nil {
+		t.Fatal(err)
+	} else if fm.Paused() {
+		t.Fatal("manager is still paused")
+	}
+	for _, p := range []string{first, second} {
+		if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: p}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lines := lh.take()
+	sort.Strings(lines)
+	if !reflect.DeepEqual(lines, []string{`three`, `two`}) {
+		t.Fatalf("bad lines after resume %v", lines)
+	}
+}
+
+func TestResetFollower(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	lh := &orderedLH{}
+	if err := fm.AddFilter(bName, filepath.Dir(fname), []string{filepath.Base(fname)}, lh, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.ResetFollower(fname); err != ErrNotFollowed {
+		t.Fatalf("reset a file that is not followed: %v", err)
+	}
+	fout, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fout.Close()
+	var want []string
+	write := func(n int) {
+		for i := 0; i < n; i++ {
+			ln := fmt.Sprintf("line %d", len(want))
+			if _, err := fout.WriteString(ln + "\n"); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, ln)
+		}
+	}
+	write(8)
+	if ok, err := fm.LoadFile(fname); err != nil || !ok {
+		t.Fatal("failed to load file", ok, err)
+	}
+	waitLines := func(n int) (lines []string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for len(lines) < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d lines, got %v", n, lines)
+			}
+			time.Sleep(10 * time.Millisecond)
+			lines = append(lines, lh.take()...)
+		}
+		return
+	}
+	if lines := waitLines(8); !reflect.DeepEqual(lines, want) {
+		t.Fatalf("bad lines %v", lines)
+	}
+	//the file keeps being written across the reset
+	if err := fm.ResetFollower(fname); err != nil {
+		t.Fatal(err)
+	}
+	write(4)
+	if lines := waitLines(12); !reflect.DeepEqual(lines, want) {
+		t.Fatalf("bad lines after reset %v", lines)
+	}
+	if err := fm.ResetAll(); err != nil {
+		t.Fatal(err)
+	}
+	if lines := waitLines(12); !reflect.DeepEqual(lines, want) {
+		t.Fatalf("bad lines after resetting all %v", lines)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSeekFollower(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := ioutil.WriteFile(fname, []byte("aaa\nbbb\nccc\npartial"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	lh := &orderedLH{}
+	if err := fm.AddFilter(bName, filepath.Dir(fname), []string{filepath.Base(fname)}, lh, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(fname); err != nil || !ok {
+		t.Fatal("failed to load file", ok, err)
+	}
+	waitLines := func(want []string) {
+		var lines []string
+		deadline := time.Now().Add(5 * time.Second)
+		for len(lines) < len(want) {
+			if time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+			lines = append(lines, lh.take()...)
+		}
+		if !reflect.DeepEqual(lines, want) {
+			t.Fatalf("bad lines %v != %v", lines, want)
+		}
+	}
+	waitLines([]string{`aaa`, `bbb`, `ccc`})
+	tests := []struct {
+		offset int64
+		snap   bool
+		want   []string
+	}{
+		{offset: 4, want: []string{`bbb`, `ccc`}},
+		{offset: 5, want: []string{`bb`, `ccc`}},
+		{offset: 5, snap: true, want: []string{`ccc`}},
+		{offset: 8, snap: true, want: []string{`ccc`}},
+	}
+	for _, tt := range tests {
+		if err := fm.SeekFollower(fname, tt.offset, tt.snap); err != nil {
+			t.Fatal(tt.offset, tt.snap, err)
+		}
+		waitLines(tt.want)
+	}
+	if err := fm.SeekFollower(fname, 1024, false); err != ErrOffsetBeyondEOF {
+		t.Fatalf("bad error for an offset past the end: %v", err)
+	} else if err := fm.SeekFollower(fname, 13, true); err != ErrNoBoundary {
+		t.Fatalf("bad error for an offset in the trailing record: %v", err)
+	} else if err := fm.SeekFollower(fname, -1, false); err != ErrInvalidOffset {
+		t.Fatalf("bad error for a negative offset: %v", err)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOffsetSnapshot(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := ioutil.WriteFile(fname, []byte("one\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.AddFilter(bName, filepath.Dir(fname), []string{filepath.Base(fname)}, &orderedLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(fname); err != nil || !ok {
+		t.Fatal("failed to load file", ok, err)
+	}
+	key := FileName{BaseName: bName, FilePath: fname}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := fm.WaitCaughtUp(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	snap := fm.OffsetSnapshot()
+	if len(snap) != 1 || snap[key] != 4 {
+		t.Fatalf("bad snapshot %v", snap)
+	}
+	//the follower keeps advancing but the snapshot does not
+	fout, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fout.Close()
+	if _, err := fout.WriteString("two\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.WaitCaughtUp(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if snap[key] != 4 {
+		t.Fatalf("snapshot changed to %d", snap[key])
+	} else if curr := fm.OffsetSnapshot(); curr[key] != 8 {
+		t.Fatalf("bad current offset %d", curr[key])
+	}
+	//and writing to the snapshot does not move the follower
+	snap[key] = 0
+	if curr := fm.OffsetSnapshot(); curr[key] != 8 {
+		t.Fatalf("live offset changed to %d", curr[key])
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := ioutil.WriteFile(fname, []byte("one\ntwo\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.AddFilter(bName, filepath.Dir(fname), []string{filepath.Base(fname)}, &orderedLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(fname); err != nil || !ok {
+		t.Fatal("failed to load file", ok, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: fname}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	states, err := ReadStateFile(name)
+	if err != nil {
+		t.Fatal(err)
+	} else if states[filepath.Join(fname, bName)] != 8 {
+		t.Fatalf("bad states after sync %v", states)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := fm.Sync(); err != os.ErrClosed {
+		t.Fatalf("bad error syncing a closed manager: %v", err)
+	}
+}
+
+func TestFollowerStatuses(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := ioutil.WriteFile(fname, []byte("one\ntwo\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	errBad := errors.New("bad record")
+	lh := ContextHandlerFunc(func(ctx context.Context, b []byte, ts time.Time) error {
+		if string(b) == `two` {
+			return errBad
+		}
+		return nil
+	})
+	if err := fm.AddFilter(bName, filepath.Dir(fname), []string{filepath.Base(fname)}, lh, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if ok, err := fm.LoadFile(fname); err != nil || !ok {
+		t.Fatal("failed to load file", ok, err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	var fss []FollowerStatus
+	for {
+		if fss = fm.FollowerStatuses(); len(fss) == 1 && fss[0].LastError != nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("handler error never surfaced: %+v", fss)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	fs := fss[0]
+	if fs.Name.FilePath != fname || fs.Name.BaseName != bName || fs.FilterId != 0 || fs.Parked {
+		t.Fatalf("bad status: %+v", fs)
+	} else if !errors.Is(fs.LastError, errBad) {
+		t.Fatalf("bad last error: %v", fs.LastError)
+	} else if fs.Offset != 4 {
+		t.Fatalf("bad offset %d", fs.Offset)
+	} else if fs.LastRead.Before(start) {
+		t.Fatalf("bad last read time %v", fs.LastRead)
+	}
+	if id, err := getFileIdFromName(fname); err != nil {
+		t.Fatal(err)
+	} else if fs.Id != id {
+		t.Fatalf("bad file id %v != %v", fs.Id, id)
+	}
+	fm.Close()
+}
+
+func TestCloseTimeout(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := ioutil.WriteFile(fname, []byte("wedge\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	lh := newBlockingLH()
+	defer close(lh.release)
+	if err := fm.AddFilter(bName, filepath.Dir(fname), []string{filepath.Base(fname)}, lh, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fm.LoadFile(fname); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-lh.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler never called")
+	}
+
+	fm.SetCloseTimeout(100 * time.Millisecond)
+	var ce *CloseError
+	if err := fm.Close(); err == nil {
+		t.Fatal("wedged follower did not cause an error")
+	} else if !errors.As(err, &ce) {
+		t.Fatalf("bad error type %T: %v", err, err)
+	} else if len(ce.Abandoned) != 1 || len(ce.Closed) != 0 || ce.Abandoned[0].FilePath != fname {
+		t.Fatalf("bad close report: %v", ce)
+	}
+}
+
+func TestCloseContext(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	stuck, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(stuck, t)
+	fine, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fine, t)
+	if err := ioutil.WriteFile(stuck, []byte("wedge\n"), 0660); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(fine, []byte("fine\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	lh := newBlockingLH()
+	defer close(lh.release)
+	if err := fm.AddFilter(`stuck`, filepath.Dir(stuck), []string{filepath.Base(stuck)}, lh, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	} else if err := fm.AddFilter(`fine`, filepath.Dir(fine), []string{filepath.Base(fine)}, &countingLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fm.LoadFile(stuck); err != nil {
+		t.Fatal(err)
+	} else if _, err := fm.LoadFile(fine); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-lh.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler never called")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := fm.WaitCaughtUp(ctx, FileName{BaseName: `fine`, FilePath: fine}); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	var ce *CloseError
+	if err := fm.CloseContext(ctx); !errors.As(err, &ce) {
+		t.Fatal("hung follower not reported", err)
+	} else if time.Since(start) > 3*time.Second {
+		t.Fatal("close ignored the context deadline", time.Since(start))
+	} else if len(ce.Abandoned) != 1 || ce.Abandoned[0].FilePath != stuck || !strings.Contains(err.Error(), stuck) {
+		t.Fatalf("stuck file not identified: %v", err)
+	} else if len(ce.Closed) != 1 || ce.Closed[0].FilePath != fine {
+		t.Fatalf("bad closed followers: %v", ce.Closed)
+	}
+	//the state of the follower that finished was written
+	states, err := ReadStateFile(name)
+	if err != nil {
+		t.Fatal(err)
+	} else if states[filepath.Join(fine, `fine`)] != 5 {
+		t.Fatalf("bad states %v", states)
+	}
+}
+
+// blockingLH blocks every call until release is closed
+type blockingLH struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func newBlockingLH() *blockingLH {
+	return &blockingLH{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+}
+
+func (h *blockingLH) HandleLog(b []byte, ts time.Time) error {
+	h.once.Do(func() { close(h.started) })
+	<-h.release
+	return nil
+}
+
+func TestDedupeHardlinks(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	link := fname + `.link`
+	if err := os.Link(fname, link); err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(link, t)
+	evts := fm.Events()
+	fm.SetDedupeHardlinks(true)
+	mtchs := []string{filepath.Base(fname) + `*`}
+	if err := fm.AddFilter(bName, filepath.Dir(fname), mtchs, &countingLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(fname); err != nil || !ok {
+		t.Fatal("failed to load canonical path", ok, err)
+	}
+	if ok, err := fm.LoadFile(link); err != nil || ok {
+		t.Fatal("link was not skipped", ok, err)
+	}
+	if n := fm.Followed(); n != 1 {
+		t.Fatal("bad follow count", n)
+	}
+	if evt := <-evts; evt.Type != EventStarted || evt.Name.FilePath != fname {
+		t.Fatalf("bad event %+v", evt)
+	}
+	select {
+	case evt := <-evts:
+		if evt.Type != EventHardlinkSkipped || evt.Name.FilePath != fname || evt.Path != link {
+			t.Fatalf("bad event %+v", evt)
+		}
+	default:
+		t.Fatal("missing skip event")
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	dir := filepath.Join(tempPath, `matches`)
+	cfgs := []FilterConfig{
+		{BaseName: `logs`, Location: dir, Matches: []string{`*.log`}, Excludes: []string{`debug.log`}},
+		{BaseName: `deep`, Location: dir, Matches: []string{`app/**/*.log`}, Doublestar: true},
+		{BaseName: `regex`, Location: dir, Matches: []string{`^APP\.`}, RegexMatches: true, CaseInsensitive: true},
+	}
+	for _, fc := range cfgs {
+		if err := fm.AddFilterConfig(fc, &countingLH{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{path: `a.log`, want: []string{`logs`}},
+		{path: `debug.log`},
+		{path: `app.log`, want: []string{`logs`, `regex`}},
+		{path: `app/x/y.log`, want: []string{`deep`}},
+		{path: `other.txt`},
+	}
+	for _, tt := range tests {
+		if got := fm.Matches(filepath.Join(dir, tt.path)); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("bad matches for %v: %v != %v", tt.path, got, tt.want)
+		}
+	}
+	fm.SetFirstMatchOnly(true)
+	if got := fm.Matches(filepath.Join(dir, `app.log`)); !reflect.DeepEqual(got, []string{`logs`}) {
+		t.Fatalf("bad first match %v", got)
+	} else if n := fm.Followed(); n != 0 {
+		t.Fatalf("matching launched %d followers", n)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	dir, err := ioutil.TempDir(tempPath, `dryrun`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, `sub`), 0770); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []string{`a.log`, `b.txt`, `sub/c.log`} {
+		if err := ioutil.WriteFile(filepath.Join(dir, n), nil, 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfgs := []FilterConfig{
+		{BaseName: `flat`, Location: dir, Matches: []string{`*.log`}},
+		{BaseName: `recursive`, Location: dir, Matches: []string{`*.log`}, Recursive: true},
+		{BaseName: `text`, Location: dir, Matches: []string{`*.txt`}},
+		{BaseName: `missing`, Location: filepath.Join(dir, `missing`), Matches: []string{`*`}},
+	}
+	for _, fc := range cfgs {
+		if err := fm.AddFilterConfig(fc, &countingLH{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := fm.DryRun()
+	for _, v := range got {
+		sort.Strings(v)
+	}
+	want := map[string][]string{
+		`flat`:      {filepath.Join(dir, `a.log`)},
+		`recursive`: {filepath.Join(dir, `a.log`), filepath.Join(dir, `sub`, `c.log`)},
+		`text`:      {filepath.Join(dir, `b.txt`)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("bad dry run %v", got)
+	} else if n := fm.Followed(); n != 0 {
+		t.Fatalf("dry run launched %d followers", n)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnfollowedMatches(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	dir, err := ioutil.TempDir(tempPath, `unfollowed`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, n := range []string{`a.log`, `b.log`, `reject.log`, `c.txt`} {
+		if err := ioutil.WriteFile(filepath.Join(dir, n), nil, 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fcfg := FilterConfig{
+		BaseName: bName,
+		Location: dir,
+		Matches:  []string{`*.log`},
+		Predicate: func(p string) (bool, error) {
+			return filepath.Base(p) != `reject.log`, nil
+		},
+	}
+	if err := fm.AddFilterConfig(fcfg, &countingLH{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fm.LoadFile(filepath.Join(dir, `a.log`)); err != nil {
+		t.Fatal(err)
+	}
+	ufs := fm.UnfollowedMatches()
+	if len(ufs) != 2 {
+		t.Fatalf("bad unfollowed set: %+v", ufs)
+	}
+	for _, uf := range ufs {
+		switch filepath.Base(uf.Name.FilePath) {
+		case `b.log`:
+			if uf.Reason != SkipNotLoaded {
+				t.Fatal("bad reason for b.log", uf.Reason)
+			}
+		case `reject.log`:
+			if uf.Reason != SkipPredicate {
+				t.Fatal("bad reason for reject.log", uf.Reason)
+			}
+		default:
+			t.Fatal("unexpected unfollowed file", uf.Name)
+		}
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatePersistFailed(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	evts := fm.Events()
+	//swap in a read only handle so every flush fails
+	good := fm.stateFout
+	ro, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	fm.stateFout = ro
+	for i := 0; i < 3; i++ {
+		if err := fm.FlushStates(); err == nil {
+			t.Fatal("flush to read only handle did not fail")
+		}
+	}
+	fm.stateFout = good
+	if err := fm.FlushStates(); err != nil {
+		t.Fatal(err)
+	}
+	for _, et := range []EventType{EventStatePersistFailed, EventStatePersistRecovered} {
+		select {
+		case evt := <-evts:
+			if evt.Type != et || evt.Path != name {
+				t.Fatalf("bad event %+v", evt)
+			} else if et == EventStatePersistFailed && evt.Err == nil {
+				t.Fatal("failure event is missing error")
+			}
+		default:
+			t.Fatal("missing event", et)
+		}
+	}
+	select {
+	case evt := <-evts:
+		t.Fatalf("repeated failures were not deduplicated: %+v", evt)
+	default:
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRotationDetector(t *testing.T) {
+	for _, rd := range []RotationDetector{nil, ReleaseRotation{}} {
+		fm, name := newTestFilterManager(t)
+		fname, err := newFileName()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rotated := fname + `.1`
+		fm.SetRotationDetector(rd)
+		mtchs := []string{filepath.Base(fname) + `*`}
+		if err := fm.AddFilter(bName, filepath.Dir(fname), mtchs, &countingLH{}, FollowerEngineConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := fm.LoadFile(fname); err != nil || !ok {
+			t.Fatal("failed to load file", ok, err)
+		}
+		if err := os.Rename(fname, rotated); err != nil {
+			t.Fatal(err)
+		}
+		ok, err := fm.LoadFile(rotated)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fbs := fm.FollowerBindings()
+		if rd == nil {
+			//default detector keeps following under the new name
+			if !ok || len(fbs) != 1 || fbs[0].Name.FilePath != rotated {
+				t.Fatalf("rename not followed: %v %+v", ok, fbs)
+			}
+		} else if ok || len(fbs) != 0 {
+			t.Fatalf("rotated file not released: %v %+v", ok, fbs)
+		}
+		if err := fm.Close(); err != nil {
+			t.Fatal(err)
+		}
+		cleanFile(rotated, t)
+		cleanFile(name, t)
+	}
+}
+
+func TestRotationDrain(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := fname + `.1`
+	defer cleanFile(fname, t)
+	defer cleanFile(rotated, t)
+	fm.SetRotationDrain(250 * time.Millisecond)
+	lh := &orderedLH{}
+	if err := fm.AddFilter(bName, filepath.Dir(fname), []string{filepath.Base(fname)}, lh, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	//the writer holds the file open across the rotation
+	fout, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fout.Close()
+	if _, err := fout.WriteString("one\n"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(fname); err != nil || !ok {
+		t.Fatal("failed to load file", ok, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: fname}); err != nil {
+		t.Fatal(err)
+	}
+	//rotate to a name the filter does not match and keep writing to the old file
+	if err := os.Rename(fname, rotated); err != nil {
+		t.Fatal(err)
+	} else if err := fm.RenameFollower(fname); err != nil {
+		t.Fatal(err)
+	} else if fm.Followed() != 0 {
+		t.Fatal("rotated file is still followed")
+	}
+	if _, err := fout.WriteString("two\n"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := fout.WriteString("three\n"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	var lines []string
+	for len(lines) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("late write to the rotated file was lost: %v", lines)
+		}
+		time.Sleep(10 * time.Millisecond)
+		lines = append(lines, lh.take()...)
+	}
+	if !reflect.DeepEqual(lines, []string{`one`, `two`, `three`}) {
+		t.Fatalf("bad lines %v", lines)
+	}
+	//the retired follower closes once the old file goes quiet
+	for {
+		fm.mtx.Lock()
+		n := len(fm.draining)
+		fm.mtx.Unlock()
+		if n == 0 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("drained follower was never closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitCaughtUp(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := ioutil.WriteFile(fname, []byte("one\ntwo\nthree\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	lh := &countingLH{}
+	if err := fm.AddFilter(bName, filepath.Dir(fname), []string{filepath.Base(fname)}, lh, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	stid := FileName{BaseName: bName, FilePath: fname}
+	if err := fm.WaitCaughtUp(context.Background(), stid); err != ErrNotFollowed {
+		t.Fatal("missing follower not reported", err)
+	}
+	if _, err := fm.LoadFile(fname); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+	if err := fm.WaitCaughtUp(ctx, stid); err != nil {
+		t.Fatal(err)
+	}
+	if n := lh.cnt; n != 3 {
+		t.Fatal("handler did not receive all lines", n)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxConcurrentHandlers(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	dir, err := ioutil.TempDir(tempPath, `concurrent`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lh := &concurrentLH{}
+	fcfg := FilterConfig{
+		FollowerEngineConfig: FollowerEngineConfig{
+			MaxConcurrentHandlers: 1,
+		},
+		BaseName: bName,
+		Location: dir,
+		Matches:  []string{`*.log`},
+	}
+	if err := fm.AddFilterConfig(fcfg, lh); err != nil {
+		t.Fatal(err)
+	}
+	//the panicking file goes first, its follower has to give the semaphore back
+	var names []FileName
+	for _, n := range []string{`panic.log`, `a.log`, `b.log`} {
+		p := filepath.Join(dir, n)
+		data := []byte("foo\nbar\nbaz\n")
+		if n == `panic.log` {
+			data = []byte("boom\n")
+		}
+		if err := ioutil.WriteFile(p, data, 0660); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fm.LoadFile(p); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, FileName{BaseName: bName, FilePath: p})
+	}
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+	for _, n := range names[1:] {
+		if err := fm.WaitCaughtUp(ctx, n); err != nil {
+			t.Fatal(n, err)
+		}
+	}
+	lh.Lock()
+	max, cnt := lh.max, lh.cnt
+	lh.Unlock()
+	if max != 1 {
+		t.Fatal("handler concurrency not bounded", max)
+	} else if cnt != 6 {
+		t.Fatal("bad handler count", cnt)
+	}
+	if err := fm.Close(); !errors.Is(err, ErrHandlerPanic) {
+		t.Fatal("handler panic not reported", err)
+	}
+}
+
+// concurrentLH tracks the peak number of concurrent calls and panics on boom
+type concurrentLH struct {
+	sync.Mutex
+	curr, max, cnt int
+}
+
+func (h *concurrentLH) HandleLog(b []byte, ts time.Time) error {
+	h.Lock()
+	if h.curr++; h.curr > h.max {
+		h.max = h.curr
+	}
+	h.Unlock()
+	defer func() {
+		h.Lock()
+		h.curr--
+		h.Unlock()
+	}()
+	if string(b) == `boom` {
+		panic("boom")
+	}
+	time.Sleep(5 * time.Millisecond)
+	h.Lock()
+	h.cnt++
+	h.Unlock()
+	return nil
+}
+
+func TestDuplicateFilters(t *testing.T) {
+	for _, mode := range []DuplicateMode{DuplicateAllow, DuplicateReject, DuplicateMerge} {
+		fm, name := newTestFilterManager(t)
+		fm.SetDuplicateMode(mode)
+		if err := fm.AddFilter(bName, tempPath+`/`, []string{`a*`, `b*`}, &countingLH{}, FollowerEngineConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		//same location once cleaned and the same set of matches in a different order
+		err := fm.AddFilter(bName, tempPath, []string{`b*`, `a*`}, &countingLH{}, FollowerEngineConfig{})
+		switch mode {
+		case DuplicateReject:
+			if !errors.Is(err, ErrDuplicateFilter) {
+				t.Fatal("duplicate not rejected", err)
+			}
+		default:
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		expect := 1
+		if mode == DuplicateAllow {
+			expect = 2
+		}
+		if n := fm.Filters(); n != expect {
+			t.Fatalf("bad filter count for mode %d: %d != %d", mode, n, expect)
+		}
+		//different matches are never duplicates
+		if err := fm.AddFilter(bName, tempPath, []string{`a*`}, &countingLH{}, FollowerEngineConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := fm.Close(); err != nil {
+			t.Fatal(err)
+		}
+		cleanFile(name, t)
+	}
+}
+
+func TestDuplicateMergeHandlers(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fm.SetDuplicateMode(DuplicateMerge)
+	dir, err := ioutil.TempDir(tempPath, `merge`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fpath := filepath.Join(dir, `a.log`)
+	if err := ioutil.WriteFile(fpath, []byte("one\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	first, second := &orderedLH{}, &orderedLH{}
+	if err := fm.AddFilter(bName, dir, []string{`*.log`}, first, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(fpath); err != nil || !ok {
+		t.Fatal("failed to load", ok, err)
+	}
+	key := FileName{BaseName: bName, FilePath: fpath}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := fm.WaitCaughtUp(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	//a handler that needs a different delivery mode cannot be merged
+	cx := ContextHandlerFunc(func(context.Context, []byte, time.Time) error { return nil })
+	if err := fm.AddFilter(`other`, dir+`/`, []string{`*.log`}, cx, FollowerEngineConfig{}); !errors.Is(err, ErrUnsupportedDelivery) {
+		t.Fatal("mismatched handler merged", err)
+	}
+	if err := fm.AddFilter(`other`, dir+`/`, []string{`*.log`}, second, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	} else if n := fm.Filters(); n != 1 {
+		t.Fatal("duplicate filter installed", n)
+	}
+	fout, err := os.OpenFile(fpath, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fout.WriteString("two\n"); err != nil {
+		t.Fatal(err)
+	}
+	fout.Close()
+	if err := fm.WaitCaughtUp(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if lines := first.take(); len(lines) != 2 || lines[0] != `one` || lines[1] != `two` {
+		t.Fatalf("bad lines for the first handler %v", lines)
+	} else if lines := second.take(); len(lines) != 1 || lines[0] != `two` {
+		t.Fatalf("bad lines for the merged handler %v", lines)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockStats(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fm.Followed()
+	if st := fm.Stats(); st.Lock.Acquisitions != 0 {
+		t.Fatal("lock instrumented while disabled", st.Lock)
+	}
+	fm.SetLockInstrumentation(true)
+	if err := fm.AddFilter(bName, tempPath, []string{`nothing`}, &countingLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	fm.Followed()
+	st := fm.Stats()
+	if st.Filters != 1 || st.Lock.Acquisitions < 2 || st.Lock.HoldMax <= 0 || st.Lock.HoldTotal < st.Lock.HoldMax {
+		t.Fatalf("bad stats: %+v", st)
+	}
+	fm.SetLockInstrumentation(false)
+	fm.Followed()
+	if n := fm.Stats().Lock.Acquisitions; n != st.Lock.Acquisitions {
+		t.Fatal("lock instrumented after disable", n, st.Lock.Acquisitions)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSharedStatusLock(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	//a status call in flight must not block other status calls
+	fm.mtx.RLock()
+	done := make(chan struct{})
+	go func() {
+		fm.Followed()
+		fm.UniqueFiles()
+		fm.Filters()
+		fm.ListFilters()
+		fm.FollowerBindings()
+		fm.Stats()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("status calls blocked on a shared lock")
+	}
+	//but mutations still wait for it
+	added := make(chan error, 1)
+	go func() {
+		added <- fm.AddFilter(bName, tempPath, []string{`nothing`}, &countingLH{}, FollowerEngineConfig{})
+	}()
+	select {
+	case <-added:
+		t.Fatal("filter added while the lock was shared")
+	case <-time.After(50 * time.Millisecond):
+	}
+	fm.mtx.RUnlock()
+	if err := <-added; err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadFiles(t *testing.T) {
+	dir, err := ioutil.TempDir(tempPath, `loadfiles`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var fpaths []string
+	for i := 0; i < 16; i++ {
+		p := filepath.Join(dir, fmt.Sprintf("%d.log", i))
+		if err := ioutil.WriteFile(p, []byte("line\n"), 0660); err != nil {
+			t.Fatal(err)
+		}
+		fpaths = append(fpaths, p)
+	}
+	missing := filepath.Join(dir, `missing.log`)
+	fpaths = append(fpaths, missing)
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	if err := fm.AddFilter(bName, dir, []string{`*.log`}, &orderedLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	//the missing file is reported without stopping the rest
+	if err := fm.LoadFiles(fpaths); err == nil || !strings.Contains(err.Error(), missing) || !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("missing file not reported", err)
+	} else if n := fm.Followed(); n != len(fpaths)-1 {
+		t.Fatal("bad follow count", n)
+	}
+
+	//removing half the files and one that was never followed
+	remove := append(append([]string(nil), fpaths[:8]...), missing)
+	if err := fm.RemoveFollowers(remove); !errors.Is(err, ErrNotFollowed) || !strings.Contains(err.Error(), missing) {
+		t.Fatal("unfollowed file not reported", err)
+	} else if n := fm.Followed(); n != len(fpaths)-9 {
+		t.Fatal("bad follow count after removal", n)
+	}
+	for _, p := range fpaths[:8] {
+		if fm.IsWatched(p) {
+			t.Fatal("removed file still watched", p)
+		}
+	}
+	if err := fm.RemoveFollowers(fpaths[8:16]); err != nil {
+		t.Fatal(err)
+	} else if n := fm.Followed(); n != 0 {
+		t.Fatal("followers left after removing every file", n)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadFileAt(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := ioutil.WriteFile(fname, []byte("one\ntwo\nthree\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	lh := &orderedLH{}
+	if err := fm.AddFilter(bName, filepath.Dir(fname), []string{filepath.Base(fname)}, lh, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.LoadFileAt(fname, -1); err != ErrInvalidOffset {
+		t.Fatal("negative offset accepted", err)
+	}
+	stid := FileName{BaseName: bName, FilePath: fname}
+	for _, tc := range []struct {
+		offset int64
+		expect []string
+	}{
+		{4, []string{`two`, `three`}},
+		{8, []string{`three`}},
+		{100, []string{`one`, `two`, `three`}}, //past the end is a reset
+	} {
+		if err := fm.LoadFileAt(fname, tc.offset); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+		err := fm.WaitCaughtUp(ctx, stid)
+		cf()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines := lh.take(); !reflect.DeepEqual(lines, tc.expect) {
+			t.Fatalf("bad lines at offset %d: %v", tc.offset, lines)
+		}
+	}
+	if n := fm.Followed(); n != 1 {
+		t.Fatal("reloading did not replace the follower", n)
+	}
+	if err := fm.LoadFileAt(name, 0); err != ErrNotFollowed {
+		t.Fatal("unmatched file was followed", err)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloseFlushesHandlers(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := ioutil.WriteFile(fname, []byte("one\ntwo\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	good := &bufferingLH{}
+	wedged := &bufferingLH{block: make(chan struct{})}
+	defer close(wedged.block)
+	if err := fm.AddFilter(bName, filepath.Dir(fname), []string{filepath.Base(fname)}, good, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.AddFilter(`wedged`, filepath.Dir(fname), []string{filepath.Base(fname)}, wedged, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fm.LoadFile(fname); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+	if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: fname}); err != nil {
+		t.Fatal(err)
+	}
+	fm.SetCloseTimeout(100 * time.Millisecond)
+	var ce *CloseError
+	if err := fm.Close(); !errors.As(err, &ce) {
+		t.Fatal("wedged flush not reported", err)
+	} else if len(ce.Abandoned) != 0 || !reflect.DeepEqual(ce.Unflushed, []string{`wedged`}) {
+		t.Fatalf("bad close report: %v", ce)
+	}
+	good.Lock()
+	defer good.Unlock()
+	if !reflect.DeepEqual(good.flushed, []string{`one`, `two`}) || len(good.buff) != 0 {
+		t.Fatalf("buffered records not flushed: %v %v", good.flushed, good.buff)
+	}
+}
+
+// bufferingLH holds records until it is flushed, block wedges Flush until it closes
+type bufferingLH struct {
+	sync.Mutex
+	buff    []string
+	flushed []string
+	block   chan struct{}
+}
+
+func (h *bufferingLH) HandleLog(b []byte, ts time.Time) error {
+	h.Lock()
+	h.buff = append(h.buff, string(b))
+	h.Unlock()
+	return nil
+}
+
+func (h *bufferingLH) Flush() error {
+	if h.block != nil {
+		<-h.block
+	}
+	h.Lock()
+	h.flushed, h.buff = append(h.flushed, h.buff...), nil
+	h.Unlock()
+	return nil
+}
+
+func TestDisableRenameTracking(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed := fname + `.renamed`
+	defer cleanFile(renamed, t)
+	if err := ioutil.WriteFile(fname, []byte("one\ntwo\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	lh := &orderedLH{}
+	fcfg := FilterConfig{
+		BaseName:              bName,
+		Location:              filepath.Dir(fname),
+		Matches:               []string{filepath.Base(fname) + `*`},
+		DisableRenameTracking: true,
+	}
+	if err := fm.AddFilterConfig(fcfg, lh); err != nil {
+		t.Fatal(err)
+	}
+	wait := func(p string) {
+		ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cf()
+		if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: p}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := fm.LoadFile(fname); err != nil {
+		t.Fatal(err)
+	}
+	wait(fname)
+	if err := os.Rename(fname, renamed); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.RenameFollower(fname); err != nil {
+		t.Fatal(err)
+	} else if n := fm.Followed(); n != 0 {
+		t.Fatal("renamed file still followed", n)
+	}
+	//the new name starts over from the beginning
+	if ok, err := fm.LoadFile(renamed); err != nil || !ok {
+		t.Fatal("failed to load renamed file", ok, err)
+	}
+	wait(renamed)
+	if lines := lh.take(); !reflect.DeepEqual(lines, []string{`one`, `two`, `one`, `two`}) {
+		t.Fatalf("bad lines: %v", lines)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPeriodicFlush(t *testing.T) {
+	name, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	fm, err := NewFilterManager(name, WithFlushInterval(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fm.mtx.Lock()
+	*fm.addSeekInfo(bName, name) = 1234
+	fm.mtx.Unlock()
+	//the state has to land on disk without an explicit flush
+	key := filepath.Join(name, bName)
+	for i := 0; ; i++ {
+		states, err := ReadStateFile(name)
+		if err == nil && states[key] == 1234 {
+			break
+		} else if i > 100 {
+			t.Fatal("states never flushed", states, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	//a second close must not hang on the flusher
+	fm.stopFlusher()
+}
+
+func TestDirtyStateFlush(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := ioutil.WriteFile(fname, []byte("one\ntwo\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.AddFilter(bName, filepath.Dir(fname), []string{filepath.Base(fname)}, &countingLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fm.LoadFile(fname); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+	if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: fname}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.FlushStates(); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	} else if before.Size() == 0 {
+		t.Fatal("advanced follower was not written")
+	}
+	if st, err := ReadStateFile(name); err != nil || st[filepath.Join(fname, bName)] != 8 {
+		t.Fatal("bad state written", st, err)
+	}
+
+	//nothing moved so the second flush must not touch the file
+	time.Sleep(20 * time.Millisecond)
+	if err := fm.FlushStates(); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	} else if !after.ModTime().Equal(before.ModTime()) || after.Size() != before.Size() {
+		t.Fatal("clean states were rewritten", before.ModTime(), after.ModTime())
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewFilterManagerWithOptions(t *testing.T) {
+	name, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	dir, err := ioutil.TempDir(tempPath, `options`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var lgr warnLogger
+	fm, err := NewFilterManagerWithOptions(name, WithLogger(&lgr), WithMaxFilesWatched(2), WithFlushInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	} else if fm.logger != &lgr {
+		t.Fatal("logger option not applied")
+	} else if fm.flushStop == nil {
+		t.Fatal("flush interval option not applied")
+	}
+	if err := fm.AddFilter(bName, dir, []string{`*.log`}, &countingLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		fpath := filepath.Join(dir, fmt.Sprintf("%d.log", i))
+		if err := ioutil.WriteFile(fpath, []byte("hello\n"), 0660); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fm.LoadFile(fpath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//the follower cap applies from creation
+	if n := len(fm.followers); n > 2 {
+		t.Fatal("follower cap not applied", n)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStateFileMode(t *testing.T) {
+	if runtime.GOOS == `windows` {
+		t.Skip("unix permissions")
+	}
+	name, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	//newFileName leaves a file behind, the manager has to create it
+	cleanFile(name, t)
+	fm, err := NewFilterManager(name, WithStateFileMode(0600))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(name); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Fatalf("bad state file mode: %v", fi.Mode())
+	}
+	//reopening an existing file must work with the same mode
+	if fm, err = NewFilterManager(name, WithStateFileMode(0600)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanExisting(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := ioutil.WriteFile(fname, []byte("one\ntwo\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	lh := &orderedLH{}
+	if err := fm.AddFilter(bName, filepath.Dir(fname), []string{filepath.Base(fname)}, lh, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	} else if n := fm.Followed(); n != 0 {
+		t.Fatalf("file followed before the scan: %d", n)
+	}
+	if err := fm.ScanExisting(); err != nil {
+		t.Fatal(err)
+	}
+	//a second scan and a watcher reporting the file do not start it twice
+	if err := fm.ScanExisting(); err != nil {
+		t.Fatal(err)
+	} else if _, err := fm.LoadFile(fname); err != nil {
+		t.Fatal(err)
+	} else if n := fm.Followed(); n != 1 {
+		t.Fatalf("bad follow count %d", n)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: fname}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if lines := lh.take(); !reflect.DeepEqual(lines, []string{`one`, `two`}) {
+		t.Fatalf("bad lines %v", lines)
+	}
+}
+
+func TestAutoResume(t *testing.T) {
+	name, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := ioutil.WriteFile(fname, []byte("one\ntwo\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	run := func(expect []string) {
+		fm, err := NewFilterManager(name, WithAutoResume(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lh := &orderedLH{}
+		//adding the filter is enough to pick up the existing file
+		if err := fm.AddFilter(bName, filepath.Dir(fname), []string{filepath.Base(fname)}, lh, FollowerEngineConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cf()
+		if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: fname}); err != nil {
+			t.Fatal(err)
+		}
+		if lines := lh.take(); !reflect.DeepEqual(lines, expect) {
+			t.Fatalf("bad lines: %v != %v", lines, expect)
+		}
+		if err := fm.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run([]string{`one`, `two`})
+	fout, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fout.WriteString("three\n")
+	fout.Close()
+	//a restart resumes from the saved offset
+	run([]string{`three`})
+}
+
+func TestRemoveFilter(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	dir, err := ioutil.TempDir(tempPath, `removefilter`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, n := range []string{`a`, `b`, `c`} {
+		p := filepath.Join(dir, n+`.log`)
+		if err := ioutil.WriteFile(p, []byte("foo\n"), 0660); err != nil {
+			t.Fatal(err)
+		}
+		if err := fm.AddFilter(n, dir, []string{n + `*`}, &countingLH{}, FollowerEngineConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := fm.LoadFile(p); err != nil || !ok {
+			t.Fatal("failed to load", p, ok, err)
+		}
+	}
+	if err := fm.RemoveFilter(`nope`); err != ErrFilterNotFound {
+		t.Fatal("missing filter not reported", err)
+	}
+	if err := fm.RemoveFilter(`b`); err != nil {
+		t.Fatal(err)
+	}
+	if n := fm.Filters(); n != 2 {
+		t.Fatal("filter not removed", n)
+	}
+	fbs := fm.FollowerBindings()
+	if len(fbs) != 2 {
+		t.Fatalf("bad bindings after remove: %+v", fbs)
+	}
+	for _, fb := range fbs {
+		if !fb.Valid || fb.Name.BaseName == `b` {
+			t.Fatalf("bad binding after remove: %+v", fb)
+		}
+	}
+	if fm.seekInfo(`b`, filepath.Join(dir, `b.log`)) != nil {
+		t.Fatal("state for removed filter kept")
+	}
+	//the shifted filter still handles renames
+	renamed := filepath.Join(dir, `c.renamed`)
+	if err := os.Rename(filepath.Join(dir, `c.log`), renamed); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(renamed); err != nil || !ok {
+		t.Fatal("failed to load renamed file", ok, err)
+	}
+	var found bool
+	for _, fb := range fm.FollowerBindings() {
+		if fb.Name.FilePath == renamed {
+			found = fb.Valid && fb.Filter == `c`
+		}
+	}
+	if !found || fm.Followed() != 2 {
+		t.Fatalf("rename not tracked after remove: %+v", fm.FollowerBindings())
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRemoveFilterRenumbersDelivery(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	dir, err := ioutil.TempDir(tempPath, `renumber`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fpath := filepath.Join(dir, `b.log`)
+	if err := ioutil.WriteFile(fpath, nil, 0660); err != nil {
+		t.Fatal(err)
+	}
+	var mtx sync.Mutex
+	var ids []int
+	lh := SourceHandlerFunc(func(b []byte, ts time.Time, src RecordSource) error {
+		mtx.Lock()
+		ids = append(ids, src.FilterID)
+		mtx.Unlock()
+		return nil
+	})
+	if err := fm.AddFilter(`a`, dir, []string{`a*`}, &countingLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	} else if err := fm.AddFilter(`b`, dir, []string{`b*`}, lh, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(fpath); err != nil || !ok {
+		t.Fatal("failed to load", ok, err)
+	}
+	fout, err := os.OpenFile(fpath, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fout.Close()
+	//keep the follower delivering while its filter is renumbered
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			fout.WriteString("line\n")
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := fm.RemoveFilter(`a`); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if _, err := fout.WriteString("last\n"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := fm.WaitCaughtUp(ctx, FileName{BaseName: `b`, FilePath: fpath}); err != nil {
+		t.Fatal(err)
+	}
+	mtx.Lock()
+	last := ids[len(ids)-1]
+	mtx.Unlock()
+	if last != 0 {
+		t.Fatal("delivery reports the old filter id", last)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// stalledLH records lines, blocking the first call until release is closed
+type stalledLH struct {
+	orderedLH
+	*blockingLH
+}
+
+func (h *stalledLH) HandleLog(b []byte, ts time.Time) error {
+	h.orderedLH.HandleLog(b, ts)
+	return h.blockingLH.HandleLog(b, ts)
+}
+
+func TestReplaceHandler(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	dir, err := ioutil.TempDir(tempPath, `replace`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fpath := filepath.Join(dir, `a.log`)
+	if err := ioutil.WriteFile(fpath, []byte("one\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	old := &stalledLH{blockingLH: newBlockingLH()}
+	if err := fm.AddFilter(bName, dir, []string{`*.log`}, old, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.AddFilter(`batched`, dir, []string{`*.batch`}, &batchLH{}, FollowerEngineConfig{BatchSize: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(fpath); err != nil || !ok {
+		t.Fatal("failed to load", ok, err)
+	}
+	if err := fm.ReplaceHandler(`nope`, &orderedLH{}); err != ErrFilterNotFound {
+		t.Fatal("missing filter not reported", err)
+	} else if err := fm.ReplaceHandler(`batched`, &orderedLH{}); err != ErrUnsupportedDelivery {
+		t.Fatal("handler without batches accepted", err)
+	}
+
+	//swap while the first record is still with the old handler
+	select {
+	case <-old.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("old handler never called")
+	}
+	nlh := &orderedLH{}
+	if err := fm.ReplaceHandler(bName, nlh); err != nil {
+		t.Fatal(err)
+	}
+	fout, err := os.OpenFile(fpath, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fout.WriteString("two\nthree\n"); err != nil {
+		t.Fatal(err)
+	}
+	fout.Close()
+	close(old.release)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: fpath}); err != nil {
+		t.Fatal(err)
+	}
+	if lines := old.take(); len(lines) != 1 || lines[0] != `one` {
+		t.Fatalf("bad old handler lines %v", lines)
+	}
+	if lines := nlh.take(); len(lines) != 2 || lines[0] != `two` || lines[1] != `three` {
+		t.Fatalf("bad new handler lines %v", lines)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListFilters(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	mtchs := []string{`a*`, `b*`}
+	if err := fm.AddFilter(bName, tempPath+`/`, mtchs, &countingLH{}, FollowerEngineConfig{CatchupRate: 10}); err != nil {
+		t.Fatal(err)
+	}
+	fcs := fm.ListFilters()
+	if len(fcs) != 1 {
+		t.Fatalf("bad filter list: %+v", fcs)
+	}
+	fc := fcs[0]
+	if fc.BaseName != bName || fc.Location != filepath.Clean(tempPath) || !reflect.DeepEqual(fc.Matches, mtchs) || fc.CatchupRate != 10 {
+		t.Fatalf("bad filter config: %+v", fc)
+	}
+	//callers must not be able to reach into the manager
+	fc.Matches[0] = `mutated`
+	if fm.ListFilters()[0].Matches[0] != `a*` {
+		t.Fatal("filter matches alias internal state")
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegexMatches(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fcfg := FilterConfig{
+		BaseName:     bName,
+		Location:     tempPath,
+		Matches:      []string{`^app-[0-9]+\.log$`},
+		RegexMatches: true,
+	}
+	if err := fm.AddFilterConfig(fcfg, &countingLH{}); err != nil {
+		t.Fatal(err)
+	}
+	v := fm.filters[0]
+	for n, exp := range map[string]bool{
+		`app-1.log`:    true,
+		`app-123.log`:  true,
+		`app-x.log`:    false,
+		`app-1.log.gz`: false,
+		`app-*.log`:    false,
+	} {
+		if fm.filterMatch(v, n) != exp {
+			t.Fatalf("bad match on %s, expected %v", n, exp)
+		}
+	}
+	if fcs := fm.ListFilters(); !fcs[0].RegexMatches {
+		t.Fatalf("regex flag lost: %+v", fcs[0])
+	}
+	//globs remain the default
+	if err := fm.AddFilter(`glob`, tempPath, []string{`app-*.log`}, &countingLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if !fm.filterMatch(fm.filters[1], `app-x.log`) {
+		t.Fatal("glob filter did not match")
+	}
+	//bad expressions are rejected up front
+	fcfg.BaseName = `bad`
+	fcfg.Matches = []string{`app-(`}
+	if err := fm.AddFilterConfig(fcfg, &countingLH{}); err == nil {
+		t.Fatal("invalid regex was accepted")
+	} else if len(fm.ListFilters()) != 2 {
+		t.Fatal("invalid filter was installed")
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCaseInsensitiveMatches(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fcfg := FilterConfig{
+		BaseName:        bName,
+		Location:        tempPath,
+		Matches:         []string{`*.log`, `[A-C]pp.TXT`},
+		CaseInsensitive: true,
+	}
+	if err := fm.AddFilterConfig(fcfg, &countingLH{}); err != nil {
+		t.Fatal(err)
+	}
+	fcfg.BaseName = `regex`
+	fcfg.Matches = []string{`^SYS\.log$`}
+	fcfg.RegexMatches = true
+	if err := fm.AddFilterConfig(fcfg, &countingLH{}); err != nil {
+		t.Fatal(err)
+	}
+	glob, re := fm.filters[0], fm.filters[1]
+	for n, exp := range map[string]bool{
+		`app.log`: true,
+		`APP.LOG`: true,
+		`App.Log`: true,
+		`app.txt`: true,
+		`BPP.txt`: true,
+		`cPp.TxT`: true,
+		`dpp.txt`: false,
+		`app.lo`:  false,
+	} {
+		if fm.filterMatch(glob, n) != exp {
+			t.Fatalf("bad glob match on %s, expected %v", n, exp)
+		}
+	}
+	for n, exp := range map[string]bool{
+		`sys.log`: true,
+		`Sys.LOG`: true,
+		`sysxlog`: false,
+	} {
+		if fm.filterMatch(re, n) != exp {
+			t.Fatalf("bad regex match on %s, expected %v", n, exp)
+		}
+	}
+	//folding is opt in
+	if err := fm.AddFilter(`exact`, tempPath, []string{`*.log`}, &countingLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	} else if fm.filterMatch(fm.filters[2], `APP.LOG`) {
+		t.Fatal("case sensitive filter matched uppercase name")
+	}
+	if fcs := fm.ListFilters(); !fcs[0].CaseInsensitive || fcs[2].CaseInsensitive {
+		t.Fatalf("bad case flags: %+v", fcs)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecursiveFilter(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	base, err := ioutil.TempDir(tempPath, `recursive`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(base, t)
+	deep := filepath.Join(base, `app`, `2024`, `01`)
+	if err := os.MkdirAll(deep, 0770); err != nil {
+		t.Fatal(err)
+	}
+	top := filepath.Join(base, `top.log`)
+	nested := filepath.Join(deep, `nested.log`)
+	for _, p := range []string{top, nested} {
+		if err := ioutil.WriteFile(p, []byte(filepath.Base(p)+"\n"), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flat, rec := &orderedLH{}, &orderedLH{}
+	if err := fm.AddFilter(`flat`, base, []string{`*.log`}, flat, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	fcfg := FilterConfig{
+		BaseName:  `rec`,
+		Location:  base,
+		Matches:   []string{`*.log`},
+		Recursive: true,
+	}
+	if err := fm.AddFilterConfig(fcfg, rec); err != nil {
+		t.Fatal(err)
+	}
+	wait := func(bname, p string) {
+		ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cf()
+		if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bname, FilePath: p}); err != nil {
+			t.Fatal(bname, p, err)
+		}
+	}
+	for _, p := range []string{top, nested} {
+		if ok, err := fm.LoadFile(p); err != nil || !ok {
+			t.Fatal("failed to load", p, ok, err)
+		}
+	}
+	wait(`flat`, top)
+	wait(`rec`, top)
+	wait(`rec`, nested)
+	if n := fm.Followed(); n != 3 {
+		t.Fatal("bad follower count", n)
+	}
+	if lines := flat.take(); !reflect.DeepEqual(lines, []string{`top.log`}) {
+		t.Fatalf("flat filter picked up nested files: %v", lines)
+	}
+	if lines := rec.take(); len(lines) != 2 {
+		t.Fatalf("recursive filter missed files: %v", lines)
+	}
+
+	//moving between subdirectories is a rename, not a new file
+	moved := filepath.Join(base, `app`, `moved.log`)
+	if err := os.Rename(nested, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.RenameFollower(nested); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(moved); err != nil || !ok {
+		t.Fatal("failed to load moved file", ok, err)
+	}
+	if fi, ok := fm.followers[FileName{BaseName: `rec`, FilePath: moved}]; !ok || fi == nil {
+		t.Fatal("nested follower was not renamed")
+	}
+	wait(`rec`, moved)
+	if lines := rec.take(); len(lines) != 0 || fm.Followed() != 3 {
+		t.Fatalf("moved file was followed as a new file: %v", lines)
+	}
+
+	//siblings that share a name prefix are not under the location
+	v := fm.filters[1]
+	if v.covers(base+`x`) || v.covers(filepath.Dir(base)) || !v.covers(deep) {
+		t.Fatal("bad directory coverage")
+	}
+	fcfg.BaseName = `lineage`
+	fcfg.Lineage = LineageConfig{Suffix: `.%d`}
+	if err := fm.AddFilterConfig(fcfg, rec); !errors.Is(err, ErrRecursiveLineage) {
+		t.Fatal("recursive lineage filter was accepted", err)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFilterExcludes(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	base, err := ioutil.TempDir(tempPath, `excludes`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(base, t)
+	lh := &orderedLH{}
+	fcfg := FilterConfig{
+		BaseName: bName,
+		Location: base,
+		Matches:  []string{`app.log*`},
+		Excludes: []string{`*.swp`, `*.debug.log`},
+	}
+	if err := fm.AddFilterConfig(fcfg, lh); err != nil {
+		t.Fatal(err)
+	}
+	v := fm.filters[0]
+	for n, exp := range map[string]bool{
+		`app.log`:           true,
+		`app.log.1`:         true,
+		`app.log.swp`:       false,
+		`app.log.debug.log`: false,
+	} {
+		if fm.filterMatch(v, n) != exp {
+			t.Fatalf("bad match on %s, expected %v", n, exp)
+		}
+	}
+	head := filepath.Join(base, `app.log`)
+	swp := filepath.Join(base, `app.log.swp`)
+	for _, p := range []string{head, swp} {
+		if err := ioutil.WriteFile(p, []byte(filepath.Base(p)+"\n"), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ok, err := fm.LoadFile(swp); err != nil || ok {
+		t.Fatal("excluded file was followed", ok, err)
+	}
+	if ok, err := fm.LoadFile(head); err != nil || !ok {
+		t.Fatal("failed to load head", ok, err)
+	}
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+	if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: head}); err != nil {
+		t.Fatal(err)
+	}
+	//an editor swapping the head out to an excluded name drops the follower
+	if err := os.Remove(swp); err != nil {
+		t.Fatal(err)
+	} else if err := os.Rename(head, swp); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.RenameFollower(head); err != nil {
+		t.Fatal(err)
+	} else if ok, err := fm.LoadFile(swp); err != nil || ok {
+		t.Fatal("excluded file was followed after rename", ok, err)
+	} else if n := fm.Followed(); n != 0 {
+		t.Fatal("excluded file still has a follower", n)
+	}
+	if lines := lh.take(); !reflect.DeepEqual(lines, []string{`app.log`}) {
+		t.Fatalf("bad lines: %v", lines)
+	}
+	if fcs := fm.ListFilters(); !reflect.DeepEqual(fcs[0].Excludes, fcfg.Excludes) {
+		t.Fatalf("bad excludes: %+v", fcs[0])
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDoublestarFilter(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	base, err := ioutil.TempDir(tempPath, `doublestar`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(base, t)
+	fcfg := FilterConfig{
+		BaseName:   bName,
+		Location:   base,
+		Matches:    []string{`app/**/*.log`, `top.txt`},
+		Excludes:   []string{`app/**/skip/*`},
+		Doublestar: true,
+	}
+	lh := &orderedLH{}
+	if err := fm.AddFilterConfig(fcfg, lh); err != nil {
+		t.Fatal(err)
+	}
+	v := fm.filters[0]
+	for rel, exp := range map[string]bool{
+		`app/a.log`:         true,
+		`app/2024/01/a.log`: true,
+		`app/2024/01/a.txt`: false,
+		`other/a.log`:       false,
+		`a.log`:             false,
+		`top.txt`:           true,
+		`deep/top.txt`:      true, //no separator means base name
+		`app/x/skip/a.log`:  false,
+	} {
+		if r := fm.pathMatch(v, filepath.Join(base, filepath.FromSlash(rel))); r != exp {
+			t.Fatalf("bad match on %s, expected %v", rel, exp)
+		}
+	}
+	nested := filepath.Join(base, `app`, `2024`, `01`, `a.log`)
+	if err := os.MkdirAll(filepath.Dir(nested), 0770); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(nested, []byte("nested\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(nested); err != nil || !ok {
+		t.Fatal("failed to load nested file", ok, err)
+	}
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+	if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: nested}); err != nil {
+		t.Fatal(err)
+	} else if lines := lh.take(); !reflect.DeepEqual(lines, []string{`nested`}) {
+		t.Fatalf("bad lines: %v", lines)
+	}
+	fcfg.BaseName = `regex`
+	fcfg.RegexMatches = true
+	if err := fm.AddFilterConfig(fcfg, lh); !errors.Is(err, ErrDoublestarRegex) {
+		t.Fatal("doublestar regex filter was accepted", err)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMatchRelativePath(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	base, err := ioutil.TempDir(tempPath, `relpath`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(base, t)
+	fcfg := FilterConfig{
+		BaseName:          `glob`,
+		Location:          base,
+		Matches:           []string{`tenantA/*.log`, `*.txt`},
+		Excludes:          []string{`tenantA/debug.log`},
+		MatchRelativePath: true,
+	}
+	if err := fm.AddFilterConfig(fcfg, &countingLH{}); err != nil {
+		t.Fatal(err)
+	}
+	fcfg.BaseName = `regex`
+	fcfg.Matches = []string{`^tenant[AB]/[^/]+\.log$`}
+	fcfg.Excludes = nil
+	fcfg.RegexMatches = true
+	if err := fm.AddFilterConfig(fcfg, &countingLH{}); err != nil {
+		t.Fatal(err)
+	}
+	fcfg.BaseName = `deep`
+	fcfg.Matches = []string{`**/*.txt`}
+	fcfg.RegexMatches = false
+	fcfg.Doublestar = true
+	if err := fm.AddFilterConfig(fcfg, &countingLH{}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		rel  string
+		glob bool
+		re   bool
+		deep bool
+	}{
+		{`tenantA/a.log`, true, true, false},
+		{`tenantA/debug.log`, false, true, false},
+		{`tenantB/a.log`, false, true, false},
+		{`tenantA/x/a.log`, false, false, false},
+		{`a.log`, false, false, false},
+		{`a.txt`, true, false, true},
+		{`tenantA/a.txt`, false, false, true},
+	}
+	for _, tt := range tests {
+		fpath := filepath.Join(base, filepath.FromSlash(tt.rel))
+		for i, exp := range []bool{tt.glob, tt.re, tt.deep} {
+			if r := fm.pathMatch(fm.filters[i], fpath); r != exp {
+				t.Fatalf("%s: bad match on %s, expected %v", fm.filters[i].bname, tt.rel, exp)
+			}
+		}
+	}
+
+	//renaming into another tenant directory no longer matches and drops the follower
+	a := filepath.Join(base, `tenantA`, `a.log`)
+	c := filepath.Join(base, `tenantC`, `a.log`)
+	for _, p := range []string{a, c} {
+		if err := os.MkdirAll(filepath.Dir(p), 0770); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(a, []byte("a\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(a); err != nil || !ok {
+		t.Fatal("failed to load", ok, err)
+	} else if n := fm.Followed(); n != 2 {
+		t.Fatal("bad follower count", n)
+	}
+	if err := os.Rename(a, c); err != nil {
+		t.Fatal(err)
+	} else if err := fm.RenameFollower(a); err != nil {
+		t.Fatal(err)
+	} else if n := fm.Followed(); n != 0 {
+		t.Fatal("moved file still followed", n)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFirstMatchOnly(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fm.SetFirstMatchOnly(true)
+	base, err := ioutil.TempDir(tempPath, `firstmatch`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(base, t)
+	first, second := &orderedLH{}, &orderedLH{}
+	if err := fm.AddFilter(`first`, base, []string{`*.log`}, first, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.AddFilter(`second`, base, []string{`*`}, second, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	wait := func(bname, p string) {
+		ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cf()
+		if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bname, FilePath: p}); err != nil {
+			t.Fatal(bname, p, err)
+		}
+	}
+	lg := filepath.Join(base, `a.log`)
+	txt := filepath.Join(base, `b.txt`)
+	for _, p := range []string{lg, txt} {
+		if err := ioutil.WriteFile(p, []byte(filepath.Base(p)+"\n"), 0660); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := fm.LoadFile(p); err != nil || !ok {
+			t.Fatal("failed to load", p, ok, err)
+		}
+	}
+	wait(`first`, lg)
+	wait(`second`, txt)
+	if n := fm.Followed(); n != 2 {
+		t.Fatal("file followed by more than one filter", n)
+	}
+	//loading again does not pull the file into the second filter
+	if _, err := fm.LoadFile(lg); err != nil {
+		t.Fatal(err)
+	} else if n := fm.Followed(); n != 2 {
+		t.Fatal("followed file was loaded again", n)
+	}
+	for _, uf := range fm.UnfollowedMatches() {
+		if uf.Reason != SkipFirstMatch {
+			t.Fatalf("bad skip reason for %v: %v", uf.Name, uf.Reason)
+		}
+	}
+
+	//renaming into a name only the second filter matches moves the file, it is not duplicated
+	moved := filepath.Join(base, `a.txt`)
+	if err := os.Rename(lg, moved); err != nil {
+		t.Fatal(err)
+	} else if err := fm.RenameFollower(lg); err != nil {
+		t.Fatal(err)
+	} else if _, err := fm.LoadFile(moved); err != nil {
+		t.Fatal(err)
+	}
+	wait(`second`, moved)
+	if n := fm.Followed(); n != 2 {
+		t.Fatal("bad follower count after rename", n)
+	}
+	//renaming back to a name both match keeps a single follower
+	if err := os.Rename(moved, lg); err != nil {
+		t.Fatal(err)
+	} else if err := fm.RenameFollower(moved); err != nil {
+		t.Fatal(err)
+	} else if _, err := fm.LoadFile(lg); err != nil {
+		t.Fatal(err)
+	} else if n := fm.Followed(); n != 2 {
+		t.Fatal("bad follower count after second rename", n)
+	}
+	if lines := first.take(); !reflect.DeepEqual(lines, []string{`a.log`}) {
+		t.Fatalf("bad first lines: %v", lines)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFollowCounts(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	base, err := ioutil.TempDir(tempPath, `counts`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(base, t)
+	if err := fm.AddFilter(`logs`, base, []string{`*.log`}, &countingLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.AddFilter(`all`, base, []string{`*`}, &countingLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.AddFilter(`none`, base, []string{`*.nope`}, &countingLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []string{`a.log`, `b.log`, `c.txt`} {
+		p := filepath.Join(base, n)
+		if err := ioutil.WriteFile(p, nil, 0660); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fm.LoadFile(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := fm.Followed(); n != 5 {
+		t.Fatal("bad follow count", n)
+	}
+	if n := fm.UniqueFiles(); n != 3 {
+		t.Fatal("bad unique file count", n)
+	}
+	if m := fm.FollowedByFilter(); !reflect.DeepEqual(m, map[string]int{`logs`: 2, `all`: 3}) {
+		t.Fatalf("bad per filter counts: %v", m)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStartAtEnd(t *testing.T) {
+	name, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	base, err := ioutil.TempDir(tempPath, `startatend`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(base, t)
+	existing := filepath.Join(base, `existing.log`)
+	created := filepath.Join(base, `created.log`)
+	if err := ioutil.WriteFile(existing, []byte("old\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	appendLine := func(p, ln string) {
+		fout, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0660)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fout.WriteString(ln + "\n")
+		fout.Close()
+	}
+	run := func(load func(*FilterManager), appended string, expect []string) {
+		fm, err := NewFilterManager(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lh := &orderedLH{}
+		fcfg := FilterConfig{
+			BaseName:   bName,
+			Location:   base,
+			Matches:    []string{`*.log`},
+			StartAtEnd: true,
+		}
+		if err := fm.AddFilterConfig(fcfg, lh); err != nil {
+			t.Fatal(err)
+		}
+		load(fm)
+		appendLine(existing, appended)
+		ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cf()
+		if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: existing}); err != nil {
+			t.Fatal(err)
+		}
+		if lines := lh.take(); !reflect.DeepEqual(lines, expect) {
+			t.Fatalf("bad lines: %v != %v", lines, expect)
+		}
+		if err := fm.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	loadExisting := func(fm *FilterManager) {
+		if ok, err := fm.LoadFile(existing); err != nil || !ok {
+			t.Fatal("failed to load", ok, err)
+		}
+	}
+	//the existing content is skipped
+	run(loadExisting, `new`, []string{`new`})
+	//lines written while down are picked up from the saved state
+	appendLine(existing, `offline`)
+	run(loadExisting, `again`, []string{`offline`, `again`})
+
+	//files created while running are read from the start
+	run(func(fm *FilterManager) {
+		loadExisting(fm)
+		appendLine(created, `created`)
+		if ok, err := fm.NewFollower(created); err != nil || !ok {
+			t.Fatal("failed to follow new file", ok, err)
+		}
+		ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cf()
+		if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: created}); err != nil {
+			t.Fatal(err)
+		}
+	}, `last`, []string{`created`, `last`})
+}
+
+func TestInitialLines(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	base, err := ioutil.TempDir(tempPath, `initiallines`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(base, t)
+	files := map[string]string{
+		`long.log`:    "one\ntwo\nthree\nfour\n",
+		`short.log`:   "one\n",
+		`partial.log`: "one\ntwo\nthr",
+		`empty.log`:   "",
+	}
+	lhs := map[string]*orderedLH{}
+	for n, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(base, n), []byte(data), 0660); err != nil {
+			t.Fatal(err)
+		}
+		lhs[n] = &orderedLH{}
+		fcfg := FilterConfig{
+			BaseName:     n,
+			Location:     base,
+			Matches:      []string{n},
+			InitialLines: 2,
+			StartAtEnd:   true, //InitialLines wins
+		}
+		if err := fm.AddFilterConfig(fcfg, lhs[n]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fm.LoadFile(filepath.Join(base, n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//finish the partial line so it is delivered
+	fout, err := os.OpenFile(filepath.Join(base, `partial.log`), os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fout.WriteString("ee\n")
+	fout.Close()
+	expect := map[string][]string{
+		`long.log`:    {`three`, `four`},
+		`short.log`:   {`one`},
+		`partial.log`: {`two`, `three`},
+		`empty.log`:   nil,
+	}
+	for n, exp := range expect {
+		ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+		err := fm.WaitCaughtUp(ctx, FileName{BaseName: n, FilePath: filepath.Join(base, n)})
+		cf()
+		if err != nil {
+			t.Fatal(n, err)
+		}
+		if lines := lhs[n].take(); !reflect.DeepEqual(lines, exp) {
+			t.Fatalf("%s: bad lines %v != %v", n, lines, exp)
+		}
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFlushPartialOnClose(t *testing.T) {
+	for _, flush := range []bool{false, true} {
+		fm, name := newTestFilterManager(t)
+		fname, err := newFileName()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fname, []byte("a\nb"), 0660); err != nil {
+			t.Fatal(err)
+		}
+		fcfg := FilterConfig{
+			FollowerEngineConfig: FollowerEngineConfig{FlushPartialOnClose: flush},
+			BaseName:             bName,
+			Location:             filepath.Dir(fname),
+			Matches:              []string{filepath.Base(fname)},
+		}
+		lh := &orderedLH{}
+		if err := fm.AddFilterConfig(fcfg, lh); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := fm.LoadFile(fname); err != nil || !ok {
+			t.Fatal("failed to load", ok, err)
+		}
+		//only the complete line is delivered while tailing
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			fm.mtx.Lock()
+			off := fm.followers[FileName{BaseName: bName, FilePath: fname}].offset()
+			fm.mtx.Unlock()
+			if off == 2 {
+				break
+			} else if time.Now().After(deadline) {
+				t.Fatal("follower never read the complete line")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if lines := lh.take(); !reflect.DeepEqual(lines, []string{`a`}) {
+			t.Fatalf("bad lines while tailing: %v", lines)
+		}
+		if err := fm.Close(); err != nil {
+			t.Fatal(err)
+		}
+		var expect []string
+		if flush {
+			expect = []string{`b`}
+		}
+		if lines := lh.take(); !reflect.DeepEqual(lines, expect) {
+			t.Fatalf("flush %v: bad lines on close: %v", flush, lines)
+		}
+		cleanFile(fname, t)
+		cleanFile(name, t)
+	}
+}
+
+func TestStripBOMTrimCR(t *testing.T) {
+	for _, clean := range []bool{false, true} {
+		fm, name := newTestFilterManager(t)
+		fname, err := newFileName()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := "\xef\xbb\xbfa\r\nb\r\n"
+		if err := ioutil.WriteFile(fname, []byte(data), 0660); err != nil {
+			t.Fatal(err)
+		}
+		fcfg := FilterConfig{
+			FollowerEngineConfig: FollowerEngineConfig{
+				KeepDelimiter: true,
+				StripBOM:      clean,
+				TrimCR:        clean,
+			},
+			BaseName: bName,
+			Location: filepath.Dir(fname),
+			Matches:  []string{filepath.Base(fname)},
+		}
+		lh := &orderedLH{}
+		if err := fm.AddFilterConfig(fcfg, lh); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := fm.LoadFile(fname); err != nil || !ok {
+			t.Fatal("failed to load", ok, err)
+		}
+		stid := FileName{BaseName: bName, FilePath: fname}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := fm.WaitCaughtUp(ctx, stid); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		expect := []string{"\xef\xbb\xbfa\r\n", "b\r\n"}
+		if clean {
+			expect = []string{"a\n", "b\n"}
+		}
+		if lines := lh.take(); !reflect.DeepEqual(lines, expect) {
+			t.Fatalf("clean %v: bad lines %q", clean, lines)
+		}
+		//offsets still count the raw bytes
+		fm.mtx.Lock()
+		off := fm.followers[stid].offset()
+		fm.mtx.Unlock()
+		if off != int64(len(data)) {
+			t.Fatalf("clean %v: bad offset %d", clean, off)
+		}
+		if err := fm.Close(); err != nil {
+			t.Fatal(err)
+		}
+		cleanFile(fname, t)
+		cleanFile(name, t)
+	}
+}
+
+func TestMaxOpenFollowers(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	parkPollInterval = 50 * time.Millisecond
+	defer func() { parkPollInterval = time.Second }()
+	dir, err := ioutil.TempDir(tempPath, `park`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fm.SetMaxOpenFollowers(4)
+	lh := &orderedLH{}
+	if err := fm.AddFilter(bName, dir, []string{`*.log`}, lh, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	var paths []string
+	for i := 0; i < 12; i++ {
+		p := filepath.Join(dir, fmt.Sprintf("%d.log", i))
+		ln := fmt.Sprintf("file %d", i)
+		if err := ioutil.WriteFile(p, []byte(ln+"\n"), 0660); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+		want = append(want, ln)
+		if ok, err := fm.LoadFile(p); err != nil || !ok {
+			t.Fatal("failed to load file", ok, err)
+		}
+		if n := fm.OpenFollowers(); n > 4 {
+			t.Fatalf("%d followers open with a cap of 4", n)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: p})
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := fm.Followed(); n != 12 {
+		t.Fatalf("parked followers were dropped, %d followed", n)
+	}
+	//writing to a parked file reopens it
+	fout, err := os.OpenFile(paths[0], os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fout.WriteString("file 0 again\n"); err != nil {
+		t.Fatal(err)
+	}
+	fout.Close()
+	want = append(want, `file 0 again`)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: paths[0]}); err != nil {
+		t.Fatal(err)
+	}
+	if n := fm.Stats().Open; n > 4 {
+		t.Fatalf("%d followers open with a cap of 4", n)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if lines := lh.take(); !reflect.DeepEqual(lines, want) {
+		t.Fatalf("bad lines %v", lines)
+	}
+}
+
+func TestParkedPartialLine(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	parkPollInterval = 20 * time.Millisecond
+	defer func() { parkPollInterval = time.Second }()
+	dir, err := ioutil.TempDir(tempPath, `parkpartial`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fm.SetMaxOpenFollowers(1)
+	lh := &orderedLH{}
+	if err := fm.AddFilter(bName, dir, []string{`*.log`}, lh, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	//both files end in a partial line so neither is ever fully read
+	var flws []*follower
+	for _, n := range []string{`a`, `b`} {
+		p := filepath.Join(dir, n+`.log`)
+		if err := ioutil.WriteFile(p, []byte(n+"\npart"), 0660); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := fm.LoadFile(p); err != nil || !ok {
+			t.Fatal("failed to load file", ok, err)
+		}
+		for deadline := time.Now().Add(5 * time.Second); ; {
+			if lines := lh.take(); len(lines) == 1 && lines[0] == n {
+				break
+			} else if len(lines) != 0 || time.Now().After(deadline) {
+				t.Fatal("bad lines", lines)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		fm.mtx.Lock()
+		flws = append(flws, fm.followers[FileName{BaseName: bName, FilePath: p}])
+		fm.mtx.Unlock()
+	}
+	//the parked follower stays parked rather than trading places on every poll
+	for i := 0; i < 20; i++ {
+		if !flws[0].isParked() || flws[1].isParked() {
+			t.Fatal("parked follower woke without new data", i)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	//finishing the line wakes it
+	fout, err := os.OpenFile(flws[0].FilePath, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fout.WriteString("ial\n"); err != nil {
+		t.Fatal(err)
+	}
+	fout.Close()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if lines := lh.take(); len(lines) == 1 && lines[0] == `partial` {
+			break
+		} else if len(lines) != 0 || time.Now().After(deadline) {
+			t.Fatal("bad lines", lines)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type warnLogger struct {
+	sync.Mutex
+	warns []string
+	errs  []string
+}
+
+func (l *warnLogger) Error(f string, args ...interface{}) error {
+	l.Lock()
+	l.errs = append(l.errs, fmt.Sprintf(f, args...))
+	l.Unlock()
+	return nil
+}
+
+func (l *warnLogger) Info(f string, args ...interface{}) error { return nil }
+func (l *warnLogger) Warn(f string, args ...interface{}) error {
+	l.Lock()
+	l.warns = append(l.warns, fmt.Sprintf(f, args...))
+	l.Unlock()
+	return nil
+}
+
+func TestInvalidPatternWarning(t *testing.T) {
+	lgr := &warnLogger{}
+	name, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	fm, err := NewFilterManager(name, WithLogger(lgr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.AddFilter(bName, tempPath, []string{`*.log`, `[bad`}, &countingLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(lgr.warns) != 1 || !strings.Contains(lgr.warns[0], `"[bad"`) {
+		t.Fatalf("bad warnings %v", lgr.warns)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDuplicateFollowerError(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := ioutil.WriteFile(fname, []byte("one\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	lh := &orderedLH{}
+	if err := fm.AddFilter(bName, filepath.Dir(fname), []string{filepath.Base(fname)}, lh, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(fname); err != nil || !ok {
+		t.Fatal("failed to load file", ok, err)
+	}
+	var st int64
+	fm.mtx.Lock()
+	err = fm.addFollower(FollowerConfig{
+		BaseName: bName,
+		FilePath: fname,
+		State:    &st,
+		Handler:  lh,
+	})
+	fm.mtx.Unlock()
+	if !errors.Is(err, ErrDuplicateFollower) {
+		t.Fatalf("expected a duplicate follower error, got %v", err)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAppendErr(t *testing.T) {
+	if appendErr(nil, nil) != nil {
+		t.Fatal("nil errors did not stay nil")
+	}
+	perr := &os.PathError{Op: "close", Path: "/tmp/foo", Err: os.ErrClosed}
+	err := appendErr(nil, ErrNotRunning)
+	err = appendErr(err, nil)
+	err = appendErr(err, fmt.Errorf("closing: %w", perr))
+	err = appendErr(err, ErrHandlerPanic)
+	if err.Error() != ErrNotRunning.Error()+" : closing: "+perr.Error()+" : "+ErrHandlerPanic.Error() {
+		t.Fatalf("bad combined message %q", err.Error())
+	}
+	for _, target := range []error{ErrNotRunning, os.ErrClosed, ErrHandlerPanic} {
+		if !errors.Is(err, target) {
+			t.Fatalf("lost %v", target)
+		}
+	}
+	if errors.Is(err, ErrNotFollowed) {
+		t.Fatal("matched an error that was never added")
+	}
+	var pe *os.PathError
+	if !errors.As(err, &pe) || pe != perr {
+		t.Fatal("failed to find the path error")
+	}
+}
+
+// visitCtx counts how many times the walk checked in with it
+type visitCtx struct {
+	context.Context
+	visits int
+}
+
+func (c *visitCtx) Err() error {
+	c.visits++
+	return c.Context.Err()
+}
+
+func TestRenameSearch(t *testing.T) {
+	base, err := ioutil.TempDir(tempPath, `rensearch`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+	mk := func(p string) string {
+		p = filepath.Join(base, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0770); err != nil {
+			t.Fatal(err)
+		} else if err := ioutil.WriteFile(p, []byte("line\n"), 0660); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	match := mk(filepath.Join(`a`, `match.log`))
+	for i := 0; i < 500; i++ {
+		mk(filepath.Join(`b`, fmt.Sprintf("%d.log", i)))
+	}
+	deep := mk(filepath.Join(`c`, `d`, `deep.log`))
+
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fc := FilterConfig{
+		BaseName:  `rec`,
+		Location:  base,
+		Matches:   []string{`*.log`},
+		Recursive: true,
+	}
+	if err := fm.AddFilterConfig(fc, &countingLH{}); err != nil {
+		t.Fatal(err)
+	}
+	v := fm.filters[0]
+	mid, err := getFileIdFromName(match)
+	if err != nil {
+		t.Fatal(err)
+	}
+	did, err := getFileIdFromName(deep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the walk stops at the match rather than going through b, only the base, a, and
+	//the match itself are visited
+	ctx := &visitCtx{Context: context.Background()}
+	if p, ok, err := fm.findFileId(ctx, v, mid); err != nil || !ok || p != match {
+		t.Fatal("failed to find file", p, ok, err)
+	} else if ctx.visits != 3 {
+		t.Fatalf("walk kept going after the match, %d visits", ctx.visits)
+	}
+
+	//depth limits
+	fm.SetRenameSearchDepth(2)
+	if _, ok, err := fm.findFileId(context.Background(), v, did); err != nil || ok {
+		t.Fatal("found file past the depth limit", ok, err)
+	}
+	fm.SetRenameSearchDepth(3)
+	if p, ok, err := fm.findFileId(context.Background(), v, did); err != nil || !ok || p != deep {
+		t.Fatal("failed to find file within the depth limit", p, ok, err)
+	}
+	fm.SetRenameSearchDepth(0)
+
+	//a cancelled search leaves the follower alone
+	if ok, err := fm.LoadFile(match); err != nil || !ok {
+		t.Fatal("failed to load file", ok, err)
+	}
+	cctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := fm.RenameFollowerContext(cctx, match); err != context.Canceled {
+		t.Fatalf("expected a cancelled search, got %v", err)
+	}
+	if _, ok := fm.followers[FileName{BaseName: `rec`, FilePath: match}]; !ok {
+		t.Fatal("cancelled rename dropped the follower")
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// BenchmarkFindFileId searches a large directory for a file that sorts first, the
+// walk stops at the match so only the directory and that file are visited.  The
+// directory is still listed in full, so the cost grows with its size, just without
+// stating every file in it.
+func BenchmarkFindFileId(b *testing.B) {
+	base, err := ioutil.TempDir(tempPath, `findid`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+	for i := 0; i < 4096; i++ {
+		if err := ioutil.WriteFile(filepath.Join(base, fmt.Sprintf("%05d.log", i)), nil, 0660); err != nil {
+			b.Fatal(err)
+		}
+	}
+	id, err := getFileIdFromName(filepath.Join(base, `00000.log`))
+	if err != nil {
+		b.Fatal(err)
+	}
+	name, err := newFileName()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(name)
+	fm, err := NewFilterManager(name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer fm.Close()
+	if err := fm.AddFilter(bName, base, []string{`*.log`}, &countingLH{}, FollowerEngineConfig{}); err != nil {
+		b.Fatal(err)
+	}
+	v := fm.filters[0]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx := &visitCtx{Context: context.Background()}
+		if _, ok, err := fm.findFileId(ctx, v, id); err != nil || !ok {
+			b.Fatal("failed to find file", ok, err)
+		} else if ctx.visits != 2 {
+			b.Fatalf("walk kept going after the match, %d visits", ctx.visits)
+		}
+	}
+}
+
+func TestIgnoredFiles(t *testing.T) {
+	dir, err := ioutil.TempDir(tempPath, `ignored`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mk := func(n string) string {
+		p := filepath.Join(dir, n)
+		if err := ioutil.WriteFile(p, []byte("line\n"), 0660); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	if err := fm.AddFilter(bName, dir, []string{`*`}, &countingLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	load := func(p string) bool {
+		ok, err := fm.LoadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	if !load(mk(`app.log`)) {
+		t.Fatal("failed to load plain file")
+	}
+	//editor churn never spins up followers
+	for i := 0; i < 5; i++ {
+		for _, n := range []string{`.app.log.swp`, `app.log~`, `#app.log#`} {
+			p := mk(n)
+			if load(p) {
+				t.Fatalf("followed editor file %v", n)
+			}
+			if err := os.Remove(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if cnt := len(fm.followers); cnt != 1 {
+		t.Fatalf("bad follower count %d", cnt)
+	}
+
+	//dotfiles are followed unless hidden files are skipped
+	if !load(mk(`.hidden`)) {
+		t.Fatal("failed to load dotfile")
+	}
+	fm.SetSkipHidden(true)
+	if load(mk(`.other`)) {
+		t.Fatal("followed a hidden file")
+	}
+
+	//the ignores can be replaced
+	if err := fm.SetIgnorePatterns([]string{`[bad`}); err == nil {
+		t.Fatal("accepted a bad pattern")
+	}
+	if err := fm.SetIgnorePatterns(nil); err != nil {
+		t.Fatal(err)
+	}
+	if !load(mk(`app.log~`)) {
+		t.Fatal("failed to load backup file with no ignores")
+	}
+	if err := fm.SetIgnoreRegexes([]string{`^tmp\d+$`}); err != nil {
+		t.Fatal(err)
+	}
+	if load(mk(`tmp123`)) {
+		t.Fatal("followed a file matching an ignore expression")
+	} else if !load(mk(`tmpfile`)) {
+		t.Fatal("failed to load a file missing the ignore expression")
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileIdStrategy(t *testing.T) {
+	dir, err := ioutil.TempDir(tempPath, `fileid`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	orig := filepath.Join(dir, `a.log`)
+	if err := ioutil.WriteFile(orig, []byte(strings.Repeat("some log line\n", 4)), 0660); err != nil {
+		t.Fatal(err)
+	}
+	hs := HashIdStrategy{Bytes: 16}
+	ids := func(p string) (inode, hash FileId) {
+		var err error
+		if inode, err = fileIdFromName(InodeIdStrategy{}, p); err != nil {
+			t.Fatal(err)
+		} else if hash, err = fileIdFromName(hs, p); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	inode, hash := ids(orig)
+	if hash.Major != 16 {
+		t.Fatalf("bad hashed length %d", hash.Major)
+	}
+
+	//both strategies follow a rename
+	renamed := filepath.Join(dir, `b.log`)
+	if err := os.Rename(orig, renamed); err != nil {
+		t.Fatal(err)
+	}
+	if ri, rh := ids(renamed); ri != inode || rh != hash {
+		t.Fatal("rename changed the id", ri, inode, rh, hash)
+	}
+	//a copy only looks the same by content
+	bts, err := ioutil.ReadFile(renamed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := filepath.Join(dir, `c.log`)
+	if err := ioutil.WriteFile(cp, bts, 0660); err != nil {
+		t.Fatal(err)
+	}
+	if ci, ch := ids(cp); ci == inode || ch != hash {
+		t.Fatal("bad ids for copy", ci, inode, ch, hash)
+	}
+	//appending past the hashed prefix keeps the id
+	fout, err := os.OpenFile(renamed, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fout.WriteString("another line\n"); err != nil {
+		t.Fatal(err)
+	}
+	fout.Close()
+	if _, ah := ids(renamed); ah != hash {
+		t.Fatal("append changed the hash id")
+	}
+
+	//a manager using hashes tracks the rename with its state
+	name, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	fm, err := NewFilterManager(name, WithFileIdStrategy(hs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.AddFilter(bName, dir, []string{`b.log`, `d.log`}, &countingLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(renamed); err != nil || !ok {
+		t.Fatal("failed to load file", ok, err)
+	}
+	key := FileName{BaseName: bName, FilePath: renamed}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := fm.WaitCaughtUp(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	moved := filepath.Join(dir, `d.log`)
+	if err := os.Rename(renamed, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.RenameFollower(renamed); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(moved); err != nil || !ok {
+		t.Fatal("failed to load moved file", ok, err)
+	}
+	if off := fm.OffsetSnapshot()[FileName{BaseName: bName, FilePath: moved}]; off != int64(len(bts))+13 {
+		t.Fatalf("moved follower lost its offset, at %d", off)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// constIdStrategy reports the same id for every file
+type constIdStrategy struct{}
+
+func (constIdStrategy) FileId(f *os.File) (FileId, error) {
+	return FileId{Major: 1, Minor: 1}, nil
+}
+
+func TestFileIdCollision(t *testing.T) {
+	dir, err := ioutil.TempDir(tempPath, `collide`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, `a.log`)
+	b := filepath.Join(dir, `b.log`)
+	if err := ioutil.WriteFile(a, []byte("a1\n"), 0660); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(b, []byte("b1\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	name, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	lgr := &warnLogger{}
+	fm, err := NewFilterManager(name, WithFileIdStrategy(constIdStrategy{}), WithLogger(lgr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lh := &orderedLH{}
+	if err := fm.AddFilter(bName, dir, []string{`*.log`}, lh, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	keyA := FileName{BaseName: bName, FilePath: a}
+	keyB := FileName{BaseName: bName, FilePath: b}
+	for _, p := range []string{a, b} {
+		if ok, err := fm.LoadFile(p); err != nil || !ok {
+			t.Fatal("failed to load", p, ok, err)
+		}
+	}
+	//the second file is not mistaken for a rename of the first
+	if n := fm.Followed(); n != 2 {
+		t.Fatalf("expected 2 followers, got %d", n)
+	}
+	for _, k := range []FileName{keyA, keyB} {
+		if err := fm.WaitCaughtUp(ctx, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := lh.take()
+	sort.Strings(lines)
+	if len(lines) != 2 || lines[0] != `a1` || lines[1] != `b1` {
+		t.Fatalf("bad lines %v", lines)
+	}
+	if ids := fm.DuplicateFileIds(); len(ids) != 1 || ids[0] != (FileId{Major: 1, Minor: 1}) {
+		t.Fatalf("bad duplicate ids %v", ids)
+	}
+	lgr.Lock()
+	warned := len(lgr.warns) > 0
+	lgr.Unlock()
+	if !warned {
+		t.Fatal("collision was not logged")
+	}
+
+	//a rename is not searched for by id, which would land on b
+	if err := os.Rename(a, filepath.Join(dir, `c.log`)); err != nil {
+		t.Fatal(err)
+	} else if err := fm.RenameFollower(a); err != nil {
+		t.Fatal(err)
+	}
+	fm.mtx.Lock()
+	_, okA := fm.followers[keyA]
+	flwB, okB := fm.followers[keyB]
+	fm.mtx.Unlock()
+	if okA || !okB || flwB.FilePath != b {
+		t.Fatal("rename was misrouted", okA, okB)
+	}
+	if n := fm.Followed(); n != 1 {
+		t.Fatalf("expected 1 follower, got %d", n)
+	}
+	if ids := fm.DuplicateFileIds(); len(ids) != 0 {
+		t.Fatalf("bad duplicate ids %v", ids)
+	}
+	fout, err := os.OpenFile(b, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fout.WriteString("b2\n"); err != nil {
+		t.Fatal(err)
+	}
+	fout.Close()
+	if err := fm.WaitCaughtUp(ctx, keyB); err != nil {
+		t.Fatal(err)
+	}
+	if lines := lh.take(); len(lines) != 1 || lines[0] != `b2` {
+		t.Fatalf("bad lines %v", lines)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMissingStateGrace(t *testing.T) {
+	dir, err := ioutil.TempDir(tempPath, `grace`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fpath := filepath.Join(dir, `app.log`)
+	away := filepath.Join(tempPath, fmt.Sprintf("away%d", time.Now().UnixNano()))
+	defer os.Remove(away)
+	if err := ioutil.WriteFile(fpath, []byte("one\ntwo\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	name, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	key := FileName{BaseName: bName, FilePath: fpath}
+	fm, err := NewFilterManager(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.AddFilter(bName, dir, []string{`*.log`}, &orderedLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(fpath); err != nil || !ok {
+		t.Fatal("failed to load file", ok, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := fm.WaitCaughtUp(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//the file is missing while the manager starts up and comes back afterwards
+	if err := os.Rename(fpath, away); err != nil {
+		t.Fatal(err)
+	}
+	lh := &orderedLH{}
+	if fm, err = NewFilterManager(name, WithMissingStateGrace(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.AddFilter(bName, dir, []string{`*.log`}, lh, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.FlushStates(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(away, fpath); err != nil {
+		t.Fatal(err)
+	}
+	fout, err := os.OpenFile(fpath, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fout.WriteString("three\n"); err != nil {
+		t.Fatal(err)
+	}
+	fout.Close()
+	if ok, err := fm.LoadFile(fpath); err != nil || !ok {
+		t.Fatal("failed to load returned file", ok, err)
+	}
+	if err := fm.WaitCaughtUp(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if lines := lh.take(); !reflect.DeepEqual(lines, []string{`three`}) {
+		t.Fatalf("returned file did not resume: %v", lines)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//states still missing after the window are dropped on the next flush
+	if err := os.Rename(fpath, away); err != nil {
+		t.Fatal(err)
+	}
+	if fm, err = NewFilterManager(name, WithMissingStateGrace(time.Nanosecond)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if err := fm.FlushStates(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fm.OffsetSnapshot()[key]; ok {
+		t.Fatal("expired state was kept")
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMissingFilePolicy(t *testing.T) {
+	dir, err := ioutil.TempDir(tempPath, `retain`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fpath := filepath.Join(dir, `daily.log`)
+	if err := ioutil.WriteFile(fpath, []byte("one\ntwo\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	name, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	key := FileName{BaseName: bName, FilePath: fpath}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	fm, err := NewFilterManager(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.AddFilter(bName, dir, []string{`*.log`}, &orderedLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(fpath); err != nil || !ok {
+		t.Fatal("failed to load file", ok, err)
+	} else if err := fm.WaitCaughtUp(ctx, key); err != nil {
+		t.Fatal(err)
+	} else if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(fpath); err != nil {
+		t.Fatal(err)
+	}
+
+	//retained states survive flushes while the file is gone
+	if fm, err = NewFilterManager(name, WithMissingFilePolicy(MissingRetain)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.FlushStates(); err != nil {
+		t.Fatal(err)
+	} else if off := fm.OffsetSnapshot()[key]; off != 8 {
+		t.Fatalf("retained state is at %d", off)
+	}
+	//a smaller file recreated at the path is read from the start
+	if err := ioutil.WriteFile(fpath, []byte("new\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	lh := &orderedLH{}
+	if err := fm.AddFilter(bName, dir, []string{`*.log`}, lh, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(fpath); err != nil || !ok {
+		t.Fatal("failed to load recreated file", ok, err)
+	} else if err := fm.WaitCaughtUp(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if lines := lh.take(); !reflect.DeepEqual(lines, []string{`new`}) {
+		t.Fatalf("recreated file was not read from the start: %v", lines)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//the default drops them
+	if err := os.Remove(fpath); err != nil {
+		t.Fatal(err)
+	}
+	if fm, err = NewFilterManager(name, WithMissingFilePolicy(MissingDrop)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fm.OffsetSnapshot()[key]; ok {
+		t.Fatal("missing file state was not dropped")
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteEvent(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := ioutil.WriteFile(fname, []byte("line\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	evts := fm.Events()
+	mtchs := []string{filepath.Base(fname)}
+	for _, bn := range []string{`first`, `second`} {
+		if err := fm.AddFilter(bn, filepath.Dir(fname), mtchs, &orderedLH{}, FollowerEngineConfig{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ok, err := fm.LoadFile(fname); err != nil || !ok {
+		t.Fatal("failed to load file", ok, err)
+	}
+	if err := os.Remove(fname); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.RemoveFollower(fname); err != nil || !ok {
+		t.Fatal("failed to remove follower", ok, err)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	//the event channel is closed with the manager
+	var deleted []FollowerEvent
+	var stopped int
+	for evt := range evts {
+		switch evt.Type {
+		case EventDeleted:
+			deleted = append(deleted, evt)
+		case EventStopped:
+			if len(deleted) == 0 {
+				t.Fatal("follower stopped before the delete event")
+			}
+			stopped++
+		}
+	}
+	if len(deleted) != 1 {
+		t.Fatalf("expected a single delete event, got %+v", deleted)
+	} else if evt := deleted[0]; evt.Path != fname || evt.Name.FilePath != fname || evt.Count != 2 {
+		t.Fatalf("bad delete event %+v", evt)
+	} else if stopped != 2 {
+		t.Fatalf("expected both followers to stop, got %d", stopped)
+	}
+}
+
+func TestInheritRenamedOffset(t *testing.T) {
+	for _, inherit := range []bool{true, false} {
+		dir, err := ioutil.TempDir(tempPath, `inherit`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		fm, name := newTestFilterManager(t)
+		defer cleanFile(name, t)
+		olh, nlh := &orderedLH{}, &orderedLH{}
+		if err := fm.AddFilter(`old`, dir, []string{`*.log`}, olh, FollowerEngineConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		fc := FilterConfig{
+			BaseName:             `new`,
+			Location:             dir,
+			Matches:              []string{`*.done`},
+			InheritRenamedOffset: inherit,
+		}
+		if err := fm.AddFilterConfig(fc, nlh); err != nil {
+			t.Fatal(err)
+		}
+		orig, moved := filepath.Join(dir, `a.log`), filepath.Join(dir, `a.done`)
+		if err := ioutil.WriteFile(orig, []byte("one\ntwo\n"), 0660); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if ok, err := fm.LoadFile(orig); err != nil || !ok {
+			t.Fatal("failed to load file", ok, err)
+		} else if err := fm.WaitCaughtUp(ctx, FileName{BaseName: `old`, FilePath: orig}); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(orig, moved); err != nil {
+			t.Fatal(err)
+		} else if ok, err := fm.LoadFile(moved); err != nil || !ok {
+			t.Fatal("failed to load moved file", ok, err)
+		}
+		fout, err := os.OpenFile(moved, os.O_WRONLY|os.O_APPEND, 0660)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fout.WriteString("three\n"); err != nil {
+			t.Fatal(err)
+		}
+		fout.Close()
+		if err := fm.WaitCaughtUp(ctx, FileName{BaseName: `new`, FilePath: moved}); err != nil {
+			t.Fatal(err)
+		}
+		if err := fm.Close(); err != nil {
+			t.Fatal(err)
+		}
+		exp := []string{`one`, `two`, `three`}
+		if inherit {
+			exp = exp[2:]
+		}
+		if lines := olh.take(); !reflect.DeepEqual(lines, []string{`one`, `two`}) {
+			t.Fatalf("bad lines for the old filter: %v", lines)
+		} else if lines := nlh.take(); !reflect.DeepEqual(lines, exp) {
+			t.Fatalf("bad lines for the new filter with inherit %v: %v", inherit, lines)
+		}
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir(tempPath, `retry`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var names []string
+	defer func() {
+		for _, name := range names {
+			cleanFile(name, t)
+		}
+	}()
+	run := func(p RetryPolicy, content string, fail func(string, int) bool) (lines *[]string, fm *FilterManager, key FileName) {
+		fpath := filepath.Join(dir, fmt.Sprintf("%d.log", time.Now().UnixNano()))
+		if err := ioutil.WriteFile(fpath, []byte(content), 0660); err != nil {
+			t.Fatal(err)
+		}
+		var name string
+		fm, name = newTestFilterManager(t)
+		names = append(names, name)
+		fm.SetRetryPolicy(p)
+		lines = &[]string{}
+		var mtx sync.Mutex
+		calls := map[string]int{}
+		lh := ContextHandlerFunc(func(ctx context.Context, b []byte, ts time.Time) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			ln := string(b)
+			calls[ln]++
+			if fail(ln, calls[ln]) {
+				return errors.New("delivery failed")
+			}
+			*lines = append(*lines, ln)
+			return nil
+		})
+		if err := fm.AddFilter(bName, dir, []string{filepath.Base(fpath)}, lh, FollowerEngineConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := fm.LoadFile(fpath); err != nil || !ok {
+			t.Fatal("failed to load file", ok, err)
+		}
+		key = FileName{BaseName: bName, FilePath: fpath}
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	//transient failures are retried until the handler takes the record
+	p := RetryPolicy{MaxAttempts: 3, Backoff: 5 * time.Millisecond, Multiplier: 2}
+	lines, fm, key := run(p, "one\ntwo\n", func(ln string, n int) bool { return n < 3 })
+	if err := fm.WaitCaughtUp(ctx, key); err != nil {
+		t.Fatal(err)
+	} else if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*lines, []string{`one`, `two`}) {
+		t.Fatalf("bad lines after retries: %v", *lines)
+	} else if st := fm.Stats(); st.Records != 2 || st.DroppedRecords != 0 {
+		t.Fatalf("bad stats %+v", st)
+	}
+
+	//permanent failures are dropped and the state moves past them
+	p = RetryPolicy{MaxAttempts: 2, OnFailure: FailDrop}
+	bad := func(ln string, n int) bool { return ln == `bad` }
+	lines, fm, key = run(p, "one\nbad\ntwo\n", bad)
+	if err := fm.WaitCaughtUp(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if off := fm.OffsetSnapshot()[key]; off != 12 {
+		t.Fatalf("dropped record held the state at %d", off)
+	} else if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*lines, []string{`one`, `two`}) {
+		t.Fatalf("bad lines after dropping: %v", *lines)
+	} else if st := fm.Stats(); st.Records != 2 || st.DroppedRecords != 1 {
+		t.Fatalf("bad stats %+v", st)
+	}
+
+	//or stop the follower without moving the state past them
+	p.OnFailure = FailStop
+	lines, fm, key = run(p, "one\nbad\ntwo\n", bad)
+	for {
+		if sts := fm.FollowerStatuses(); len(sts) == 1 && sts[0].LastError != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("follower did not stop")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if off := fm.OffsetSnapshot()[key]; off != 4 {
+		t.Fatalf("state moved past the failed record to %d", off)
+	}
+	fm.Close()
+	if !reflect.DeepEqual(*lines, []string{`one`}) {
+		t.Fatalf("bad lines after stopping: %v", *lines)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := ioutil.WriteFile(fname, []byte("one\nbad\ntwo\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	errBad := errors.New("bad record")
+	lh := &orderedLH{}
+	primary := ContextHandlerFunc(func(ctx context.Context, b []byte, ts time.Time) error {
+		if string(b) == `bad` {
+			return errBad
+		}
+		return lh.HandleLog(b, ts)
+	})
+	var mtx sync.Mutex
+	var dead []string
+	fm.SetRetryPolicy(RetryPolicy{MaxAttempts: 2}) //stops the follower without a sink
+	fm.SetDeadLetter(DeadLetterFunc(func(src FileName, b []byte, ts time.Time, err error) error {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if src.FilePath != fname || src.BaseName != bName || err != errBad {
+			t.Errorf("bad dead letter source %v and error %v", src, err)
+		}
+		dead = append(dead, string(b))
+		return nil
+	}))
+	if err := fm.AddFilter(bName, filepath.Dir(fname), []string{filepath.Base(fname)}, primary, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(fname); err != nil || !ok {
+		t.Fatal("failed to load file", ok, err)
+	}
+	key := FileName{BaseName: bName, FilePath: fname}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := fm.WaitCaughtUp(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if off := fm.OffsetSnapshot()[key]; off != 12 {
+		t.Fatalf("dead lettered record held the state at %d", off)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if lines := lh.take(); !reflect.DeepEqual(lines, []string{`one`, `two`}) {
+		t.Fatalf("bad primary lines %v", lines)
+	} else if !reflect.DeepEqual(dead, []string{`bad`}) {
+		t.Fatalf("bad dead letters %v", dead)
+	} else if st := fm.Stats(); st.DeadLettered != 1 || st.DroppedRecords != 0 {
+		t.Fatalf("bad stats %+v", st)
+	}
+}
+
+func TestReadRateLimit(t *testing.T) {
+	dir, err := ioutil.TempDir(tempPath, `ratelimit`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	//2000 bytes against a 1000 byte per second cap, the first 1000 are the burst
+	content := []byte(strings.Repeat(strings.Repeat("x", 49)+"\n", 40))
+	run := func(global int, fec FollowerEngineConfig) time.Duration {
+		fpath := filepath.Join(dir, fmt.Sprintf("%d.log", time.Now().UnixNano()))
+		if err := ioutil.WriteFile(fpath, content, 0660); err != nil {
+			t.Fatal(err)
+		}
+		fm, name := newTestFilterManager(t)
+		defer cleanFile(name, t)
+		fm.SetMaxBytesPerSecond(global)
+		if err := fm.AddFilter(bName, dir, []string{filepath.Base(fpath)}, &countingLH{}, fec); err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		if ok, err := fm.LoadFile(fpath); err != nil || !ok {
+			t.Fatal("failed to load file", ok, err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: fpath}); err != nil {
+			t.Fatal(err)
+		}
+		d := time.Since(start)
+		if err := fm.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	if d := run(1000, FollowerEngineConfig{}); d < 900*time.Millisecond {
+		t.Fatalf("manager cap exceeded, read %d bytes in %v", len(content), d)
+	}
+	if d := run(0, FollowerEngineConfig{MaxBytesPerSecond: 1000}); d < 900*time.Millisecond {
+		t.Fatalf("filter cap exceeded, read %d bytes in %v", len(content), d)
+	}
+
+	//a throttled follower does not hold up shutdown
+	fpath := filepath.Join(dir, `slow.log`)
+	if err := ioutil.WriteFile(fpath, content, 0660); err != nil {
+		t.Fatal(err)
+	}
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fm.SetMaxBytesPerSecond(100)
+	if err := fm.AddFilter(bName, dir, []string{`slow.log`}, &countingLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(fpath); err != nil || !ok {
+		t.Fatal("failed to load file", ok, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	} else if d := time.Since(start); d > time.Second {
+		t.Fatalf("close took %v", d)
+	}
+}
+
+func TestPublishExpvar(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := ioutil.WriteFile(fname, []byte("one\ntwo\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	prefix := fmt.Sprintf("filewatch%d", time.Now().UnixNano())
+	if err := fm.PublishExpvar(prefix); err != nil {
+		t.Fatal(err)
+	}
+	//a second manager must pick its own prefix
+	other, oname := newTestFilterManager(t)
+	defer cleanFile(oname, t)
+	if err := other.PublishExpvar(prefix); err != ErrExpvarPublished {
+		t.Fatalf("expected a duplicate publish error, got %v", err)
+	} else if err := other.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bn := range []string{`first`, `second`} {
+		if err := fm.AddFilter(bn, filepath.Dir(fname), []string{filepath.Base(fname)}, &countingLH{}, FollowerEngineConfig{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ok, err := fm.LoadFile(fname); err != nil || !ok {
+		t.Fatal("failed to load file", ok, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, bn := range []string{`first`, `second`} {
+		if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bn, FilePath: fname}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exp := map[string]string{
+		`followed`:   `2`,
+		`files`:      `1`,
+		`bytes_read`: `16`,
+		`records`:    `4`,
+	}
+	for k, v := range exp {
+		if ev := expvar.Get(prefix + `.` + k); ev == nil {
+			t.Fatalf("%v was not published", k)
+		} else if ev.String() != v {
+			t.Fatalf("%v is %v not %v", k, ev.String(), v)
+		}
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHeaderCheck(t *testing.T) {
+	dir, err := ioutil.TempDir(tempPath, `header`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fpath := filepath.Join(dir, `app.log`)
+	orig := strings.Repeat("original line\n", 8)
+	if err := ioutil.WriteFile(fpath, []byte(orig), 0660); err != nil {
+		t.Fatal(err)
+	}
+	name, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	key := FileName{BaseName: bName, FilePath: fpath}
+	run := func(lh *orderedLH) {
+		fm, err := NewFilterManager(name, WithHeaderCheck(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fm.AddFilter(bName, dir, []string{`*.log`}, lh, FollowerEngineConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := fm.LoadFile(fpath); err != nil || !ok {
+			t.Fatal("failed to load file", ok, err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := fm.WaitCaughtUp(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+		if err := fm.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lh := &orderedLH{}
+	run(lh)
+	if lines := lh.take(); len(lines) != 8 {
+		t.Fatalf("bad initial read: %v", lines)
+	}
+
+	//an untouched file resumes at its offset
+	lh = &orderedLH{}
+	run(lh)
+	if lines := lh.take(); len(lines) != 0 {
+		t.Fatalf("untouched file was read again: %v", lines)
+	}
+
+	//rewrite the contents in place, the inode and size stay the same
+	id, err := getFileIdFromName(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fout, err := os.OpenFile(fpath, os.O_WRONLY, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fout.WriteAt([]byte(strings.Repeat("replaced line\n", 8)), 0); err != nil {
+		t.Fatal(err)
+	}
+	fout.Close()
+	if fi, err := os.Stat(fpath); err != nil || fi.Size() != int64(len(orig)) {
+		t.Fatal("bad replaced file", err)
+	} else if nid, err := getFileIdFromName(fpath); err != nil || nid != id {
+		t.Fatal("replaced file changed id", err)
+	}
+	lh = &orderedLH{}
+	run(lh)
+	if lines := lh.take(); len(lines) != 8 || lines[0] != `replaced line` {
+		t.Fatalf("replaced file was not read from the start: %v", lines)
+	}
+}
+
+func TestReopenOnReplace(t *testing.T) {
+	dir, err := ioutil.TempDir(tempPath, `reopen`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fpath := filepath.Join(dir, `app.log`)
+	//replace the file the way editors and atomic writers do, write a temp and rename it
+	replace := func(data string) {
+		tmp := filepath.Join(dir, `app.tmp`)
+		if err := ioutil.WriteFile(tmp, []byte(data), 0660); err != nil {
+			t.Fatal(err)
+		} else if err := os.Rename(tmp, fpath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitLines := func(lh *orderedLH, n int) (r []string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); len(r) < n; time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %d lines: %v", n, r)
+			}
+			r = append(r, lh.take()...)
+		}
+		return
+	}
+	replace("one\ntwo\n")
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	evts := fm.Events()
+	lh := &orderedLH{}
+	if err := fm.AddFilter(bName, dir, []string{`*.log`}, lh, FollowerEngineConfig{ReopenCheck: 10 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(fpath); err != nil || !ok {
+		t.Fatal("failed to load file", ok, err)
+	}
+	if lines := waitLines(lh, 2); !reflect.DeepEqual(lines, []string{`one`, `two`}) {
+		t.Fatalf("bad initial lines: %v", lines)
+	}
+
+	//the old contents rewritten with a new line appended picks up where we were
+	replace("one\ntwo\nthree\n")
+	if lines := waitLines(lh, 1); !reflect.DeepEqual(lines, []string{`three`}) {
+		t.Fatalf("rewritten file did not continue: %v", lines)
+	}
+
+	//different contents are read from the start
+	replace("alpha\nbeta\n")
+	if lines := waitLines(lh, 2); !reflect.DeepEqual(lines, []string{`alpha`, `beta`}) {
+		t.Fatalf("replaced file was not read from the start: %v", lines)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if lines := lh.take(); len(lines) != 0 {
+		t.Fatalf("extra lines delivered: %v", lines)
+	} else if off := fm.OffsetSnapshot()[FileName{BaseName: bName, FilePath: fpath}]; off != int64(len("alpha\nbeta\n")) {
+		t.Fatalf("bad offset after replacement: %d", off)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var replaced int
+	for evt := range evts {
+		if evt.Type == EventReplaced {
+			replaced++
+		}
+	}
+	if replaced != 1 {
+		t.Fatalf("expected a single replaced event, got %d", replaced)
+	}
+}
+
+func TestHandlerPanicPolicy(t *testing.T) {
+	for _, policy := range []PanicPolicy{PanicSkip, PanicStop} {
+		dir, err := ioutil.TempDir(tempPath, `panics`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		panicky, steady := filepath.Join(dir, `panicky.log`), filepath.Join(dir, `steady.log`)
+		for _, p := range []string{panicky, steady} {
+			if err := ioutil.WriteFile(p, []byte("one\nboom\ntwo\n"), 0660); err != nil {
+				t.Fatal(err)
+			}
+		}
+		name, err := newFileName()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cleanFile(name, t)
+		lgr := &warnLogger{}
+		fm, err := NewFilterManager(name, WithLogger(lgr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fm.SetPanicPolicy(policy)
+		var mtx sync.Mutex
+		var dead []string
+		fm.SetDeadLetter(DeadLetterFunc(func(src FileName, b []byte, ts time.Time, err error) error {
+			mtx.Lock()
+			dead = append(dead, string(b))
+			mtx.Unlock()
+			return nil
+		}))
+		plh, slh := &orderedLH{}, &orderedLH{}
+		bad := ContextHandlerFunc(func(ctx context.Context, b []byte, ts time.Time) error {
+			if string(b) == `boom` {
+				panic("boom")
+			}
+			return plh.HandleLog(b, ts)
+		})
+		if err := fm.AddFilter(`panicky`, dir, []string{`panicky.log`}, bad, FollowerEngineConfig{}); err != nil {
+			t.Fatal(err)
+		} else if err := fm.AddFilter(`steady`, dir, []string{`steady.log`}, slh, FollowerEngineConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range []string{panicky, steady} {
+			if ok, err := fm.LoadFile(p); err != nil || !ok {
+				t.Fatal("failed to load file", ok, err)
+			}
+		}
+		pkey := FileName{BaseName: `panicky`, FilePath: panicky}
+		skey := FileName{BaseName: `steady`, FilePath: steady}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := fm.WaitCaughtUp(ctx, skey); err != nil {
+			t.Fatal(err)
+		}
+		if policy == PanicSkip {
+			if err := fm.WaitCaughtUp(ctx, pkey); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+				fm.mtx.Lock()
+				running := fm.followers[pkey].Running()
+				fm.mtx.Unlock()
+				if !running {
+					break
+				} else if time.Now().After(deadline) {
+					t.Fatal("follower did not stop on the panic")
+				}
+			}
+		}
+
+		//the other follower keeps running
+		fout, err := os.OpenFile(steady, os.O_WRONLY|os.O_APPEND, 0660)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fout.WriteString("three\n"); err != nil {
+			t.Fatal(err)
+		}
+		fout.Close()
+		for deadline := time.Now().Add(5 * time.Second); fm.OffsetSnapshot()[skey] != 19; time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal("steady follower stopped reading")
+			}
+		}
+		ms := fm.Stats()
+		if ms.HandlerPanics != 1 {
+			t.Fatalf("%v: bad panic count %d", policy, ms.HandlerPanics)
+		}
+		var lastErr error
+		for _, st := range fm.FollowerStatuses() {
+			if st.Name == pkey {
+				lastErr = st.LastError
+			}
+		}
+		off := fm.OffsetSnapshot()[pkey]
+		err = fm.Close()
+		lgr.Lock()
+		logged := len(lgr.errs)
+		lgr.Unlock()
+		if logged != 1 {
+			t.Fatalf("%v: panic was logged %d times", policy, logged)
+		}
+		if lines := slh.take(); !reflect.DeepEqual(lines, []string{`one`, `boom`, `two`, `three`}) {
+			t.Fatalf("bad steady lines %v", lines)
+		}
+		switch policy {
+		case PanicSkip:
+			if err != nil || lastErr != nil {
+				t.Fatal("skipped panic stopped the follower", err, lastErr)
+			} else if lines := plh.take(); !reflect.DeepEqual(lines, []string{`one`, `two`}) {
+				t.Fatalf("bad lines after skipping %v", lines)
+			} else if !reflect.DeepEqual(dead, []string{`boom`}) || ms.DeadLettered != 1 {
+				t.Fatalf("panicking record was not dead lettered: %v", dead)
+			} else if off != 13 {
+				t.Fatalf("bad offset after skipping %d", off)
+			}
+		case PanicStop:
+			if !errors.Is(lastErr, ErrHandlerPanic) || !errors.Is(err, ErrHandlerPanic) {
+				t.Fatal("panic did not stop the follower", err, lastErr)
+			} else if lines := plh.take(); !reflect.DeepEqual(lines, []string{`one`}) {
+				t.Fatalf("bad lines after stopping %v", lines)
+			} else if len(dead) != 0 {
+				t.Fatalf("stopped follower dead lettered %v", dead)
+			} else if off != 4 {
+				t.Fatalf("panicking record was acknowledged, offset %d", off)
+			}
+		}
+	}
+}
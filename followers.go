@@ -9,13 +9,22 @@
 package filewatch
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"regexp"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/gravwell/ingest/v3"
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -23,14 +32,101 @@ const (
 )
 
 var (
-	ErrNotRunning = errors.New("Not running")
-	tickInterval  = time.Second
+	ErrNotRunning          = errors.New("Not running")
+	ErrUnsupportedDelivery = errors.New("Handler does not support the requested delivery mode")
+	ErrHandlerPanic        = errors.New("Handler panicked")
+	ErrInvalidDelimiter    = errors.New("Delimiter must be a single byte")
+	tickInterval           = time.Second
+	waitPollInterval       = 50 * time.Millisecond
+	stopDrainTimeout       = 500 * time.Millisecond //how long a stopping routine may drain before its context is cancelled
 )
 
+// handler receives records from followers.  Handlers are called synchronously from
+// the follower read loop, the next record is not read until the handler accepted the
+// previous one, so a slow handler throttles reading instead of the file piling up in
+// memory.  A follower holds at most one record, or one batch when batching, at a time.
 type handler interface {
 	HandleLog([]byte, time.Time) error
 }
 
+// RecordSource describes where a delivered record came from
+type RecordSource struct {
+	FilePath string
+	BaseName string //name of the filter following the file
+	FilterID int
+	Offset   int64 //offset in the raw file where reading of the record began
+}
+
+// sourceHandler is implemented by handlers that want to know which file each
+// record came from, see SourceHandlerFunc
+type sourceHandler interface {
+	HandleSourceLog([]byte, time.Time, RecordSource) error
+}
+
+// ContextHandler is implemented by handlers that can give up on a record, the context
+// is cancelled when the follower is stopped or closed and the handler should return
+// promptly once it is done.  A record whose handler call fails is not acknowledged
+// and is read again on restart.  See HandlerShim for wrapping plain handlers.
+type ContextHandler interface {
+	HandleLogContext(context.Context, []byte, time.Time) error
+}
+
+// batchHandler is implemented by handlers that take several records per call, see
+// FollowerEngineConfig.BatchSize
+type batchHandler interface {
+	HandleBatch([][]byte, time.Time) error
+}
+
+// flusher is implemented by handlers that buffer records, Close calls Flush once
+// every follower has drained so buffered records are not lost on shutdown
+type flusher interface {
+	Flush() error
+}
+
+// DeliveryMode selects which handler method a follower uses to deliver records,
+// DeliveryAuto picks the most capable interface implemented by the handler
+type DeliveryMode int
+
+const (
+	DeliveryAuto    DeliveryMode = iota
+	DeliveryLine                 //plain HandleLog calls
+	DeliverySource               //HandleSourceLog calls carrying the RecordSource
+	DeliveryContext              //HandleLogContext calls with the follower context
+)
+
+// resolveDelivery picks the delivery mode for a handler, explicitly requesting
+// a mode the handler does not implement is an error
+func resolveDelivery(mode DeliveryMode, lh handler) (DeliveryMode, error) {
+	if fo, ok := lh.(*fanoutHandler); ok && mode == DeliveryAuto {
+		return fo.mode, nil //merged handlers already agreed on a mode
+	}
+	_, src := lh.(sourceHandler)
+	_, cx := lh.(ContextHandler)
+	switch {
+	case mode == DeliveryAuto && cx:
+		return DeliveryContext, nil
+	case mode == DeliveryAuto && src:
+		return DeliverySource, nil
+	case mode == DeliveryAuto, mode == DeliveryLine:
+		return DeliveryLine, nil
+	case mode == DeliverySource && src, mode == DeliveryContext && cx:
+		return mode, nil
+	}
+	return mode, ErrUnsupportedDelivery
+}
+
+// handlerMode resolves the delivery mode of lh under cfg, batching also needs a
+// handler that takes batches
+func handlerMode(cfg FollowerEngineConfig, lh handler) (DeliveryMode, error) {
+	mode, err := resolveDelivery(cfg.Delivery, lh)
+	if err != nil {
+		return mode, err
+	} else if _, ok := lh.(batchHandler); cfg.BatchSize > 0 && !ok {
+		return mode, ErrUnsupportedDelivery
+	}
+	return mode, nil
+}
+
 type FileId struct {
 	Major uint64
 	Minor uint64
@@ -39,6 +135,101 @@ type FileId struct {
 type FollowerEngineConfig struct {
 	Engine     int
 	EngineArgs string
+	// CatchupRate limits how fast (in bytes per second) a follower drains the
+	// existing contents of a file before it first reaches EOF, zero is unlimited.
+	// Once caught up the follower switches to notification driven reads.
+	CatchupRate int
+	// MaxBytesPerSecond caps the combined read rate of every follower of a filter,
+	// unlike CatchupRate it applies for the life of the followers.  Zero is unlimited,
+	// see SetMaxBytesPerSecond for a cap across every filter.
+	MaxBytesPerSecond int
+	Delivery          DeliveryMode
+	// KeepDelimiter delivers the exact bytes of each line including its
+	// delimiter, partial lines are still held until the delimiter arrives.
+	// Only the line engine strips delimiters, so it has no effect on others.
+	KeepDelimiter bool
+	// Delimiter is the single byte that ends each record for the line engine, empty
+	// means a newline.  A string is used so that a NUL delimiter can be expressed.
+	// Records are delivered without the delimiter, the newline delimiter also strips
+	// a preceding carriage return so \r\n files work without configuration.
+	Delimiter string
+	// MultilineStart is a regular expression matching the first line of a record,
+	// lines that do not match are appended to the record being built.  A record is
+	// delivered when the next start line arrives, when it reaches MaxMultilineBytes
+	// (zero is the maximum line length), or when the follower is closed.
+	MultilineStart    string
+	MaxMultilineBytes int
+	// ReadBufferSize is how many bytes followers of the filter read from their file at
+	// a time, zero uses the manager wide size from SetReadBufferSize.  It must be
+	// between MinReadBufferSize and MaxReadBufferSize and does not limit record sizes.
+	// A multiline record starts out with room for this many bytes, or for
+	// MaxMultilineBytes if that is smaller.
+	ReadBufferSize int
+	// A trailing line that has no delimiter yet is normally held back until the
+	// delimiter arrives so half a record is never delivered.  FlushPartialOnClose
+	// delivers the held bytes as a final record when the follower shuts down
+	// gracefully or its file is removed, the line engine is the only one that holds
+	// partial lines.
+	FlushPartialOnClose bool
+	// Gzip decompresses followed files, see GzipReader.  Compressed files are read
+	// once from the start and their state is set to the file size once every line
+	// was delivered, so a restart does not deliver them again.  Files that were only
+	// partially delivered are read again from the start.
+	Gzip bool
+	// Encoding converts records from a character encoding such as utf-16le or latin1
+	// to UTF-8 before delivery, empty delivers the raw bytes.  Names are the WHATWG
+	// encoding labels except that latin1 is ISO-8859-1.  Offsets are tracked on the raw
+	// file and a byte order mark at the start of the file is dropped.
+	Encoding string
+	// StripBOM drops a UTF-8 byte order mark from the first record when that record
+	// starts at offset zero, TrimCR removes a trailing carriage return from every
+	// record.  Offsets still track the raw bytes so restarts land on record boundaries.
+	StripBOM bool
+	TrimCR   bool
+	// SymlinkRecheck is how often a followed symlink is re-resolved, if the link
+	// was repointed the follower switches to the new target and starts over at
+	// offset zero.  Zero disables re-resolution.
+	SymlinkRecheck time.Duration
+	// ReopenCheck is how often a follower stats its path to catch writers that
+	// replace the file, such as a write to a temporary file renamed over the
+	// original, or whose writes are not visible through the handle the follower
+	// holds.  Once the old file is drained a different file at the path picks up at
+	// the current offset if it starts with the same bytes and is at least that long,
+	// as when the old contents were rewritten with new data appended, otherwise it is
+	// read from the start.  A file that grew past what our handle reads is reopened at
+	// the current offset.  Zero disables the check.
+	ReopenCheck time.Duration
+	// MaxConcurrentHandlers bounds how many handler calls the followers of a
+	// single filter may have in flight at once, zero is unlimited.
+	MaxConcurrentHandlers int
+	// BatchSize hands records to the handler HandleBatch method in groups of up to
+	// BatchSize, the handler must implement HandleBatch.  A partial batch is handed
+	// over once BatchFlushInterval has passed since its first record, or as soon as
+	// the follower reaches the end of the file when the interval is zero, and when
+	// the follower is closed.  The state only moves past a batch once the handler
+	// accepted it, so a batch that was never accepted is read again on restart.
+	BatchSize          int
+	BatchFlushInterval time.Duration
+}
+
+// validate catches engine settings that would otherwise only fail once a follower
+// is launched
+func (fec FollowerEngineConfig) validate() error {
+	if _, err := parseDelimiter(fec.Delimiter); err != nil {
+		return err
+	} else if err = checkReadBufferSize(fec.ReadBufferSize); err != nil {
+		return err
+	} else if _, err = regexp.Compile(fec.MultilineStart); err != nil {
+		return fmt.Errorf("Invalid multiline start %q: %v", fec.MultilineStart, err)
+	} else if fec.Gzip && fec.Engine != LineEngine {
+		return ErrGzipEngine
+	} else if fec.Encoding == `` {
+		return nil
+	} else if fec.Gzip || fec.Engine != LineEngine {
+		return ErrEncodingEngine
+	}
+	_, err := lookupEncoding(fec.Encoding)
+	return err
 }
 
 type FollowerConfig struct {
@@ -48,52 +239,107 @@ type FollowerConfig struct {
 	State    *int64
 	FilterID int
 	Handler  handler
+	bus      *eventBus
+	sem      chan struct{}
+	gate     <-chan struct{} //follower does not start reading until this closes
+	dirty    *dirtyFlag      //set whenever the follower moves its state
+	counters *ioCounters     //manager wide throughput counters
+	idStrat  FileIdStrategy  //nil is the inode strategy
+	retry    RetryPolicy     //what to do when the handler fails
+	dlq      handler         //takes records the handler gave up on, nil drops them
+	trace    traceConfig     //spans around handler calls, see WithTracer
+	panics   PanicPolicy     //what to do when the handler panics
+	flim     *rate.Limiter   //read limit shared by every follower of the filter
+	glim     *rate.Limiter   //read limit shared by every follower of the manager
+	logger   ingest.IngestLogger
 }
 
 type follower struct {
 	FileName
-	filterId int
+	filterId int //protected by imtx, renumbered when filters are removed
 	id       FileId
 	lnr      Reader
+	rcfg     ReaderConfig
+	idStrat  FileIdStrategy
+	retry    RetryPolicy
+	dlq      handler
+	trace    traceConfig
+	panics   PanicPolicy
+	logger   ingest.IngestLogger
 	state    *int64
 	mtx      *sync.Mutex
+	imtx     *sync.Mutex //protects id and the name, which change on reopens and renames
 	running  int32
 	err      error
+	lastErr  error //last error that stopped the routine, protected by imtx
 	abortCh  chan bool
 	fsn      *fsnotify.Watcher
 	wg       *sync.WaitGroup
 	lh       handler
-	lastAct  time.Time
+	lastAct  int64 //unix nanoseconds of the last delivery, accessed atomically
+	mode     DeliveryMode
+	nextLh   *handlerSwap //handler waiting to take over, protected by imtx
+	swapping int32        //set while nextLh is waiting, accessed atomically
+	bus      *eventBus
+	ctx      context.Context
+	cancel   context.CancelFunc
+	catchup  *rate.Limiter
+	flim     *rate.Limiter
+	glim     *rate.Limiter
+	caughtUp bool
+	sem      chan struct{}
+	gate     <-chan struct{}
+	done     chan struct{} //closed once the follower catches up or is closed
+	parked   bool          //reader and watcher are closed to save descriptors, see park
+	parkSize int64         //size of the file when parked, see pendingData
+	paused   bool          //routine is stopped but the file stays open, see pause
+	suspend  bool          //the routine is being stopped to park or pause, so held records are not flushed
+	doneOnce *sync.Once
+	dirty    *dirtyFlag
+	counters *ioCounters
+	stripBOM bool
+	trimCR   bool
+	atStart  bool //the next record starts at offset zero
+
+	batchSize  int
+	batchIvl   time.Duration
+	batch      [][]byte  //records waiting on the handler
+	batchIdx   int64     //index just past the last record in the batch
+	batchOffs  []int64   //offset of each record in the batch, only kept when tracing
+	batchStart time.Time //when the first record in the batch was read
+
+	target       string //resolved target when following a symlink
+	symCheck     time.Duration
+	lastSymCheck time.Time
+	reopenIvl    time.Duration
+	lastReopen   time.Time
+	reopenHdr    FileHeader //start of the file we hold, tells a rewrite apart from a new file
 }
 
 func NewFollower(cfg FollowerConfig) (*follower, error) {
 	if cfg.State == nil {
 		return nil, errors.New("Invalid file state pointer")
 	}
-	fin, err := openDeletableFile(cfg.FilePath)
+	mode, err := handlerMode(cfg.FollowerEngineConfig, cfg.Handler)
 	if err != nil {
 		return nil, err
 	}
-	id, err := getFileId(fin)
-	if err != nil {
-		fin.Close()
-		return nil, err
-	}
-
-	if _, err := fin.Seek(*cfg.State, 0); err != nil {
-		fin.Close()
-		return nil, err
-	}
 	rdrCfg := ReaderConfig{
-		Fin:        fin,
-		MaxLineLen: defaultMaxLine,
-		StartIndex: *cfg.State,
-		Engine:     cfg.Engine,
-		EngineArgs: cfg.EngineArgs,
+		MaxLineLen:    defaultMaxLine,
+		Engine:        cfg.Engine,
+		EngineArgs:    cfg.EngineArgs,
+		KeepDelimiter: cfg.KeepDelimiter,
+		Delimiter:     cfg.Delimiter,
+
+		MultilineStart:    cfg.MultilineStart,
+		MaxMultilineBytes: cfg.MaxMultilineBytes,
+		FlushPartial:      cfg.FlushPartialOnClose,
+		Gzip:              cfg.Gzip,
+		Encoding:          cfg.Encoding,
+		BufferSize:        cfg.ReadBufferSize,
 	}
-	lnr, err := NewReader(rdrCfg)
+	lnr, id, err := openReader(cfg.FilePath, *cfg.State, rdrCfg, cfg.idStrat)
 	if err != nil {
-		fin.Close()
 		return nil, err
 	}
 
@@ -103,12 +349,35 @@ func NewFollower(cfg FollowerConfig) (*follower, error) {
 		return nil, err
 	}
 
+	var catchup *rate.Limiter
+	if cfg.CatchupRate > 0 {
+		catchup = rate.NewLimiter(rate.Limit(cfg.CatchupRate), cfg.CatchupRate)
+	}
+	var reopenHdr FileHeader
+	if cfg.ReopenCheck > 0 {
+		reopenHdr, _ = readHeader(cfg.FilePath, HeaderCheckBytes) //taken again on the first check if this fails
+	}
+	var target string
+	if cfg.SymlinkRecheck > 0 {
+		//only track targets of paths that are actually links
+		if fi, err := os.Lstat(cfg.FilePath); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			if target, err = filepath.EvalSymlinks(cfg.FilePath); err != nil {
+				lnr.Close()
+				wtchr.Close()
+				return nil, err
+			}
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
 	//open the file for reading and get
 	return &follower{
 		filterId: cfg.FilterID,
 		id:       id,
 		lnr:      lnr,
+		rcfg:     rdrCfg,
 		mtx:      &sync.Mutex{},
+		imtx:     &sync.Mutex{},
 		wg:       &sync.WaitGroup{},
 		fsn:      wtchr,
 		lh:       cfg.Handler,
@@ -117,18 +386,257 @@ func NewFollower(cfg FollowerConfig) (*follower, error) {
 			FilePath: cfg.FilePath,
 			BaseName: cfg.BaseName,
 		},
-		lastAct: time.Now(),
+		lastAct:  time.Now().UnixNano(),
+		mode:     mode,
+		bus:      cfg.bus,
+		ctx:      ctx,
+		cancel:   cancel,
+		catchup:  catchup,
+		flim:     cfg.flim,
+		glim:     cfg.glim,
+		sem:      cfg.sem,
+		gate:     cfg.gate,
+		done:     make(chan struct{}),
+		doneOnce: &sync.Once{},
+		dirty:    cfg.dirty,
+		counters: cfg.counters,
+		idStrat:  cfg.idStrat,
+		retry:    cfg.retry,
+		dlq:      cfg.dlq,
+		trace:    cfg.trace,
+		panics:   cfg.panics,
+		logger:   cfg.logger,
+		stripBOM: cfg.StripBOM,
+		trimCR:   cfg.TrimCR,
+		atStart:  *cfg.State == 0,
+		target:   target,
+		symCheck: cfg.SymlinkRecheck,
+
+		reopenIvl: cfg.ReopenCheck,
+		reopenHdr: reopenHdr,
+		batchSize: cfg.BatchSize,
+		batchIvl:  cfg.BatchFlushInterval,
 	}, nil
 }
 
+// openReader opens the file at fpath and builds a reader positioned at idx
+func openReader(fpath string, idx int64, rcfg ReaderConfig, strat FileIdStrategy) (Reader, FileId, error) {
+	fin, err := openDeletableFile(fpath)
+	if err != nil {
+		return nil, FileId{}, err
+	}
+	if strat == nil {
+		strat = InodeIdStrategy{}
+	}
+	id, err := strat.FileId(fin)
+	if err != nil {
+		fin.Close()
+		return nil, id, err
+	}
+	if _, err := fin.Seek(idx, 0); err != nil {
+		fin.Close()
+		return nil, id, err
+	}
+	rcfg.Fin = fin
+	rcfg.StartIndex = idx
+	lnr, err := NewReader(rcfg)
+	if err != nil {
+		fin.Close()
+		return nil, id, err
+	}
+	return lnr, id, nil
+}
+
 func (f *follower) FilterId() int {
+	f.imtx.Lock()
+	defer f.imtx.Unlock()
 	return f.filterId
 }
 
+// setFilterId points the follower at a renumbered filter
+func (f *follower) setFilterId(id int) {
+	f.imtx.Lock()
+	f.filterId = id
+	f.imtx.Unlock()
+}
+
+// name returns the name of the followed file, the manager changes it on renames
+func (f *follower) name() FileName {
+	f.imtx.Lock()
+	defer f.imtx.Unlock()
+	return f.FileName
+}
+
+// rename points the follower at the new name of its file
+func (f *follower) rename(fn FileName) {
+	f.imtx.Lock()
+	f.FileName = fn
+	f.imtx.Unlock()
+}
+
 func (f *follower) FileId() FileId {
+	f.imtx.Lock()
+	defer f.imtx.Unlock()
 	return f.id
 }
 
+// handlerSwap is a handler waiting to replace the one a follower delivers to
+type handlerSwap struct {
+	lh   handler
+	mode DeliveryMode
+}
+
+// setHandler queues lh to take over delivery, the routine switches before its next
+// record so records already handed off or batched finish with the old handler
+func (f *follower) setHandler(lh handler, mode DeliveryMode) {
+	f.imtx.Lock()
+	f.nextLh = &handlerSwap{lh: lh, mode: mode}
+	atomic.StoreInt32(&f.swapping, 1)
+	f.imtx.Unlock()
+}
+
+// takeHandler switches to a handler queued by setHandler once the pending batch has
+// gone to the old one
+// only the follower routine may call this
+func (f *follower) takeHandler() error {
+	if atomic.LoadInt32(&f.swapping) == 0 {
+		return nil
+	} else if err := f.flushBatch(); err != nil {
+		return err
+	}
+	f.imtx.Lock()
+	if f.nextLh != nil {
+		f.lh, f.mode = f.nextLh.lh, f.nextLh.mode
+		f.nextLh = nil
+	}
+	atomic.StoreInt32(&f.swapping, 0)
+	f.imtx.Unlock()
+	return nil
+}
+
+// reopen swaps the reader out for a freshly opened handle on our path positioned at idx
+// and points the notification watcher at whatever the path currently resolves to
+// only the follower routine may call this
+func (f *follower) reopen(idx int64) error {
+	fpath := f.name().FilePath
+	lnr, id, err := openReader(fpath, idx, f.rcfg, f.idStrat)
+	if err != nil {
+		return err
+	}
+	//drop the watch first, closing the last handle on a replaced file reports a removal
+	f.fsn.Remove(fpath)
+	f.lnr.Close()
+	f.lnr = lnr
+	f.atStart = idx == 0
+	f.imtx.Lock()
+	f.id = id
+	f.imtx.Unlock()
+	return f.fsn.Add(fpath)
+}
+
+// checkSymlink re-resolves a followed symlink, if it was repointed we drain the old
+// target and switch to reading the new one from the start
+func (f *follower) checkSymlink() error {
+	if f.target == `` || time.Since(f.lastSymCheck) < f.symCheck {
+		return nil
+	}
+	f.lastSymCheck = time.Now()
+	target, err := filepath.EvalSymlinks(f.name().FilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil //dangling link, wait for it to come back
+		}
+		return err
+	} else if target == f.target {
+		return nil
+	}
+	if err := f.processLines(false); err != nil {
+		return err
+	}
+	if err := f.reopen(0); err != nil {
+		return err
+	}
+	f.target = target
+	atomic.StoreInt64(f.state, 0)
+	f.dirty.set()
+	f.bus.emit(FollowerEvent{
+		Type: EventSymlinkRetargeted,
+		Name: f.name(),
+		Path: target,
+	})
+	return nil
+}
+
+// checkReopen stats our path and reopens it if the path now holds a different file,
+// or a larger file than our handle reads.  The old file is drained before a new one
+// is read from the start.
+// only the follower routine may call this
+func (f *follower) checkReopen() error {
+	if f.reopenIvl <= 0 || time.Since(f.lastReopen) < f.reopenIvl {
+		return nil
+	}
+	f.lastReopen = time.Now()
+	fpath := f.name().FilePath
+	fi, err := os.Stat(fpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil //mid replacement or removed, the remove notification handles the latter
+		}
+		return err
+	}
+	id, err := fileIdFromName(f.idStrat, fpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return err
+	}
+	if id == f.FileId() {
+		if f.reopenHdr.Len < HeaderCheckBytes && f.reopenHdr.Len < fi.Size() {
+			if h, err := readHeader(fpath, HeaderCheckBytes); err == nil {
+				f.reopenHdr = h
+			}
+		}
+		if fi.Size() <= f.lnr.Index() {
+			return nil
+		}
+		//read what we can see first, a stale handle comes up short
+		if err = f.processLines(false); err != nil || fi.Size() <= f.lnr.Index() {
+			return err
+		}
+		//held records were not acknowledged, reopen at the last record handed over
+		if err = f.flushBatch(); err != nil {
+			return err
+		}
+		return f.reopen(atomic.LoadInt64(f.state))
+	}
+	if err = f.processLines(false); err != nil {
+		return err
+	} else if err = f.flushBatch(); err != nil {
+		return err
+	}
+	off := atomic.LoadInt64(f.state)
+	replaced := fi.Size() < off || headerChanged(fpath, f.reopenHdr)
+	if replaced {
+		off = 0
+	}
+	if err = f.reopen(off); err != nil {
+		return err
+	}
+	f.reopenHdr, _ = readHeader(fpath, HeaderCheckBytes)
+	if !replaced {
+		return nil //the old contents were rewritten, carry on where we were
+	}
+	atomic.StoreInt64(f.state, 0)
+	f.dirty.set()
+	f.bus.emit(FollowerEvent{
+		Type: EventReplaced,
+		Name: f.name(),
+	})
+	f.counters.rotation()
+	return nil
+}
+
 func (f *follower) Start() error {
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
@@ -138,9 +646,12 @@ func (f *follower) Start() error {
 	if f.abortCh != nil || f.running != 0 {
 		return ErrAlreadyStarted
 	}
-	if err := f.fsn.Add(f.FilePath); err != nil {
+	if err := f.fsn.Add(f.name().FilePath); err != nil {
 		return err
 	}
+	if f.ctx.Err() != nil {
+		f.ctx, f.cancel = context.WithCancel(context.Background())
+	}
 	f.abortCh = make(chan bool, 1)
 	f.running = 1
 	f.wg.Add(1)
@@ -159,10 +670,26 @@ func (f *follower) Stop() error {
 }
 
 func (f *follower) stop() {
+	if f.suspend {
+		f.cancel() //nothing is drained, kick anything blocked waiting on a limiter
+	}
 	if atomic.LoadInt32(&f.running) != 0 {
 		f.abortCh <- true
-		f.wg.Wait()
+		//the final drain runs with a live context so limiters and the handler
+		//semaphore are honored, only a drain that is stuck gets cancelled
+		done := make(chan struct{})
+		go func() {
+			f.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(stopDrainTimeout):
+			f.cancel()
+			<-done
+		}
 	}
+	f.cancel()
 	close(f.abortCh)
 	f.abortCh = nil
 	f.running = 0
@@ -175,6 +702,11 @@ func (f *follower) Close() error {
 	if f.abortCh != nil && atomic.LoadInt32(&f.running) != 0 {
 		f.stop()
 	}
+	f.cancel()
+	f.markDone()
+	if f.parked {
+		return f.err //descriptors were already released
+	}
 	if err := f.fsn.Close(); err != nil {
 		f.err = err
 	}
@@ -184,6 +716,111 @@ func (f *follower) Close() error {
 	return f.err
 }
 
+// pause stops the follower routine but keeps the file open, records that were read
+// but not handed to the handler are dropped and read again by resume
+func (f *follower) pause() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.paused {
+		return nil
+	} else if f.parked || f.abortCh == nil || atomic.LoadInt32(&f.running) == 0 {
+		return ErrNotRunning
+	}
+	f.suspend = true
+	f.stop()
+	f.suspend = false
+	f.paused = true
+	f.batch, f.batchOffs = nil, nil
+	return nil
+}
+
+// resume restarts a paused follower at its current state
+func (f *follower) resume() error {
+	f.mtx.Lock()
+	if !f.paused {
+		f.mtx.Unlock()
+		return nil
+	}
+	idx := atomic.LoadInt64(f.state)
+	if err := f.lnr.SeekFile(idx); err != nil {
+		f.mtx.Unlock()
+		return err
+	}
+	f.atStart = idx == 0
+	f.paused = false
+	f.mtx.Unlock()
+	return f.Start()
+}
+
+// seek moves the follower to idx, the routine is stopped while the reader moves and
+// then picks up reading at idx.  Paused and parked followers stay that way and read
+// from idx once they are resumed.
+func (f *follower) seek(idx int64) error {
+	f.mtx.Lock()
+	restart := f.abortCh != nil
+	if restart {
+		f.suspend = true
+		f.stop()
+		f.suspend = false
+	}
+	f.batch, f.batchOffs = nil, nil
+	atomic.StoreInt64(f.state, idx)
+	f.dirty.set()
+	f.atStart = idx == 0
+	if !f.parked {
+		if err := f.lnr.SeekFile(idx); err != nil {
+			f.mtx.Unlock()
+			return err
+		}
+	}
+	f.mtx.Unlock()
+	if restart {
+		return f.Start()
+	}
+	return nil
+}
+
+// nextBoundary returns the start of the first record at or after offset, only plain
+// files read with the line engine have boundaries we can find in the raw bytes
+func (f *follower) nextBoundary(offset int64) (int64, error) {
+	if f.rcfg.Engine != LineEngine || f.rcfg.Gzip || f.rcfg.Encoding != `` || f.rcfg.MultilineStart != `` {
+		return 0, ErrSnapUnsupported
+	}
+	delim, err := parseDelimiter(f.rcfg.Delimiter)
+	if err != nil {
+		return 0, err
+	} else if offset == 0 {
+		return 0, nil
+	}
+	fin, err := os.Open(f.name().FilePath)
+	if err != nil {
+		return 0, err
+	}
+	defer fin.Close()
+	//a record starts at offset if the byte before it ends one
+	if _, err = fin.Seek(offset-1, io.SeekStart); err != nil {
+		return 0, err
+	}
+	brdr := bufio.NewReader(fin)
+	pos := offset - 1
+	for {
+		ln, err := brdr.ReadSlice(delim)
+		pos += int64(len(ln))
+		if err == nil {
+			return pos, nil
+		} else if err == io.EOF {
+			return 0, ErrNoBoundary
+		} else if err != bufio.ErrBufferFull {
+			return 0, err
+		}
+	}
+}
+
+// markDone releases anything gated on this follower
+func (f *follower) markDone() {
+	f.doneOnce.Do(func() { close(f.done) })
+}
+
 func (f *follower) Running() bool {
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
@@ -193,8 +830,20 @@ func (f *follower) Running() bool {
 	return true
 }
 
+// offset returns the index of the last record accepted by the handler
+func (f *follower) offset() int64 {
+	return atomic.LoadInt64(f.state)
+}
+
+// lastError returns the last error that stopped the follower routine
+func (f *follower) lastError() error {
+	f.imtx.Lock()
+	defer f.imtx.Unlock()
+	return f.lastErr
+}
+
 func (f *follower) IdleDuration() time.Duration {
-	return time.Since(f.lastAct)
+	return time.Since(time.Unix(0, atomic.LoadInt64(&f.lastAct)))
 }
 
 // writeEvent should be set to true if we're calling this as a result of
@@ -204,36 +853,270 @@ func (f *follower) IdleDuration() time.Duration {
 func (f *follower) processLines(writeEvent bool) error {
 	var hit bool
 	for {
+		start := f.lnr.Index()
 		ln, ok, sawEOF, err := f.lnr.ReadEntry()
 		if err != nil {
+			f.counters.readError()
 			return err
 		}
+		f.counters.addBytes(f.lnr.Index() - start)
 		if sawEOF && writeEvent {
 			// We got an EOF on the file after a write
-			fi, err := os.Stat(f.FilePath)
-			if err != nil {
+			if trunc, err := f.checkTruncate(); err != nil {
+				if errors.Is(err, context.Canceled) {
+					return nil
+				}
 				return err
+			} else if trunc && !ok {
+				continue //read whatever was written after the truncation
 			}
-			if fi.Size() < *f.state {
-				// the file must have been truncated
-				*f.state = 0
-				if err = f.lnr.SeekFile(0); err != nil {
+		}
+		if !ok {
+			if f.batchIvl <= 0 {
+				if err := f.flushBatch(); err != nil {
+					if errors.Is(err, context.Canceled) {
+						return nil //shutting down, the batch is read again on restart
+					}
 					return err
 				}
 			}
-		}
-		if !ok {
+			if sawEOF && !f.caughtUp && len(f.batch) == 0 {
+				f.caughtUp = true
+				f.markDone()
+				f.bus.emit(FollowerEvent{
+					Type: EventCaughtUp,
+					Name: f.name(),
+				})
+			}
 			break
 		}
+		if !f.caughtUp && f.catchup != nil {
+			if err := waitBytes(f.ctx, f.catchup, len(ln)); err != nil {
+				if f.ctx.Err() != nil {
+					return nil //shutting down, the state was not advanced
+				}
+				return err
+			}
+		}
+		if err := f.throttle(int(f.lnr.Index() - start)); err != nil {
+			if f.ctx.Err() != nil {
+				return nil //shutting down, the state was not advanced
+			}
+			return err
+		}
 		//actually handle the line
-		if err := f.lh.HandleLog(ln, time.Now()); err != nil {
+		if err := f.accept(f.normalize(ln), start); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil //shutting down while waiting on the handler semaphore or the handler
+			}
 			return err
 		}
-		*f.state = f.lnr.Index()
 		hit = true
 	}
 	if hit {
-		f.lastAct = time.Now()
+		atomic.StoreInt64(&f.lastAct, time.Now().UnixNano())
+	}
+	return nil
+}
+
+// checkTruncate resets the follower to the start of the file if the file shrank below
+// the read position, copytruncate style rotation empties the file in place so the
+// file id does not change
+// only the follower routine may call this
+func (f *follower) checkTruncate() (bool, error) {
+	fi, err := os.Stat(f.name().FilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil //renamed away while we still hold it open, keep reading
+		}
+		return false, err
+	}
+	if fi.Size() >= f.lnr.Index() {
+		return false, nil
+	} else if f.reopenIvl > 0 {
+		//a different file at our path is not a truncation, checkReopen switches to it
+		if id, err := fileIdFromName(f.idStrat, f.name().FilePath); err == nil && id != f.FileId() {
+			return false, nil
+		}
+	}
+	//hand over what was read before the truncation
+	if err = f.flushBatch(); err != nil {
+		return false, err
+	}
+	atomic.StoreInt64(f.state, 0)
+	f.dirty.set()
+	if err = f.lnr.SeekFile(0); err != nil {
+		return false, err
+	}
+	f.atStart = true
+	f.bus.emit(FollowerEvent{
+		Type: EventTruncated,
+		Name: f.name(),
+	})
+	f.counters.rotation()
+	return true, nil
+}
+
+// normalize applies StripBOM and TrimCR to a record that is about to be delivered
+// only the follower routine may call this
+func (f *follower) normalize(ln []byte) []byte {
+	if f.atStart {
+		f.atStart = false
+		if f.stripBOM {
+			ln = bytes.TrimPrefix(ln, []byte(utf8BOM))
+		}
+	}
+	if f.trimCR {
+		if bytes.HasSuffix(ln, []byte("\r\n")) {
+			ln = append(ln[:len(ln)-2:len(ln)-2], '\n') //delimiter was kept, copy so the reader buffer is untouched
+		} else {
+			ln = bytes.TrimSuffix(ln, []byte("\r"))
+		}
+	}
+	return ln
+}
+
+// accept delivers a record or adds it to the pending batch, the state only moves
+// once the handler has taken the record
+// only the follower routine may call this
+func (f *follower) accept(ln []byte, start int64) error {
+	if err := f.takeHandler(); err != nil {
+		return err
+	}
+	if f.batchSize <= 0 {
+		recs := [][]byte{ln}
+		delivered, err := f.handle(recs, func() error {
+			return f.traced(recs, []int64{start}, func(ctx context.Context) error {
+				return f.deliver(ctx, ln, start)
+			})
+		})
+		if err != nil {
+			return err
+		} else if delivered {
+			f.counters.addRecords(1)
+		}
+		atomic.StoreInt64(f.state, f.lnr.Index())
+		f.dirty.set()
+		return nil
+	}
+	if len(f.batch) == 0 {
+		f.batchStart = time.Now()
+	}
+	//readers reuse their buffers so the record has to be copied
+	f.batch = append(f.batch, append([]byte(nil), ln...))
+	if f.trace.tracer != nil {
+		f.batchOffs = append(f.batchOffs, start)
+	}
+	f.batchIdx = f.lnr.Index()
+	if len(f.batch) < f.batchSize {
+		return nil
+	}
+	return f.flushBatch()
+}
+
+// flushBatch hands the pending batch to the handler and moves the state past it
+// only the follower routine may call this
+func (f *follower) flushBatch() error {
+	if len(f.batch) == 0 {
+		return nil
+	}
+	delivered, err := f.handle(f.batch, func() error {
+		return f.traced(f.batch, f.batchOffs, func(context.Context) error {
+			return f.guard(func() error {
+				return f.lh.(batchHandler).HandleBatch(f.batch, time.Now())
+			})
+		})
+	})
+	if err != nil {
+		return err
+	} else if delivered {
+		f.counters.addRecords(len(f.batch))
+	}
+	f.batch, f.batchOffs = nil, nil
+	atomic.StoreInt64(f.state, f.batchIdx)
+	f.dirty.set()
+	return nil
+}
+
+// batchDue returns how long until the pending batch must be flushed
+func (f *follower) batchDue() (time.Duration, bool) {
+	if len(f.batch) == 0 || f.batchIvl <= 0 {
+		return 0, false
+	}
+	return f.batchIvl - time.Since(f.batchStart), true
+}
+
+// deliver hands a record that was read starting at off to the handler using the
+// resolved delivery mode, context handlers are given ctx
+func (f *follower) deliver(ctx context.Context, ln []byte, off int64) error {
+	return f.guard(func() error {
+		switch f.mode {
+		case DeliveryLine:
+			return f.lh.HandleLog(ln, time.Now())
+		case DeliverySource:
+			name := f.name()
+			return f.lh.(sourceHandler).HandleSourceLog(ln, time.Now(), RecordSource{
+				FilePath: name.FilePath,
+				BaseName: name.BaseName,
+				FilterID: f.FilterId(),
+				Offset:   off,
+			})
+		case DeliveryContext:
+			return f.lh.(ContextHandler).HandleLogContext(ctx, ln, time.Now())
+		}
+		return ErrUnsupportedDelivery
+	})
+}
+
+// guard runs a handler call while holding the handler semaphore, a panicking
+// handler is recovered and reported as an error
+func (f *follower) guard(fn func() error) (err error) {
+	if f.sem != nil {
+		select {
+		case f.sem <- struct{}{}:
+		case <-f.ctx.Done():
+			return f.ctx.Err()
+		}
+		defer func() { <-f.sem }()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrHandlerPanic, r)
+			f.counters.handlerPanic()
+			if f.logger != nil {
+				name := f.name()
+				f.logger.Error("Handler for %v on %v panicked: %v", name.BaseName, name.FilePath, r)
+			}
+		}
+	}()
+	return fn()
+}
+
+// throttle blocks until the filter and manager read limits allow another n bytes
+// only the follower routine may call this
+func (f *follower) throttle(n int) error {
+	for _, lim := range []*rate.Limiter{f.flim, f.glim} {
+		if lim == nil || lim.Limit() == rate.Inf {
+			continue
+		} else if err := waitBytes(f.ctx, lim, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitBytes blocks until the limiter allows n bytes, requests larger than
+// the burst size are broken into burst sized chunks
+func waitBytes(ctx context.Context, lim *rate.Limiter, n int) error {
+	for n > 0 {
+		chunk := n
+		if b := lim.Burst(); chunk > b {
+			chunk = b
+		}
+		if err := lim.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+		n -= chunk
 	}
 	return nil
 }
@@ -243,7 +1126,26 @@ func (f *follower) routine() {
 	defer func(r *int32) {
 		atomic.CompareAndSwapInt32(r, 1, 0)
 	}(&f.running)
-	tckr := time.NewTicker(tickInterval)
+	defer func() {
+		if f.err != nil {
+			f.imtx.Lock()
+			f.lastErr = f.err
+			f.imtx.Unlock()
+		}
+	}()
+	if f.gate != nil {
+		//wait for the file ahead of us to be read
+		select {
+		case <-f.gate:
+		case <-f.abortCh:
+			return
+		}
+	}
+	ivl := tickInterval
+	if f.reopenIvl > 0 && f.reopenIvl < ivl {
+		ivl = f.reopenIvl //tick often enough to honor the reopen check
+	}
+	tckr := time.NewTicker(ivl)
 	defer tckr.Stop()
 
 routineLoop:
@@ -255,7 +1157,17 @@ routineLoop:
 			}
 			return
 		}
+		var batchC <-chan time.Time
+		if d, ok := f.batchDue(); ok {
+			batchC = time.After(d)
+		}
 		select {
+		case <-batchC:
+			if err := f.flushBatch(); err != nil && !errors.Is(err, context.Canceled) {
+				f.lnr.Close()
+				f.err = err
+				return
+			}
 		case err, ok := <-f.fsn.Errors:
 			if !ok {
 				break routineLoop
@@ -273,6 +1185,10 @@ routineLoop:
 						f.err = err
 					}
 				}
+				//the file is gone so nothing else can complete a held record
+				if err := f.finalFlush(); err != nil {
+					f.err = err
+				}
 				//On remove we close the liner and bail out
 				f.err = f.lnr.Close()
 				return
@@ -286,6 +1202,18 @@ routineLoop:
 				}
 			}
 		case _ = <-tckr.C:
+			if err := f.checkSymlink(); err != nil {
+				f.err = err
+				break routineLoop
+			} else if err = f.checkReopen(); err != nil && !errors.Is(err, context.Canceled) {
+				f.err = err
+				break routineLoop
+			}
+			//a truncation is not always followed by a write notification
+			if _, err := f.checkTruncate(); err != nil && !errors.Is(err, context.Canceled) {
+				f.err = err
+				break routineLoop
+			}
 			//just loop and attempt to get some lines
 			//this is purely to deal with race conditions where lines come in when we are starting up
 			//causing us to miss the event
@@ -294,11 +1222,51 @@ routineLoop:
 			break routineLoop
 		}
 	}
+	if f.suspend {
+		return //anything not yet handed over is read again when we are resumed
+	}
 	//this whole process is kind of racy, so every iteration we attempt to process lines
 	if err := f.processLines(false); err != nil {
 		//check if its just a notexists erro, which Windows version of the liner will throw
 		if !os.IsNotExist(err) {
 			f.err = err
 		}
+	} else if err := f.finalFlush(); err != nil {
+		f.err = err
+	}
+}
+
+// flushEntry delivers anything the reader is holding back as final records
+// only the follower routine may call this
+func (f *follower) flushEntry() error {
+	ef, ok := f.lnr.(entryFlusher)
+	if !ok {
+		return nil
+	}
+	for {
+		start := f.lnr.Index()
+		ln, ok := ef.FlushEntry()
+		if !ok {
+			return nil
+		}
+		if err := f.accept(f.normalize(ln), start); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil //the handler semaphore is gone, the record is reread on restart
+			}
+			return err
+		}
 	}
 }
+
+// finalFlush hands over held back records and the pending batch when the follower
+// is going away
+// only the follower routine may call this
+func (f *follower) finalFlush() error {
+	if err := f.flushEntry(); err != nil {
+		return err
+	}
+	if err := f.flushBatch(); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
@@ -9,10 +9,21 @@
 package filewatch
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -249,3 +260,566 @@ func (h *trackingLH) HandleLog(b []byte, ts time.Time) error {
 	}
 	return nil
 }
+
+func TestCatchupEvent(t *testing.T) {
+	var tlh trackingLH
+	var state int64
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(fname)
+	//write the backlog before we start following
+	_, mp, err := writeLines(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := newEventBus()
+	evts := bus.channel()
+	fcfg := FollowerConfig{
+		FollowerEngineConfig: FollowerEngineConfig{
+			CatchupRate: 1024 * 1024,
+		},
+		BaseName: baseName,
+		FilePath: fname,
+		State:    &state,
+		Handler:  &tlh,
+		bus:      bus,
+	}
+	fl, err := NewFollower(fcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case evt := <-evts:
+		if evt.Type != EventCaughtUp || evt.Name.FilePath != fname {
+			t.Fatalf("bad event: %+v", evt)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for caught up event")
+	}
+	if err := fl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(tlh.mp) != len(mp) {
+		t.Fatalf("missed lines during catch up: %d != %d", len(tlh.mp), len(mp))
+	}
+}
+
+func TestCloseDrainsWithLimits(t *testing.T) {
+	var lh orderedLH
+	var state int64
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(fname)
+	var want []string
+	var bb bytes.Buffer
+	for i := 0; i < 100; i++ {
+		want = append(want, fmt.Sprintf("line %03d", i))
+		bb.WriteString(want[i] + "\n")
+	}
+	if err := ioutil.WriteFile(fname, bb.Bytes(), 0660); err != nil {
+		t.Fatal(err)
+	}
+	//slow enough that the backlog is still being read when we close
+	fcfg := FollowerConfig{
+		FollowerEngineConfig: FollowerEngineConfig{
+			CatchupRate: 4096,
+		},
+		BaseName: baseName,
+		FilePath: fname,
+		State:    &state,
+		Handler:  &lh,
+		flim:     rate.NewLimiter(4096, 256),
+		sem:      make(chan struct{}, 1),
+	}
+	fl, err := NewFollower(fcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if lines := lh.take(); !reflect.DeepEqual(lines, want) {
+		t.Fatalf("close dropped lines, got %d of %d", len(lines), len(want))
+	} else if state != int64(bb.Len()) {
+		t.Fatal("bad state after close", state)
+	}
+}
+
+func TestDeliveryMode(t *testing.T) {
+	var clh countingLH
+	var state int64
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(fname)
+	fcfg := FollowerConfig{
+		BaseName: baseName,
+		FilePath: fname,
+		State:    &state,
+		Handler:  &clh,
+	}
+	//auto resolves to plain line delivery
+	fl, err := NewFollower(fcfg)
+	if err != nil {
+		t.Fatal(err)
+	} else if fl.mode != DeliveryLine {
+		t.Fatal("auto delivery did not resolve to line delivery", fl.mode)
+	}
+	if err := fl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fcfg.Delivery = DeliveryMode(1000)
+	if _, err := NewFollower(fcfg); err != ErrUnsupportedDelivery {
+		t.Fatal("unsupported delivery mode was not rejected", err)
+	}
+	fcfg.Delivery = DeliverySource
+	if _, err := NewFollower(fcfg); err != ErrUnsupportedDelivery {
+		t.Fatal("source delivery accepted a plain handler", err)
+	}
+	//handlers that take the source get it automatically
+	fcfg.Delivery = DeliveryAuto
+	fcfg.Handler = SourceHandlerFunc(func([]byte, time.Time, RecordSource) error { return nil })
+	if fl, err = NewFollower(fcfg); err != nil {
+		t.Fatal(err)
+	} else if fl.mode != DeliverySource {
+		t.Fatal("auto delivery did not resolve to source delivery", fl.mode)
+	}
+	if err := fl.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSourceDelivery(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := ioutil.WriteFile(fname, []byte("a\n\nbb\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	var mtx sync.Mutex
+	var srcs []RecordSource
+	lh := SourceHandlerFunc(func(b []byte, ts time.Time, src RecordSource) error {
+		mtx.Lock()
+		srcs = append(srcs, src)
+		mtx.Unlock()
+		return nil
+	})
+	if err := fm.AddFilter(baseName, filepath.Dir(fname), []string{filepath.Base(fname)}, lh, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(fname); err != nil || !ok {
+		t.Fatal("failed to load", ok, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := fm.WaitCaughtUp(ctx, FileName{BaseName: baseName, FilePath: fname}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	//the empty line is skipped, so the second record is read starting at the end of the first
+	expect := []RecordSource{
+		{FilePath: fname, BaseName: baseName, Offset: 0},
+		{FilePath: fname, BaseName: baseName, Offset: 2},
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	if !reflect.DeepEqual(srcs, expect) {
+		t.Fatalf("bad sources %+v", srcs)
+	}
+}
+
+func TestSymlinkRetarget(t *testing.T) {
+	dir, err := ioutil.TempDir(tempPath, `symlinks`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	first, second := filepath.Join(dir, `first`), filepath.Join(dir, `second`)
+	link := filepath.Join(dir, `current`)
+	if err := ioutil.WriteFile(first, []byte("first\n"), 0660); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(second, []byte("second\n"), 0660); err != nil {
+		t.Fatal(err)
+	} else if err := os.Symlink(first, link); err != nil {
+		t.Fatal(err)
+	}
+
+	lh := newSafeTrackingLH()
+	bus := newEventBus()
+	evts := bus.channel()
+	var state int64
+	fl, err := NewFollower(FollowerConfig{
+		FollowerEngineConfig: FollowerEngineConfig{
+			SymlinkRecheck: time.Millisecond,
+		},
+		BaseName: baseName,
+		FilePath: link,
+		State:    &state,
+		Handler:  lh,
+		bus:      bus,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	//atomically swap the link over to the second file
+	if err := os.Symlink(second, link+`.tmp`); err != nil {
+		t.Fatal(err)
+	} else if err := os.Rename(link+`.tmp`, link); err != nil {
+		t.Fatal(err)
+	}
+	var evt FollowerEvent
+	for evt.Type != EventSymlinkRetargeted {
+		select {
+		case evt = <-evts:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for retarget event")
+		}
+	}
+	if evt.Path != second {
+		t.Fatal("bad retarget path", evt.Path)
+	}
+	if err := fl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if lh.Len() != 2 {
+		t.Fatal("did not read both targets", lh.mp)
+	}
+	if state != int64(len("second\n")) {
+		t.Fatal("state was not reset for new target", state)
+	}
+}
+
+func TestContextHandlerClose(t *testing.T) {
+	blh := newBlockingLH()
+	defer close(blh.release)
+	started := make(chan struct{})
+	var once sync.Once
+	hnds := []struct {
+		name    string
+		lh      handler
+		started chan struct{}
+	}{
+		{`context`, ContextHandlerFunc(func(ctx context.Context, b []byte, ts time.Time) error {
+			once.Do(func() { close(started) })
+			<-ctx.Done()
+			return ctx.Err()
+		}), started},
+		{`shim`, NewHandlerShim(blh), blh.started},
+	}
+	for _, h := range hnds {
+		name, lh := h.name, h.lh
+		var state int64
+		fname, err := newFileName()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fname, []byte("a\nb\n"), 0660); err != nil {
+			t.Fatal(err)
+		}
+		fl, err := NewFollower(FollowerConfig{
+			BaseName: baseName,
+			FilePath: fname,
+			State:    &state,
+			Handler:  lh,
+		})
+		if err != nil {
+			t.Fatal(err)
+		} else if fl.mode != DeliveryContext {
+			t.Fatal(name, "bad delivery mode", fl.mode)
+		}
+		if err := fl.Start(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-h.started:
+		case <-time.After(5 * time.Second):
+			t.Fatal(name, "handler never called")
+		}
+		closed := make(chan error, 1)
+		go func() { closed <- fl.Close() }()
+		select {
+		case err := <-closed:
+			if err != nil {
+				t.Fatal(name, err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal(name, "close blocked on the handler")
+		}
+		//the record was never accepted so it is read again on restart
+		if state != 0 {
+			t.Fatal(name, "state advanced past an unacknowledged record", state)
+		}
+		cleanFile(fname, t)
+	}
+}
+
+type batchLH struct {
+	sync.Mutex
+	batches [][]string
+	fail    int //fail the batch with this number, counting from one
+}
+
+func (b *batchLH) HandleLog(ln []byte, ts time.Time) error {
+	return b.HandleBatch([][]byte{ln}, ts)
+}
+
+func (b *batchLH) HandleBatch(lns [][]byte, ts time.Time) error {
+	b.Lock()
+	defer b.Unlock()
+	if len(b.batches)+1 == b.fail {
+		return errors.New("batch rejected")
+	}
+	var batch []string
+	for _, ln := range lns {
+		batch = append(batch, string(ln))
+	}
+	b.batches = append(b.batches, batch)
+	return nil
+}
+
+func (b *batchLH) take() (r [][]string) {
+	b.Lock()
+	defer b.Unlock()
+	r, b.batches = b.batches, nil
+	return
+}
+
+func TestBatchDelivery(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := ioutil.WriteFile(fname, []byte("a\nb\nc\nd\ne\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	//plain handlers cannot take batches
+	var state int64
+	if _, err := NewFollower(FollowerConfig{
+		FollowerEngineConfig: FollowerEngineConfig{BatchSize: 2},
+		BaseName:             baseName,
+		FilePath:             fname,
+		State:                &state,
+		Handler:              &countingLH{},
+	}); err != ErrUnsupportedDelivery {
+		t.Fatal("batching accepted a plain handler", err)
+	}
+	lh := &batchLH{}
+	ecfg := FollowerEngineConfig{BatchSize: 2, BatchFlushInterval: 100 * time.Millisecond}
+	if err := fm.AddFilter(baseName, filepath.Dir(fname), []string{filepath.Base(fname)}, lh, ecfg); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(fname); err != nil || !ok {
+		t.Fatal("failed to load", ok, err)
+	}
+	stid := FileName{BaseName: baseName, FilePath: fname}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	//the trailing partial batch goes out once the interval passes
+	if err := fm.WaitCaughtUp(ctx, stid); err != nil {
+		t.Fatal(err)
+	}
+	if b := lh.take(); !reflect.DeepEqual(b, [][]string{{`a`, `b`}, {`c`, `d`}, {`e`}}) {
+		t.Fatalf("bad batches %q", b)
+	}
+	//a pending batch is handed over on close
+	fout, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fout.WriteString("f\n"); err != nil {
+		t.Fatal(err)
+	}
+	fout.Close()
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b := lh.take(); !reflect.DeepEqual(b, [][]string{{`f`}}) {
+		t.Fatalf("bad batches on close %q", b)
+	}
+}
+
+func TestBatchUnacked(t *testing.T) {
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := ioutil.WriteFile(fname, []byte("a\nb\nc\nd\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	var state int64
+	lh := &batchLH{fail: 2}
+	fl, err := NewFollower(FollowerConfig{
+		FollowerEngineConfig: FollowerEngineConfig{BatchSize: 2},
+		BaseName:             baseName,
+		FilePath:             fname,
+		State:                &state,
+		Handler:              lh,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for fl.Running() {
+		if time.Now().After(deadline) {
+			t.Fatal("follower did not stop on the handler error")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	fl.Close()
+	//only the accepted batch moved the state
+	if b := lh.take(); !reflect.DeepEqual(b, [][]string{{`a`, `b`}}) {
+		t.Fatalf("bad batches %q", b)
+	} else if state != 4 {
+		t.Fatal("state moved past an unacknowledged batch", state)
+	}
+}
+
+func TestSlowHandlerBackpressure(t *testing.T) {
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	//32MB of 1KB lines
+	line := strings.Repeat("x", 1023) + "\n"
+	fout, err := os.Create(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 32*1024; i++ {
+		if _, err := fout.WriteString(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fout.Close()
+
+	var before runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	var state int64
+	lh := newBlockingLH()
+	fl, err := NewFollower(FollowerConfig{
+		BaseName: baseName,
+		FilePath: fname,
+		State:    &state,
+		Handler:  lh,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-lh.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler never called")
+	}
+	//give a runaway reader time to pull the file in behind the stuck handler
+	time.Sleep(250 * time.Millisecond)
+	var after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	close(lh.release)
+	if err := fl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if grew := int64(after.HeapAlloc) - int64(before.HeapAlloc); grew > 4*1024*1024 {
+		t.Fatalf("heap grew %d bytes while the handler was blocked", grew)
+	}
+}
+
+func TestCopyTruncate(t *testing.T) {
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	old := "old line one\nold line two\n"
+	if err := ioutil.WriteFile(fname, []byte(old), 0660); err != nil {
+		t.Fatal(err)
+	}
+	lh := &orderedLH{}
+	bus := newEventBus()
+	evts := bus.channel()
+	var state int64
+	fl, err := NewFollower(FollowerConfig{
+		BaseName: baseName,
+		FilePath: fname,
+		State:    &state,
+		Handler:  lh,
+		bus:      bus,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fl.Close()
+	if err := fl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitOffset := func(off int64) {
+		deadline := time.Now().Add(5 * time.Second)
+		for fl.offset() != off {
+			if time.Now().After(deadline) {
+				t.Fatal("follower never reached offset", off, fl.offset())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitOffset(int64(len(old)))
+	if lines := lh.take(); !reflect.DeepEqual(lines, []string{`old line one`, `old line two`}) {
+		t.Fatalf("bad lines %q", lines)
+	}
+	//copytruncate keeps the file but empties it, then the writer carries on
+	if err := os.Truncate(fname, 0); err != nil {
+		t.Fatal(err)
+	}
+	fout, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fout.WriteString("new\n"); err != nil {
+		t.Fatal(err)
+	}
+	fout.Close()
+	var evt FollowerEvent
+	for evt.Type != EventTruncated {
+		select {
+		case evt = <-evts:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for truncation event")
+		}
+	}
+	waitOffset(4)
+	if lines := lh.take(); !reflect.DeepEqual(lines, []string{`new`}) {
+		t.Fatalf("bad lines after truncation %q", lines)
+	}
+}
@@ -10,6 +10,7 @@
 package filewatch
 
 import (
+	"errors"
 	"os"
 	"syscall"
 )
@@ -49,3 +50,8 @@ func openDeletableFile(fpath string) (*os.File, error) {
 func createDeletableFile(fpath string) (*os.File, error) {
 	return os.Create(fpath)
 }
+
+// isFdLimit checks if err came from the process or system running out of file descriptors
+func isFdLimit(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
@@ -0,0 +1,106 @@
+// +build linux
+
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+package filewatch
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestLoadFilesDescriptorLimit(t *testing.T) {
+	dir, err := ioutil.TempDir(tempPath, `fdlimit`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var fpaths []string
+	for i := 0; i < 32; i++ {
+		p := filepath.Join(dir, fmt.Sprintf("%d.log", i))
+		if err := ioutil.WriteFile(p, []byte("line\n"), 0660); err != nil {
+			t.Fatal(err)
+		}
+		fpaths = append(fpaths, p)
+	}
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	if err := fm.AddFilter(bName, dir, []string{`*.log`}, &orderedLH{}, FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	//leave room for a handful of followers, each one holds a file and a notification handle
+	fis, err := ioutil.ReadDir(`/proc/self/fd`)
+	if err != nil {
+		t.Skip("no procfs available", err)
+	}
+	var orig syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &orig); err != nil {
+		t.Fatal(err)
+	}
+	lim := orig
+	lim.Cur = uint64(len(fis) + 16)
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		t.Skip("cannot lower the open file limit", err)
+	}
+	err = fm.LoadFiles(fpaths)
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &orig); err != nil {
+		t.Fatal(err)
+	}
+	if err == nil || !strings.Contains(err.Error(), ErrTooManyOpenFiles.Error()) {
+		t.Fatal("descriptor exhaustion not reported", err)
+	}
+	//files that fit under the limit are still followed
+	if n := fm.Followed(); n == 0 || n == len(fpaths) {
+		t.Fatal("bad follow count", n)
+	}
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStateFileErrorWrapped(t *testing.T) {
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fname, nil, 0660); err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	//a state file underneath a regular file can never be stat'd
+	if _, err = NewFilterManager(filepath.Join(fname, `state`)); err == nil {
+		t.Fatal("invalid state file path did not fail")
+	} else if !errors.Is(err, syscall.ENOTDIR) {
+		t.Fatalf("underlying error was lost: %v", err)
+	}
+
+	if os.Geteuid() == 0 {
+		return //root ignores permission bits
+	}
+	dir, err := ioutil.TempDir(tempPath, `statedir`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Chmod(dir, 0770)
+	if err := os.Chmod(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewFilterManager(filepath.Join(dir, `state`)); err == nil {
+		t.Fatal("unreachable state file did not fail")
+	} else if !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("permission error was lost: %v", err)
+	}
+}
@@ -1,3 +1,5 @@
+// +build windows
+
 /*************************************************************************
  * Copyright 2017 Gravwell, Inc. All rights reserved.
  * Contact: <legal@gravwell.io>
@@ -6,7 +8,6 @@
  * BSD 2-clause license. See the LICENSE file for details.
  **************************************************************************/
 
-// +build windows
 package filewatch
 
 import (
@@ -100,3 +101,8 @@ func createDeletableFile(fpath string) (*os.File, error) {
 
 	return os.NewFile(uintptr(h), fpath), nil
 }
+
+// isFdLimit checks if err came from the process running out of file handles
+func isFdLimit(err error) bool {
+	return errors.Is(err, syscall.Errno(4)) //ERROR_TOO_MANY_OPEN_FILES
+}
@@ -6,6 +6,10 @@ require (
 	github.com/fsnotify/fsnotify v1.4.7
 	github.com/gravwell/ingest/v3 v3.3.12
 	github.com/gravwell/timegrinder/v3 v3.2.5
-	golang.org/x/sys v0.0.0-20200219091948-cb0a6d8edb6c // indirect
-	golang.org/x/time v0.0.0-20191024005414-555d28b269f0 // indirect
+	github.com/prometheus/client_golang v1.11.1
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	golang.org/x/text v0.3.3
+	golang.org/x/time v0.0.0-20191024005414-555d28b269f0
 )
@@ -0,0 +1,195 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	ErrTruncatedGzip = errors.New("Gzip file is truncated")
+	ErrGzipEngine    = errors.New("Gzip files can only be read with the line engine")
+)
+
+// GzipReader reads lines out of a gzip compressed file.  Compressed files cannot be
+// resumed part way through, so they are read once from the start and the index stays
+// at zero until the last line is handed out, at which point it jumps to the size of
+// the file.  A start index at or past the end of the file means the file was already
+// read and nothing is returned.  Concatenated gzip members are read as one stream.
+// Lines longer than MaxLineLen are handed out in MaxLineLen sized pieces so a huge
+// or delimiter free stream is decompressed with bounded memory.
+type GzipReader struct {
+	f         *os.File
+	zr        *gzip.Reader
+	brdr      *bufio.Reader
+	delim     byte
+	keepDelim bool
+	bufSize   int
+	maxLine   int
+	next      []byte //lookahead so we know when the last line goes out
+	rest      []byte //remainder of a line that ran past maxLine
+	eof       bool   //the decompressed stream is exhausted
+	done      bool   //every line was handed out
+	idx       int64
+	err       error
+}
+
+func NewGzipReader(cfg ReaderConfig) (*GzipReader, error) {
+	if cfg.Fin == nil {
+		return nil, errors.New("Reader is nil")
+	} else if cfg.MaxLineLen < 0 {
+		return nil, errors.New("maxline is invalid")
+	} else if cfg.StartIndex < 0 {
+		return nil, errors.New("Invalid start index")
+	}
+	delim, err := parseDelimiter(cfg.Delimiter)
+	if err != nil {
+		return nil, err
+	}
+	gr := &GzipReader{
+		f:         cfg.Fin,
+		delim:     delim,
+		keepDelim: cfg.KeepDelimiter,
+		bufSize:   readBufferSize(cfg.BufferSize),
+		maxLine:   cfg.MaxLineLen,
+	}
+	if err := gr.SeekFile(cfg.StartIndex); err != nil {
+		return nil, err
+	}
+	return gr, nil
+}
+
+// SeekFile restarts the file from the beginning unless offset says it was already read
+func (gr *GzipReader) SeekFile(offset int64) error {
+	if gr.zr != nil {
+		gr.zr.Close()
+		gr.zr = nil
+	}
+	gr.brdr, gr.next, gr.rest, gr.eof, gr.err = nil, nil, nil, false, nil
+	gr.done, gr.idx = false, 0
+	if offset > 0 {
+		fi, err := gr.f.Stat()
+		if err != nil {
+			return err
+		}
+		if offset >= fi.Size() {
+			gr.done, gr.idx = true, offset
+		}
+	}
+	return nil
+}
+
+func (gr *GzipReader) ReadEntry() (ln []byte, ok bool, wasEOF bool, err error) {
+	if gr.done {
+		wasEOF = true
+		return
+	}
+	if gr.zr == nil {
+		if _, err = gr.f.Seek(0, io.SeekStart); err != nil {
+			return
+		}
+		if gr.zr, err = gzip.NewReader(gr.f); err != nil {
+			gr.zr = nil
+			if err == io.EOF {
+				//nothing has been written yet
+				err = nil
+				wasEOF = true
+			}
+			return
+		}
+		gr.brdr = bufio.NewReaderSize(gr.zr, gr.bufSize)
+		gr.fill()
+	}
+	if gr.next == nil {
+		if err = gr.err; err == nil {
+			wasEOF = true
+		}
+		return
+	}
+	ln, ok = gr.next, true
+	gr.fill()
+	if gr.next == nil && gr.eof && gr.err == nil {
+		//this is the last line, the file is complete once it is handled
+		var fi os.FileInfo
+		if fi, err = gr.f.Stat(); err != nil {
+			return nil, false, false, err
+		}
+		gr.done, gr.idx = true, fi.Size()
+	}
+	return
+}
+
+// fill reads the next non empty line into the lookahead
+func (gr *GzipReader) fill() {
+	gr.next = nil
+	for !gr.eof {
+		b, err := gr.readLine()
+		if err == io.EOF {
+			gr.eof = true
+		} else if err != nil {
+			gr.eof = true
+			if err == io.ErrUnexpectedEOF {
+				err = ErrTruncatedGzip
+			}
+			gr.err = fmt.Errorf("%s: %w", gr.f.Name(), err)
+			return //anything decoded after the last complete line is suspect
+		}
+		if !gr.keepDelim {
+			b = trimDelim(b, gr.delim)
+		}
+		if len(b) > 0 {
+			gr.next = b
+			return
+		}
+	}
+}
+
+// readLine reads through the next delimiter, a line that runs past maxLine is cut
+// there and the remainder starts the next line
+func (gr *GzipReader) readLine() (ln []byte, err error) {
+	ln, gr.rest = gr.rest, nil
+	done := len(ln) > 0 && ln[len(ln)-1] == gr.delim //the remainder already reached the delimiter
+	for !done && (gr.maxLine <= 0 || len(ln) <= gr.maxLine) {
+		var b []byte
+		b, err = gr.brdr.ReadSlice(gr.delim)
+		ln = append(ln, b...) //ReadSlice hands back the reader buffer so it must be copied
+		if err != bufio.ErrBufferFull {
+			break
+		}
+		err = nil
+	}
+	if gr.maxLine > 0 && len(ln) > gr.maxLine {
+		//errors stick in the gzip reader so they come back with the remainder
+		gr.rest = append([]byte(nil), ln[gr.maxLine:]...)
+		ln, err = ln[:gr.maxLine:gr.maxLine], nil
+	}
+	return
+}
+
+func (gr *GzipReader) Index() int64 {
+	return gr.idx
+}
+
+func (gr *GzipReader) Close() error {
+	if gr.zr != nil {
+		gr.zr.Close()
+		gr.zr = nil
+	}
+	if gr.f == nil {
+		return nil
+	}
+	err := gr.f.Close()
+	gr.f = nil
+	return err
+}
@@ -0,0 +1,244 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func gzipBytes(t *testing.T, data string) []byte {
+	var bb bytes.Buffer
+	zw := gzip.NewWriter(&bb)
+	if _, err := zw.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	} else if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bb.Bytes()
+}
+
+func newGzipFile(t *testing.T, b []byte) string {
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fname, b, 0660); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+func readGzip(t *testing.T, fname string, start int64) (r []string, idx int64, err error) {
+	fin, err := os.Open(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr, err := NewReader(ReaderConfig{Fin: fin, MaxLineLen: defaultMaxLine, StartIndex: start, Gzip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdr.Close()
+	for {
+		ln, ok, _, lerr := rdr.ReadEntry()
+		if lerr != nil {
+			return r, rdr.Index(), lerr
+		} else if !ok {
+			return r, rdr.Index(), nil
+		}
+		r = append(r, string(ln))
+	}
+}
+
+func TestGzipReader(t *testing.T) {
+	b := gzipBytes(t, "a\n\nb\nc")
+	fname := newGzipFile(t, b)
+	defer cleanFile(fname, t)
+	lines, idx, err := readGzip(t, fname, 0)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(lines, []string{`a`, `b`, `c`}) {
+		t.Fatalf("bad lines: %q", lines)
+	} else if idx != int64(len(b)) {
+		t.Fatal("file not marked complete", idx)
+	}
+	//a completed file is not read again
+	if lines, _, err = readGzip(t, fname, int64(len(b))); err != nil || len(lines) != 0 {
+		t.Fatal("completed file read again", lines, err)
+	}
+	//partial offsets cannot be resumed so the file starts over
+	if lines, _, err = readGzip(t, fname, 5); err != nil || len(lines) != 3 {
+		t.Fatal("partially read file did not start over", lines, err)
+	}
+}
+
+func TestGzipReaderConcatenated(t *testing.T) {
+	b := append(gzipBytes(t, "a\nb\n"), gzipBytes(t, "c\nd\n")...)
+	fname := newGzipFile(t, b)
+	defer cleanFile(fname, t)
+	lines, idx, err := readGzip(t, fname, 0)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(lines, []string{`a`, `b`, `c`, `d`}) {
+		t.Fatalf("bad lines: %q", lines)
+	} else if idx != int64(len(b)) {
+		t.Fatal("file not marked complete", idx)
+	}
+}
+
+func TestGzipReaderTruncated(t *testing.T) {
+	var data bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		data.WriteString("some fairly repetitive line of data\n")
+	}
+	b := gzipBytes(t, data.String())
+	fname := newGzipFile(t, b[:len(b)/2])
+	defer cleanFile(fname, t)
+	lines, idx, err := readGzip(t, fname, 0)
+	if !errors.Is(err, ErrTruncatedGzip) {
+		t.Fatal("truncation not reported", err)
+	} else if idx != 0 {
+		t.Fatal("truncated file marked complete", idx)
+	}
+	for _, ln := range lines {
+		if ln != `some fairly repetitive line of data` {
+			t.Fatalf("bad line out of truncated file: %q", ln)
+		}
+	}
+}
+
+func TestGzipReaderMaxLine(t *testing.T) {
+	fname := newGzipFile(t, gzipBytes(t, "abcd\nabcdef\nab\n"))
+	defer cleanFile(fname, t)
+	fin, err := os.Open(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr, err := NewReader(ReaderConfig{Fin: fin, MaxLineLen: 4, Gzip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdr.Close()
+	var lines []string
+	for {
+		ln, ok, _, err := rdr.ReadEntry()
+		if err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			break
+		}
+		lines = append(lines, string(ln))
+	}
+	if !reflect.DeepEqual(lines, []string{`abcd`, `abcd`, `ef`, `ab`}) {
+		t.Fatalf("bad lines: %q", lines)
+	}
+}
+
+func TestGzipReaderBounded(t *testing.T) {
+	const maxLine = 64 * 1024
+	const runaway = 32 * 1024 * 1024
+	//a huge delimiter free stream compresses down to almost nothing
+	var bb bytes.Buffer
+	zw := gzip.NewWriter(&bb)
+	blk := bytes.Repeat([]byte{'x'}, 1024*1024)
+	for i := 0; i < runaway/len(blk); i++ {
+		if _, err := zw.Write(blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := zw.Write([]byte("\nshort\n")); err != nil {
+		t.Fatal(err)
+	} else if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fname := newGzipFile(t, bb.Bytes())
+	defer cleanFile(fname, t)
+	fin, err := os.Open(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr, err := NewReader(ReaderConfig{Fin: fin, MaxLineLen: maxLine, Gzip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdr.Close()
+	gr := rdr.(*GzipReader)
+	var total, records int
+	var last string
+	for {
+		ln, ok, _, err := rdr.ReadEntry()
+		if err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			break
+		}
+		if len(ln) > maxLine {
+			t.Fatalf("record of %d bytes is past the max line", len(ln))
+		} else if c := cap(ln) + cap(gr.rest) + cap(gr.next); c > 2*maxLine+2*DefaultReadBufferSize {
+			t.Fatalf("reader is holding %d bytes", c)
+		}
+		total += len(ln)
+		records++
+		last = string(ln)
+	}
+	if last != `short` || total != runaway+len(last) || records != runaway/maxLine+1 {
+		t.Fatal("bad records", total, records, last)
+	}
+}
+
+func TestGzipFollower(t *testing.T) {
+	name, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	fname := newGzipFile(t, gzipBytes(t, "one\ntwo\n"))
+	defer cleanFile(fname, t)
+	run := func(expect []string) {
+		fm, err := NewFilterManager(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fcfg := FilterConfig{
+			FollowerEngineConfig: FollowerEngineConfig{Gzip: true},
+			BaseName:             bName,
+			Location:             filepath.Dir(fname),
+			Matches:              []string{filepath.Base(fname)},
+		}
+		lh := &orderedLH{}
+		if err := fm.AddFilterConfig(fcfg, lh); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := fm.LoadFile(fname); err != nil || !ok {
+			t.Fatal("failed to load", ok, err)
+		}
+		ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cf()
+		if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: fname}); err != nil {
+			t.Fatal(err)
+		}
+		if lines := lh.take(); !reflect.DeepEqual(lines, expect) {
+			t.Fatalf("bad lines: %v != %v", lines, expect)
+		}
+		if err := fm.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run([]string{`one`, `two`})
+	//a restart does not ingest the completed file again
+	run(nil)
+}
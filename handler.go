@@ -10,8 +10,11 @@ package filewatch
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"net"
+	"reflect"
 	"time"
 
 	"github.com/gravwell/ingest/v3/entry"
@@ -28,6 +31,169 @@ type logger interface {
 	Critical(string, ...interface{}) error
 }
 
+// SourceHandlerFunc adapts a function that wants the source of each record into a
+// handler.  Followers deliver through HandleSourceLog, HandleLog exists so the
+// adapter can be passed anywhere a handler is taken and reports an empty source.
+type SourceHandlerFunc func([]byte, time.Time, RecordSource) error
+
+func (fn SourceHandlerFunc) HandleLog(b []byte, ts time.Time) error {
+	return fn(b, ts, RecordSource{})
+}
+
+func (fn SourceHandlerFunc) HandleSourceLog(b []byte, ts time.Time, src RecordSource) error {
+	return fn(b, ts, src)
+}
+
+// ContextHandlerFunc adapts a function that takes a context into a handler,
+// HandleLog calls it with a background context
+type ContextHandlerFunc func(context.Context, []byte, time.Time) error
+
+func (fn ContextHandlerFunc) HandleLog(b []byte, ts time.Time) error {
+	return fn(context.Background(), b, ts)
+}
+
+func (fn ContextHandlerFunc) HandleLogContext(ctx context.Context, b []byte, ts time.Time) error {
+	return fn(ctx, b, ts)
+}
+
+// HandlerShim lets a handler that knows nothing about contexts be delivered to as a
+// ContextHandler.  The wrapped HandleLog runs in its own goroutine and is abandoned
+// when the context is done, so a blocked handler cannot hold up Close.  An abandoned
+// call keeps running in the background and its record is read again on restart.
+type HandlerShim struct {
+	lh handler
+}
+
+func NewHandlerShim(lh handler) *HandlerShim {
+	return &HandlerShim{lh: lh}
+}
+
+func (hs *HandlerShim) HandleLog(b []byte, ts time.Time) error {
+	return hs.lh.HandleLog(b, ts)
+}
+
+func (hs *HandlerShim) HandleLogContext(ctx context.Context, b []byte, ts time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errCh <- fmt.Errorf("%w: %v", ErrHandlerPanic, r)
+			}
+		}()
+		errCh <- hs.lh.HandleLog(b, ts)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush passes through to the wrapped handler if it buffers records
+func (hs *HandlerShim) Flush() error {
+	if fl, ok := hs.lh.(flusher); ok {
+		return fl.Flush()
+	}
+	return nil
+}
+
+// fanoutHandler delivers every record to each of its handlers, it keeps feeding the
+// handler of a filter folded into a duplicate by DuplicateMerge.  Every handler takes
+// records in the same mode and a record that fails on any of them is retried on all.
+type fanoutHandler struct {
+	lhs  []handler
+	mode DeliveryMode
+}
+
+// mergeHandlers returns a handler delivering to both a and b under cfg, an existing
+// fan out is extended rather than nested and a handler is never added twice
+func mergeHandlers(cfg FollowerEngineConfig, a, b handler) (handler, error) {
+	var lhs []handler
+	if fo, ok := a.(*fanoutHandler); ok {
+		lhs = append(lhs, fo.lhs...)
+	} else {
+		lhs = append(lhs, a)
+	}
+	for _, lh := range lhs {
+		if sameHandler(lh, b) {
+			return a, nil
+		}
+	}
+	fo := &fanoutHandler{lhs: append(lhs, b)}
+	for i, lh := range fo.lhs {
+		mode, err := handlerMode(cfg, lh)
+		if err != nil {
+			return nil, err
+		} else if i > 0 && mode != fo.mode {
+			return nil, fmt.Errorf("%w: merged handlers take different delivery modes", ErrUnsupportedDelivery)
+		}
+		fo.mode = mode
+	}
+	return fo, nil
+}
+
+// sameHandler reports whether a and b are the same handler, handlers that cannot be
+// compared such as function adapters are never the same
+func sameHandler(a, b handler) bool {
+	t := reflect.TypeOf(a)
+	if t == nil || t != reflect.TypeOf(b) || !t.Comparable() {
+		return false
+	}
+	return a == b
+}
+
+func (fo *fanoutHandler) HandleLog(b []byte, ts time.Time) (err error) {
+	for _, lh := range fo.lhs {
+		if lerr := lh.HandleLog(b, ts); lerr != nil {
+			err = appendErr(err, lerr)
+		}
+	}
+	return
+}
+
+func (fo *fanoutHandler) HandleSourceLog(b []byte, ts time.Time, src RecordSource) (err error) {
+	for _, lh := range fo.lhs {
+		if lerr := lh.(sourceHandler).HandleSourceLog(b, ts, src); lerr != nil {
+			err = appendErr(err, lerr)
+		}
+	}
+	return
+}
+
+func (fo *fanoutHandler) HandleLogContext(ctx context.Context, b []byte, ts time.Time) (err error) {
+	for _, lh := range fo.lhs {
+		if lerr := lh.(ContextHandler).HandleLogContext(ctx, b, ts); lerr != nil {
+			err = appendErr(err, lerr)
+		}
+	}
+	return
+}
+
+func (fo *fanoutHandler) HandleBatch(lns [][]byte, ts time.Time) (err error) {
+	for _, lh := range fo.lhs {
+		if lerr := lh.(batchHandler).HandleBatch(lns, ts); lerr != nil {
+			err = appendErr(err, lerr)
+		}
+	}
+	return
+}
+
+// Flush flushes every handler that buffers records
+func (fo *fanoutHandler) Flush() (err error) {
+	for _, lh := range fo.lhs {
+		if fl, ok := lh.(flusher); ok {
+			if lerr := fl.Flush(); lerr != nil {
+				err = appendErr(err, lerr)
+			}
+		}
+	}
+	return
+}
+
 type LogHandler struct {
 	LogHandlerConfig
 	tg *timegrinder.TimeGrinder
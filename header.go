@@ -0,0 +1,98 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"sync/atomic"
+)
+
+const (
+	HeaderCheckBytes int64 = 64
+)
+
+// FileHeader fingerprints the start of the file a state belongs to, see
+// WithHeaderCheck.  Only bytes before the saved offset are hashed so appends never
+// change it, Len is zero if the header was never taken.
+type FileHeader struct {
+	Len  int64  //bytes hashed, at most HeaderCheckBytes
+	Hash uint64 //fnv64a of the first Len bytes
+}
+
+// readHeader hashes the first n bytes of the file at fpath, fewer are hashed if the
+// file is shorter
+func readHeader(fpath string, n int64) (FileHeader, error) {
+	id, err := fileIdFromName(HashIdStrategy{Bytes: n}, fpath)
+	if err != nil {
+		return FileHeader{}, err
+	}
+	return FileHeader{Len: int64(id.Major), Hash: id.Minor}, nil
+}
+
+// headerChanged returns whether the file at fpath no longer starts with the bytes h
+// was taken from.  Unknown headers and unreadable files are never reported as
+// changed, the id and size checks still apply to them.
+func headerChanged(fpath string, h FileHeader) bool {
+	if h.Len <= 0 {
+		return false
+	}
+	cur, err := readHeader(fpath, h.Len)
+	return err == nil && cur != h
+}
+
+// stateHeader is a cached header and the offset of the state when it was taken
+type stateHeader struct {
+	FileHeader
+	off int64
+}
+
+// nolockStateHeader returns the header to persist for state k at offset.  The header
+// is taken again whenever the offset moved since the last flush so a file truncated
+// and rewritten in place is not saved with the header of its old contents.
+// caller MUST HOLD THE LOCK
+func (fm *FilterManager) nolockStateHeader(k FileName, offset int64) stateHeader {
+	h := fm.stateHdrs[k]
+	if _, ok := lineageStateDir(k); ok || h.off == offset {
+		return h //chain members are keyed by id, there is no path to read
+	}
+	want := offset
+	if want > HeaderCheckBytes {
+		want = HeaderCheckBytes
+	}
+	if nh, err := readHeader(k.FilePath, want); err == nil {
+		h = stateHeader{FileHeader: nh, off: offset}
+	}
+	return h
+}
+
+// nolockAttachHeaders fills in the header of every persisted state and drops cached
+// headers for states that are gone
+// caller MUST HOLD THE LOCK
+func (fm *FilterManager) nolockAttachHeaders(r map[FileName]FileState) {
+	hdrs := make(map[FileName]stateHeader, len(r))
+	for k, st := range r {
+		if st.Offset <= 0 {
+			continue
+		}
+		h := fm.nolockStateHeader(k, st.Offset)
+		st.Header = h.FileHeader
+		hdrs[k] = h
+		r[k] = st
+	}
+	fm.stateHdrs = hdrs
+}
+
+// nolockHeaderChanged checks whether the file behind state k was replaced with
+// different contents since its header was taken
+// caller MUST HOLD THE LOCK
+func (fm *FilterManager) nolockHeaderChanged(k FileName, fpath string, st *int64) bool {
+	if !fm.hdrCheck || st == nil || atomic.LoadInt64(st) == 0 {
+		return false
+	}
+	return headerChanged(fpath, fm.stateHdrs[k].FileHeader)
+}
@@ -0,0 +1,80 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// DefaultIgnorePatterns are the editor swap, backup, and lock files every manager
+// ignores unless SetIgnorePatterns replaces them
+var DefaultIgnorePatterns = []string{
+	`*.swp`, //vim swap files
+	`*.swx`,
+	`*~`,  //emacs and vim backups
+	`#*#`, //emacs autosaves
+	`.#*`, //emacs lock files
+}
+
+// SetSkipHidden controls whether files whose base name starts with a dot are ignored
+// even when a filter matches them, it is off by default
+func (fm *FilterManager) SetSkipHidden(skip bool) {
+	fm.mtx.Lock()
+	defer fm.mtx.Unlock()
+	fm.skipHidden = skip
+}
+
+// SetIgnorePatterns replaces the globs checked against the base name of every file
+// before the filters are, matching files are never followed.  The defaults are
+// DefaultIgnorePatterns, an empty set ignores nothing.
+func (fm *FilterManager) SetIgnorePatterns(globs []string) error {
+	for _, g := range globs {
+		if _, err := filepath.Match(g, ``); err != nil {
+			return err
+		}
+	}
+	fm.mtx.Lock()
+	defer fm.mtx.Unlock()
+	fm.ignores = append([]string(nil), globs...)
+	return nil
+}
+
+// SetIgnoreRegexes sets regular expressions checked against the base name of every
+// file alongside the ignore patterns, none are set by default
+func (fm *FilterManager) SetIgnoreRegexes(exprs []string) error {
+	res, err := compileMatches(exprs, false)
+	if err != nil {
+		return err
+	}
+	fm.mtx.Lock()
+	defer fm.mtx.Unlock()
+	fm.ignoreRes = res
+	return nil
+}
+
+// ignored returns whether the file at fpath is skipped regardless of the filters
+// caller MUST HOLD THE LOCK
+func (fm *FilterManager) ignored(fpath string) bool {
+	name := filepath.Base(fpath)
+	if fm.skipHidden && strings.HasPrefix(name, `.`) {
+		return true
+	}
+	for _, g := range fm.ignores {
+		if ok, err := filepath.Match(g, name); err == nil && ok {
+			return true
+		}
+	}
+	for _, re := range fm.ignoreRes {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,250 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	lineageStateSep string = `#` //separates the head path from the file id in lineage state keys
+)
+
+var (
+	ErrInvalidLineageSuffix = errors.New("Invalid lineage suffix pattern")
+	ErrRecursiveLineage     = errors.New("Lineage cannot be used with recursive filters")
+)
+
+// LineageConfig turns a filter into a rotation chain follower.  The filter Matches
+// select the head of each chain (e.g. app.log) and Suffix describes the names that
+// rotated members get (e.g. .%d for app.log.1, app.log.2).  Members are delivered
+// oldest first, a member is not read until the member before it has caught up.
+// Member offsets are tracked by file id rather than name, so rotations that happen
+// while the manager is down do not cause members to be read twice.
+//
+// Suffix supports %d as a rotation number where higher numbers are older, or the
+// date verbs %Y %m %d %H %M %S where earlier dates are older.  When any date verb
+// is present %d is the day of the month.  %% is a literal percent sign.
+type LineageConfig struct {
+	Suffix string
+}
+
+// lineage is a compiled LineageConfig
+type lineage struct {
+	cfg   LineageConfig
+	re    *regexp.Regexp
+	date  bool
+	verbs []byte //verb for each capture group, in order of appearance
+}
+
+// lineagePos is the position of a member within its chain
+type lineagePos struct {
+	head  bool   //the live file, newer than every rotated member
+	n     int64  //rotation number
+	stamp string //date digits ordered from most to least significant
+}
+
+var dateVerbs = map[byte]string{
+	'Y': `(\d{4})`,
+	'm': `(\d{2})`,
+	'd': `(\d{2})`,
+	'H': `(\d{2})`,
+	'M': `(\d{2})`,
+	'S': `(\d{2})`,
+}
+
+const dateSignificance = `YmdHMS`
+
+func newLineage(lc LineageConfig) (*lineage, error) {
+	if lc.Suffix == `` {
+		return nil, nil
+	}
+	l := &lineage{cfg: lc}
+	for _, v := range []string{`%Y`, `%m`, `%H`, `%M`, `%S`} {
+		if strings.Contains(lc.Suffix, v) {
+			l.date = true
+		}
+	}
+	var sb strings.Builder
+	sb.WriteString(`^(.+)`)
+	for i := 0; i < len(lc.Suffix); i++ {
+		c := lc.Suffix[i]
+		if c != '%' {
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+			continue
+		}
+		if i++; i >= len(lc.Suffix) {
+			return nil, fmt.Errorf("%w %q: trailing %%", ErrInvalidLineageSuffix, lc.Suffix)
+		}
+		v := lc.Suffix[i]
+		if v == '%' {
+			sb.WriteString(`%`)
+			continue
+		}
+		if !l.date {
+			if v != 'd' || len(l.verbs) > 0 {
+				return nil, fmt.Errorf("%w %q: numeric suffixes take a single %%d", ErrInvalidLineageSuffix, lc.Suffix)
+			}
+			sb.WriteString(`(\d+)`)
+		} else if expr, ok := dateVerbs[v]; ok {
+			sb.WriteString(expr)
+		} else {
+			return nil, fmt.Errorf("%w %q: unknown verb %%%c", ErrInvalidLineageSuffix, lc.Suffix, v)
+		}
+		l.verbs = append(l.verbs, v)
+	}
+	if len(l.verbs) == 0 {
+		return nil, fmt.Errorf("%w %q: no verbs", ErrInvalidLineageSuffix, lc.Suffix)
+	}
+	sb.WriteString(`$`)
+	var err error
+	if l.re, err = regexp.Compile(sb.String()); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// member checks if fname is the head or a rotated member of a chain whose head
+// is accepted by isHead, returning the head name and the position of fname in the chain
+func (l *lineage) member(isHead func(string) bool, fname string) (head string, pos lineagePos, ok bool) {
+	if isHead(fname) {
+		return fname, lineagePos{head: true}, true
+	}
+	sm := l.re.FindStringSubmatch(fname)
+	if sm == nil || !isHead(sm[1]) {
+		return
+	}
+	head = sm[1]
+	if !l.date {
+		var err error
+		if pos.n, err = strconv.ParseInt(sm[2], 10, 64); err != nil {
+			return
+		}
+		return head, pos, true
+	}
+	var sb strings.Builder
+	for _, sig := range []byte(dateSignificance) {
+		for i, v := range l.verbs {
+			if v == sig {
+				sb.WriteString(sm[i+2])
+			}
+		}
+	}
+	pos.stamp = sb.String()
+	return head, pos, true
+}
+
+// older returns true if a was rotated out before b
+func (l *lineage) older(a, b lineagePos) bool {
+	if a.head || b.head {
+		return !a.head && b.head
+	}
+	if l.date {
+		return a.stamp < b.stamp
+	}
+	return a.n > b.n
+}
+
+// lineageStateKey is the state key for a chain member, keyed on the file id
+// so that the offset survives the member being renamed down the chain
+func lineageStateKey(v filter, head string, id FileId) FileName {
+	return FileName{
+		BaseName: v.bname,
+		FilePath: fmt.Sprintf("%s%s%d:%d", filepath.Join(v.loc, head), lineageStateSep, id.Major, id.Minor),
+	}
+}
+
+// lineageStateDir returns the chain directory if k is a lineage state key
+func lineageStateDir(k FileName) (string, bool) {
+	idx := strings.LastIndex(k.FilePath, lineageStateSep)
+	if idx < 0 {
+		return ``, false
+	}
+	var maj, min uint64
+	if _, err := fmt.Sscanf(k.FilePath[idx+len(lineageStateSep):], "%d:%d", &maj, &min); err != nil {
+		return ``, false
+	}
+	return filepath.Dir(k.FilePath[:idx]), true
+}
+
+// filterMatch checks if a file name in the filter location belongs to the filter
+func (f *FilterManager) filterMatch(v filter, fname string) bool {
+	if f.excluded(v, fname) {
+		return false
+	} else if v.lin == nil {
+		return f.headMatch(v, fname)
+	}
+	_, _, ok := v.lin.member(f.headMatcher(v), fname)
+	return ok
+}
+
+// lineageMember is a file in a chain along with its position
+type lineageMember struct {
+	path string
+	pos  lineagePos
+}
+
+// olderMembers returns the unfollowed members of the chain that are older than pos, oldest first
+// Caller MUST HOLD THE LOCK
+func (f *FilterManager) olderMembers(v filter, head string, pos lineagePos) ([]lineageMember, error) {
+	fis, err := ioutil.ReadDir(v.loc)
+	if err != nil {
+		return nil, err
+	}
+	var r []lineageMember
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() || f.excluded(v, fi.Name()) {
+			continue
+		}
+		h, p, ok := v.lin.member(f.headMatcher(v), fi.Name())
+		if !ok || h != head || !v.lin.older(p, pos) {
+			continue
+		}
+		fpath := filepath.Join(v.loc, fi.Name())
+		if _, ok := f.followers[FileName{BaseName: v.bname, FilePath: fpath}]; ok {
+			continue
+		}
+		r = append(r, lineageMember{path: fpath, pos: p})
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return v.lin.older(r[i].pos, r[j].pos)
+	})
+	return r, nil
+}
+
+// lineageGate returns the done channel of the closest older follower in the chain,
+// nil means there is nothing older to wait on
+// Caller MUST HOLD THE LOCK
+func (f *FilterManager) lineageGate(filterId int, v filter, head string, pos lineagePos) <-chan struct{} {
+	var gate *follower
+	var gatePos lineagePos
+	for k, flw := range f.followers {
+		if flw.FilterId() != filterId {
+			continue
+		}
+		h, p, ok := v.lin.member(f.headMatcher(v), filepath.Base(k.FilePath))
+		if !ok || h != head || !v.lin.older(p, pos) {
+			continue
+		}
+		if gate == nil || v.lin.older(gatePos, p) {
+			gate, gatePos = flw, p
+		}
+	}
+	if gate == nil {
+		return nil
+	}
+	return gate.done
+}
@@ -0,0 +1,144 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLineageOrdering(t *testing.T) {
+	fm := &FilterManager{}
+	isHead := func(n string) bool { return fm.matchFile([]string{`app.log`}, n) }
+	for _, tc := range []struct {
+		suffix string
+		names  []string //oldest first
+	}{
+		{`.%d`, []string{`app.log.10`, `app.log.2`, `app.log.1`, `app.log`}},
+		{`-%Y%m%d`, []string{`app.log-20191231`, `app.log-20200101`, `app.log-20200102`, `app.log`}},
+		{`.%d-%m-%Y`, []string{`app.log.31-12-2019`, `app.log.01-01-2020`, `app.log.02-01-2020`, `app.log`}},
+	} {
+		l, err := newLineage(LineageConfig{Suffix: tc.suffix})
+		if err != nil {
+			t.Fatal(tc.suffix, err)
+		}
+		var prev lineagePos
+		for i, n := range tc.names {
+			head, pos, ok := l.member(isHead, n)
+			if !ok || head != `app.log` {
+				t.Fatalf("%s: %s is not a member: %v %q", tc.suffix, n, ok, head)
+			}
+			if i > 0 && !l.older(prev, pos) {
+				t.Fatalf("%s: %s is not newer than %s", tc.suffix, n, tc.names[i-1])
+			}
+			prev = pos
+		}
+		if _, _, ok := l.member(isHead, `other.log.1`); ok {
+			t.Fatal(tc.suffix, "foreign file matched")
+		}
+	}
+	for _, bad := range []string{`.%`, `.%d.%d`, `.%q`, `.log`} {
+		if _, err := newLineage(LineageConfig{Suffix: bad}); err == nil {
+			t.Fatal("bad suffix accepted", bad)
+		}
+	}
+}
+
+func TestLineageChain(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	dir, err := ioutil.TempDir(tempPath, `lineage`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles := func(files map[string]string) {
+		for n, v := range files {
+			if err := ioutil.WriteFile(filepath.Join(dir, n), []byte(v), 0660); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	writeFiles(map[string]string{
+		`app.log.2`: "a1\na2\n",
+		`app.log.1`: "b1\n",
+		`app.log`:   "c1\n",
+	})
+	lh := &orderedLH{}
+	fcfg := FilterConfig{
+		BaseName: bName,
+		Location: dir,
+		Matches:  []string{`app.log`},
+		Lineage:  LineageConfig{Suffix: `.%d`},
+	}
+	run := func(fm *FilterManager, expect []string) {
+		if err := fm.AddFilterConfig(fcfg, lh); err != nil {
+			t.Fatal(err)
+		}
+		//loading the head has to pull in the older members
+		if _, err := fm.LoadFile(filepath.Join(dir, `app.log`)); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cf()
+		for _, n := range []string{`app.log.2`, `app.log.1`, `app.log`} {
+			if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: filepath.Join(dir, n)}); err != nil {
+				t.Fatal(n, err)
+			}
+		}
+		if lines := lh.take(); !reflect.DeepEqual(lines, expect) {
+			t.Fatalf("bad delivery order: %v != %v", lines, expect)
+		}
+		if err := fm.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run(fm, []string{`a1`, `a2`, `b1`, `c1`})
+
+	//rotate while the manager is down, only the new head should be read
+	if err := os.Remove(filepath.Join(dir, `app.log.2`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, mv := range [][2]string{{`app.log.1`, `app.log.2`}, {`app.log`, `app.log.1`}} {
+		if err := os.Rename(filepath.Join(dir, mv[0]), filepath.Join(dir, mv[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFiles(map[string]string{`app.log`: "d1\n"})
+	if fm, err = NewFilterManager(name); err != nil {
+		t.Fatal(err)
+	}
+	run(fm, []string{`d1`})
+}
+
+// orderedLH records lines in the order they were delivered
+type orderedLH struct {
+	sync.Mutex
+	lines []string
+}
+
+func (h *orderedLH) HandleLog(b []byte, ts time.Time) error {
+	h.Lock()
+	h.lines = append(h.lines, string(b))
+	h.Unlock()
+	return nil
+}
+
+func (h *orderedLH) take() (r []string) {
+	h.Lock()
+	r, h.lines = h.lines, nil
+	h.Unlock()
+	return
+}
@@ -10,31 +10,51 @@ package filewatch
 
 import (
 	"bufio"
-	"bytes"
 	"io"
 )
 
 type LineReader struct {
 	baseReader
-	brdr     *bufio.Reader
-	currLine []byte
+	brdr         *bufio.Reader
+	currLine     []byte
+	keepDelim    bool
+	delim        byte
+	flushPartial bool
 }
 
 func NewLineReader(cfg ReaderConfig) (*LineReader, error) {
+	delim, err := parseDelimiter(cfg.Delimiter)
+	if err != nil {
+		return nil, err
+	}
 	br, err := newBaseReader(cfg.Fin, cfg.MaxLineLen, cfg.StartIndex)
 	if err != nil {
 		return nil, err
 	}
 	return &LineReader{
-		baseReader: br,
-		brdr:       bufio.NewReader(cfg.Fin),
+		baseReader:   br,
+		brdr:         bufio.NewReaderSize(cfg.Fin, readBufferSize(cfg.BufferSize)),
+		keepDelim:    cfg.KeepDelimiter,
+		flushPartial: cfg.FlushPartial,
+		delim:        delim,
 	}, nil
 }
 
+// SeekFile repositions the reader, buffered data and partial lines are from the
+// old position so they are dropped
+func (lr *LineReader) SeekFile(offset int64) error {
+	lr.currLine = nil
+	if err := lr.baseReader.SeekFile(offset); err != nil {
+		return err
+	}
+	lr.brdr.Reset(lr.f)
+	return nil
+}
+
 func (lr *LineReader) ReadEntry() (ln []byte, ok bool, wasEOF bool, err error) {
 	for {
 		//ReadBytes garuntees that it returns err == nil ONLY when the results hit the delimiter
-		b, lerr := lr.brdr.ReadBytes(byte('\n'))
+		b, lerr := lr.brdr.ReadBytes(lr.delim)
 		//legit error
 		if lerr != nil && lerr != io.EOF {
 			err = lerr //set the error for return
@@ -50,7 +70,18 @@ func (lr *LineReader) ReadEntry() (ln []byte, ok bool, wasEOF bool, err error) {
 		}
 		//we got something, add to our index, trim, and check
 		lr.idx += int64(len(b))
-		b = bytes.TrimRight(b, "\r\n")
+		if lr.keepDelim {
+			//hand back the raw bytes, only holding on to partial lines
+			if lerr == io.EOF {
+				lr.currLine = append(lr.currLine, b...)
+				return
+			}
+			ln = append(lr.currLine, b...)
+			lr.currLine = nil
+			ok = true
+			break
+		}
+		b = trimDelim(b, lr.delim)
 		if len(b) == 0 {
 			//we just got the ending to a line that we had the beginning of
 			if len(lr.currLine) != 0 {
@@ -82,3 +113,14 @@ func (lr *LineReader) ReadEntry() (ln []byte, ok bool, wasEOF bool, err error) {
 	}
 	return
 }
+
+// FlushEntry hands back a trailing partial line that is still waiting on its delimiter,
+// nothing is returned unless the reader was configured with FlushPartial
+func (lr *LineReader) FlushEntry() (ln []byte, ok bool) {
+	if !lr.flushPartial || len(lr.currLine) == 0 {
+		return
+	}
+	ln, ok = lr.currLine, true
+	lr.currLine = nil
+	return
+}
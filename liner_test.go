@@ -9,10 +9,13 @@
 package filewatch
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -225,3 +228,297 @@ func randomString(n int) []byte {
 	}
 	return buff
 }
+
+func TestLinerKeepDelimiter(t *testing.T) {
+	f, name, err := newFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	lnr, err := NewLineReader(ReaderConfig{
+		Fin:           f,
+		MaxLineLen:    defMaxLine,
+		KeepDelimiter: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lnr.Close()
+	readAll := func() (r []string) {
+		for {
+			ln, ok, _, err := lnr.ReadEntry()
+			if err != nil {
+				t.Fatal(err)
+			} else if !ok {
+				return
+			}
+			r = append(r, string(ln))
+		}
+	}
+	w, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, err := w.Write([]byte("a\r\n\nb\nc")); err != nil {
+		t.Fatal(err)
+	}
+	if r := readAll(); len(r) != 3 || r[0] != "a\r\n" || r[1] != "\n" || r[2] != "b\n" {
+		t.Fatalf("bad lines: %q", r)
+	}
+	//finish off the partial line
+	if _, err := w.Write([]byte("d\n")); err != nil {
+		t.Fatal(err)
+	}
+	if r := readAll(); len(r) != 1 || r[0] != "cd\n" {
+		t.Fatalf("bad partial line: %q", r)
+	}
+	if lnr.Index() != 9 {
+		t.Fatal("bad index", lnr.Index())
+	}
+}
+
+func TestLinerDelimiter(t *testing.T) {
+	f, name, err := newFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	if _, err := NewLineReader(ReaderConfig{Fin: f, MaxLineLen: defMaxLine, Delimiter: `ab`}); !errors.Is(err, ErrInvalidDelimiter) {
+		t.Fatal("multi byte delimiter was accepted", err)
+	}
+	lnr, err := NewLineReader(ReaderConfig{
+		Fin:        f,
+		MaxLineLen: defMaxLine,
+		Delimiter:  "\x00",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lnr.Close()
+	readAll := func() (r []string) {
+		for {
+			ln, ok, _, err := lnr.ReadEntry()
+			if err != nil {
+				t.Fatal(err)
+			} else if !ok {
+				return
+			}
+			r = append(r, string(ln))
+		}
+	}
+	w, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	//newlines are just data, the partial record is held back
+	if _, err := w.Write([]byte("a\nb\x00\x00c\r\n\x00d")); err != nil {
+		t.Fatal(err)
+	}
+	if r := readAll(); len(r) != 2 || r[0] != "a\nb" || r[1] != "c\r\n" {
+		t.Fatalf("bad records: %q", r)
+	}
+	if _, err := w.Write([]byte("e\x00")); err != nil {
+		t.Fatal(err)
+	}
+	if r := readAll(); len(r) != 1 || r[0] != "de" {
+		t.Fatalf("bad partial record: %q", r)
+	}
+	//the index sits just past the last delimiter so a restart resumes cleanly
+	if lnr.Index() != 12 {
+		t.Fatal("bad index", lnr.Index())
+	}
+	if off, err := tailOffset(name, 2, 0); err != nil || off != 5 {
+		t.Fatal("bad tail offset", off, err)
+	}
+}
+
+func TestTailOffset(t *testing.T) {
+	fout, fname, err := newFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fout.Close()
+	defer cleanFile(fname, t)
+	long := strings.Repeat("x", 3*buffBlockSize) + "\n"
+	tests := []struct {
+		data string
+		n    int
+		off  int
+	}{
+		{"", 2, 0},
+		{"a\nb\nc\n", 2, 2},
+		{"a\nb\nc\n", 3, 0},
+		{"a\nb\nc\n", 10, 0},
+		{"a\nb\nc", 1, 4}, //trailing partial line counts as a line
+		{"a\nb\nc", 2, 2},
+		{"a\n\nc\n", 2, 2},  //empty lines count
+		{"a\nb\nc\n", 0, 6}, //nothing requested starts at the end
+		{long + "a\nb\n", 2, len(long)},
+		{long + long + "a\n", 2, len(long)},
+	}
+	for i, tt := range tests {
+		if err := ioutil.WriteFile(fname, []byte(tt.data), 0660); err != nil {
+			t.Fatal(err)
+		}
+		if off, err := tailOffset(fname, tt.n, '\n'); err != nil {
+			t.Fatal(err)
+		} else if off != int64(tt.off) {
+			t.Fatalf("%d: bad offset %d != %d", i, off, tt.off)
+		}
+	}
+}
+
+func TestLinerFlushPartial(t *testing.T) {
+	for _, flush := range []bool{false, true} {
+		f, name, err := newFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteString("a\nb"); err != nil {
+			t.Fatal(err)
+		} else if _, err := f.Seek(0, 0); err != nil {
+			t.Fatal(err)
+		}
+		lnr, err := NewLineReader(ReaderConfig{
+			Fin:          f,
+			MaxLineLen:   defMaxLine,
+			FlushPartial: flush,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ln, ok, _, err := lnr.ReadEntry(); err != nil || !ok || string(ln) != `a` {
+			t.Fatalf("bad first line %q %v %v", ln, ok, err)
+		} else if ln, ok, _, err := lnr.ReadEntry(); err != nil || ok {
+			t.Fatalf("partial line was returned %q %v", ln, err)
+		}
+		ln, ok := lnr.FlushEntry()
+		if ok != flush || (flush && string(ln) != `b`) {
+			t.Fatalf("flush %v: bad flushed line %q %v", flush, ln, ok)
+		} else if _, ok := lnr.FlushEntry(); ok {
+			t.Fatal("partial line flushed twice")
+		}
+		lnr.Close()
+		cleanFile(name, t)
+	}
+}
+
+func TestReadBufferSize(t *testing.T) {
+	f, name, err := newFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+	for _, sz := range []int{-1, MinReadBufferSize - 1, MaxReadBufferSize + 1} {
+		if _, err := NewReader(ReaderConfig{Fin: f, MaxLineLen: defMaxLine, BufferSize: sz}); !errors.Is(err, ErrReadBufferSize) {
+			t.Fatalf("buffer size %d was not rejected: %v", sz, err)
+		} else if err = (FollowerEngineConfig{ReadBufferSize: sz}).validate(); !errors.Is(err, ErrReadBufferSize) {
+			t.Fatalf("engine buffer size %d was not rejected: %v", sz, err)
+		}
+	}
+
+	//lines longer than the buffer still come back whole
+	long := strings.Repeat(`x`, 3*MinReadBufferSize)
+	if _, err := f.WriteString(long + "\nshort\n" + long + "\n"); err != nil {
+		t.Fatal(err)
+	} else if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	lnr, err := NewReader(ReaderConfig{Fin: f, MaxLineLen: defMaxLine, BufferSize: MinReadBufferSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, exp := range []string{long, `short`, long} {
+		if ln, ok, _, err := lnr.ReadEntry(); err != nil || !ok || string(ln) != exp {
+			t.Fatalf("bad line %d bytes %v %v", len(ln), ok, err)
+		}
+	}
+
+	//multiline records start with room for the buffer, capped at the record limit
+	for _, tt := range []struct {
+		buf, max, hint int
+	}{
+		{0, 0, DefaultReadBufferSize},
+		{MinReadBufferSize, 0, MinReadBufferSize},
+		{MaxReadBufferSize, 1024, 1024},
+	} {
+		mr, err := NewMultilineReader(lnr, ReaderConfig{
+			MultilineStart:    `^\S`,
+			MaxLineLen:        defaultMaxLine,
+			MaxMultilineBytes: tt.max,
+			BufferSize:        tt.buf,
+		})
+		if err != nil {
+			t.Fatal(err)
+		} else if mr.hint != tt.hint {
+			t.Fatalf("buffer %d max %d: bad record hint %d != %d", tt.buf, tt.max, mr.hint, tt.hint)
+		}
+	}
+	lnr.Close()
+
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	defer fm.Close()
+	if err := fm.SetReadBufferSize(MinReadBufferSize - 1); !errors.Is(err, ErrReadBufferSize) {
+		t.Fatalf("manager buffer size was not rejected: %v", err)
+	} else if err := fm.SetReadBufferSize(MaxReadBufferSize); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// BenchmarkReadBufferSize reads a large file with several buffer sizes, on Linux the
+// read calls made per pass are reported from /proc/self/io
+func BenchmarkReadBufferSize(b *testing.B) {
+	f, name, err := newFile()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(name)
+	defer f.Close()
+	ln := append(randomString(127), '\n')
+	for i := 0; i < 256*1024; i++ {
+		if _, err := f.Write(ln); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for _, sz := range []int{MinReadBufferSize, DefaultReadBufferSize, 64 * 1024, 1024 * 1024} {
+		b.Run(fmt.Sprintf("%d", sz), func(b *testing.B) {
+			b.SetBytes(int64(len(ln)) * 256 * 1024)
+			start, counted := readSyscalls()
+			for i := 0; i < b.N; i++ {
+				lnr, err := NewLineReader(ReaderConfig{Fin: f, MaxLineLen: defaultMaxLine, BufferSize: sz})
+				if err != nil {
+					b.Fatal(err)
+				}
+				for {
+					_, ok, _, err := lnr.ReadEntry()
+					if err != nil {
+						b.Fatal(err)
+					} else if !ok {
+						break
+					}
+				}
+			}
+			if end, ok := readSyscalls(); counted && ok {
+				b.ReportMetric(float64(end-start)/float64(b.N), "reads/op")
+			}
+		})
+	}
+}
+
+// readSyscalls returns the number of read calls the process has made
+func readSyscalls() (uint64, bool) {
+	bts, err := ioutil.ReadFile(`/proc/self/io`)
+	if err != nil {
+		return 0, false
+	}
+	for _, l := range strings.Split(string(bts), "\n") {
+		if strings.HasPrefix(l, `syscr:`) {
+			n, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(l, `syscr:`)), 10, 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
@@ -10,7 +10,6 @@ package filewatch
 
 import (
 	"bufio"
-	"bytes"
 	"errors"
 	"io"
 	"os"
@@ -18,10 +17,14 @@ import (
 )
 
 type LineReader struct {
-	fpath    string
-	currLine []byte
-	idx      int64
-	maxLine  int
+	fpath        string
+	currLine     []byte
+	idx          int64
+	maxLine      int
+	bufSize      int
+	keepDelim    bool
+	delim        byte
+	flushPartial bool
 }
 
 func NewLineReader(cfg ReaderConfig) (*LineReader, error) {
@@ -34,16 +37,25 @@ func NewLineReader(cfg ReaderConfig) (*LineReader, error) {
 	if cfg.StartIndex < 0 {
 		return nil, errors.New("Invalid start index")
 	}
+	delim, err := parseDelimiter(cfg.Delimiter)
+	if err != nil {
+		return nil, err
+	}
 	fpath := cfg.Fin.Name()
 	return &LineReader{
-		fpath:   fpath,
-		idx:     cfg.StartIndex,
-		maxLine: cfg.MaxLineLen,
+		fpath:        fpath,
+		idx:          cfg.StartIndex,
+		maxLine:      cfg.MaxLineLen,
+		bufSize:      readBufferSize(cfg.BufferSize),
+		keepDelim:    cfg.KeepDelimiter,
+		flushPartial: cfg.FlushPartial,
+		delim:        delim,
 	}, nil
 }
 
 func (lr *LineReader) SeekFile(offset int64) error {
 	lr.idx = offset
+	lr.currLine = nil //partial lines are from the old position
 	return nil
 }
 
@@ -66,10 +78,10 @@ func (lr *LineReader) ReadEntry() (ln []byte, ok bool, sawEOF bool, err error) {
 		return
 	}
 	defer fin.Close()
-	brdr := bufio.NewReader(fin)
+	brdr := bufio.NewReaderSize(fin, lr.bufSize)
 	for {
 		//ReadBytes garuntees that it returns err == nil ONLY when the results hit the delimiter
-		b, lerr := brdr.ReadBytes(byte('\n'))
+		b, lerr := brdr.ReadBytes(lr.delim)
 		//legit error
 		if lerr != nil && lerr != io.EOF {
 			err = lerr //set the error for return
@@ -85,7 +97,18 @@ func (lr *LineReader) ReadEntry() (ln []byte, ok bool, sawEOF bool, err error) {
 		}
 		//we got something, add to our index, trim, and check
 		lr.idx += int64(len(b))
-		b = bytes.TrimRight(b, "\r\n")
+		if lr.keepDelim {
+			//hand back the raw bytes, only holding on to partial lines
+			if lerr == io.EOF {
+				lr.currLine = append(lr.currLine, b...)
+				return
+			}
+			ln = append(lr.currLine, b...)
+			lr.currLine = nil
+			ok = true
+			break
+		}
+		b = trimDelim(b, lr.delim)
 		if len(b) == 0 {
 			//we just got the ending to a line that we had the beginning of
 			if len(lr.currLine) != 0 {
@@ -125,3 +148,14 @@ func (lr *LineReader) Index() int64 {
 func (lr *LineReader) Close() error {
 	return nil
 }
+
+// FlushEntry hands back a trailing partial line that is still waiting on its delimiter,
+// nothing is returned unless the reader was configured with FlushPartial
+func (lr *LineReader) FlushEntry() (ln []byte, ok bool) {
+	if !lr.flushPartial || len(lr.currLine) == 0 {
+		return
+	}
+	ln, ok = lr.currLine, true
+	lr.currLine = nil
+	return
+}
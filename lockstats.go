@@ -0,0 +1,219 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LockStats describes contention on the filter manager lock while instrumentation
+// was enabled.  Wait is the time spent blocked acquiring the lock, Hold is the
+// time between acquiring and releasing it.  Shared acquisitions by read only calls
+// count towards Acquisitions and Wait, only exclusive holds are timed.
+type LockStats struct {
+	Acquisitions uint64
+	WaitTotal    time.Duration
+	WaitMax      time.Duration
+	HoldTotal    time.Duration
+	HoldMax      time.Duration
+}
+
+// ManagerStats is a snapshot of filter manager diagnostics
+type ManagerStats struct {
+	Followed int
+	Files    int //distinct paths being followed, a file matched by several filters counts once
+	Open     int //followers holding an open file, see SetMaxOpenFollowers
+	Filters  int
+	States   int
+	Lock     LockStats
+
+	DroppedEvents uint64 //events lost because the Events channel was full
+
+	BytesRead  uint64 //raw bytes consumed from followed files
+	Records    uint64 //records accepted by handlers
+	ReadErrors uint64 //failed reads from followed files
+	Rotations  uint64 //renames and truncations of followed files that were handled
+
+	DroppedRecords uint64 //records skipped after the handler kept failing, see RetryPolicy
+	DeadLettered   uint64 //records handed to the dead letter sink, see SetDeadLetter
+	HandlerPanics  uint64 //handler calls that panicked, see SetPanicPolicy
+}
+
+// ioCounters are the throughput counters shared by the manager and its followers,
+// they are updated atomically so followers never touch the manager lock
+type ioCounters struct {
+	bytes     uint64
+	records   uint64
+	readErrs  uint64
+	rotations uint64
+	dropped   uint64
+	dlq       uint64 //records taken by the dead letter sink
+	panics    uint64
+}
+
+// the counter methods are safe to call on nil counters, followers created outside
+// of a manager do not have any
+func (ic *ioCounters) addBytes(n int64) {
+	if ic != nil && n > 0 {
+		atomic.AddUint64(&ic.bytes, uint64(n))
+	}
+}
+
+func (ic *ioCounters) addRecords(n int) {
+	if ic != nil {
+		atomic.AddUint64(&ic.records, uint64(n))
+	}
+}
+
+func (ic *ioCounters) readError() {
+	if ic != nil {
+		atomic.AddUint64(&ic.readErrs, 1)
+	}
+}
+
+func (ic *ioCounters) rotation() {
+	if ic != nil {
+		atomic.AddUint64(&ic.rotations, 1)
+	}
+}
+
+func (ic *ioCounters) deadLetter(n int) {
+	if ic != nil {
+		atomic.AddUint64(&ic.dlq, uint64(n))
+	}
+}
+
+func (ic *ioCounters) handlerPanic() {
+	if ic != nil {
+		atomic.AddUint64(&ic.panics, 1)
+	}
+}
+
+func (ic *ioCounters) dropRecords(n int) {
+	if ic != nil {
+		atomic.AddUint64(&ic.dropped, uint64(n))
+	}
+}
+
+// statMutex is a read write mutex that can optionally time acquisitions and hold durations,
+// when instrumentation is off the only overhead is an atomic load per Lock and Unlock
+type statMutex struct {
+	mtx      sync.RWMutex
+	enabled  int32
+	lockedAt time.Time //only touched while holding mtx
+
+	smtx  sync.Mutex //protects stats
+	stats LockStats
+}
+
+func newStatMutex() *statMutex {
+	return &statMutex{}
+}
+
+func (sm *statMutex) Lock() {
+	if atomic.LoadInt32(&sm.enabled) == 0 {
+		sm.mtx.Lock()
+		return
+	}
+	start := time.Now()
+	sm.mtx.Lock()
+	sm.lockedAt = time.Now()
+	sm.recordWait(sm.lockedAt.Sub(start))
+}
+
+func (sm *statMutex) RLock() {
+	if atomic.LoadInt32(&sm.enabled) == 0 {
+		sm.mtx.RLock()
+		return
+	}
+	start := time.Now()
+	sm.mtx.RLock()
+	sm.recordWait(time.Since(start))
+}
+
+func (sm *statMutex) RUnlock() {
+	sm.mtx.RUnlock()
+}
+
+func (sm *statMutex) recordWait(wait time.Duration) {
+	sm.smtx.Lock()
+	sm.stats.Acquisitions++
+	sm.stats.WaitTotal += wait
+	if wait > sm.stats.WaitMax {
+		sm.stats.WaitMax = wait
+	}
+	sm.smtx.Unlock()
+}
+
+func (sm *statMutex) Unlock() {
+	if sm.lockedAt.IsZero() {
+		sm.mtx.Unlock()
+		return
+	}
+	hold := time.Since(sm.lockedAt)
+	sm.lockedAt = time.Time{}
+	sm.mtx.Unlock()
+	sm.smtx.Lock()
+	sm.stats.HoldTotal += hold
+	if hold > sm.stats.HoldMax {
+		sm.stats.HoldMax = hold
+	}
+	sm.smtx.Unlock()
+}
+
+// setEnabled toggles instrumentation, enabling it resets the collected stats
+func (sm *statMutex) setEnabled(v bool) {
+	if !v {
+		atomic.StoreInt32(&sm.enabled, 0)
+		return
+	}
+	sm.smtx.Lock()
+	sm.stats = LockStats{}
+	sm.smtx.Unlock()
+	atomic.StoreInt32(&sm.enabled, 1)
+}
+
+func (sm *statMutex) snapshot() LockStats {
+	sm.smtx.Lock()
+	defer sm.smtx.Unlock()
+	return sm.stats
+}
+
+// SetLockInstrumentation enables or disables timing of the manager lock, it is off by
+// default.  Enabling it resets the lock stats reported by Stats.
+func (fm *FilterManager) SetLockInstrumentation(v bool) {
+	fm.mtx.setEnabled(v)
+}
+
+// Stats returns a snapshot of the manager counters and lock contention
+func (fm *FilterManager) Stats() (ms ManagerStats) {
+	fm.mtx.RLock()
+	ms.Followed = len(fm.followers)
+	paths := make(map[string]struct{}, len(fm.followers))
+	for k := range fm.followers {
+		paths[k.FilePath] = struct{}{}
+	}
+	ms.Files = len(paths)
+	ms.Open = fm.nolockOpenFollowers()
+	ms.Filters = len(fm.filters)
+	ms.States = len(fm.states)
+	fm.mtx.RUnlock()
+	ms.Lock = fm.mtx.snapshot()
+	ms.DroppedEvents = fm.events.droppedEvents()
+	ms.BytesRead = atomic.LoadUint64(&fm.counters.bytes)
+	ms.Records = atomic.LoadUint64(&fm.counters.records)
+	ms.ReadErrors = atomic.LoadUint64(&fm.counters.readErrs)
+	ms.Rotations = atomic.LoadUint64(&fm.counters.rotations)
+	ms.DroppedRecords = atomic.LoadUint64(&fm.counters.dropped)
+	ms.DeadLettered = atomic.LoadUint64(&fm.counters.dlq)
+	ms.HandlerPanics = atomic.LoadUint64(&fm.counters.panics)
+	return
+}
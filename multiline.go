@@ -0,0 +1,142 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"regexp"
+)
+
+// entryFlusher is implemented by readers that hold data back while waiting for the
+// rest of a record, FlushEntry hands back whatever is held as a final record
+type entryFlusher interface {
+	FlushEntry() ([]byte, bool)
+}
+
+// MultilineReader groups the records of another reader into multiline records, a
+// record begins with a line that matches the start expression and runs until the next
+// one.  Index only advances past complete records so a restart rereads anything that
+// was still being buffered.
+type MultilineReader struct {
+	Reader
+	start  *regexp.Regexp
+	max    int
+	hint   int //capacity given to a new record, the read buffer size capped at max
+	sep    []byte
+	buff   []byte
+	buffed int64 //index of the underlying reader after the last buffered line
+	idx    int64 //index just past the last record handed out
+}
+
+func NewMultilineReader(rdr Reader, cfg ReaderConfig) (*MultilineReader, error) {
+	start, err := regexp.Compile(cfg.MultilineStart)
+	if err != nil {
+		return nil, err
+	}
+	delim, err := parseDelimiter(cfg.Delimiter)
+	if err != nil {
+		return nil, err
+	}
+	mr := &MultilineReader{
+		Reader: rdr,
+		start:  start,
+		max:    cfg.MaxMultilineBytes,
+		buffed: rdr.Index(),
+		idx:    rdr.Index(),
+	}
+	if mr.max <= 0 {
+		mr.max = cfg.MaxLineLen
+	}
+	if mr.hint = readBufferSize(cfg.BufferSize); mr.max > 0 && mr.hint > mr.max {
+		mr.hint = mr.max
+	}
+	if !cfg.KeepDelimiter {
+		mr.sep = []byte{delim}
+	}
+	return mr, nil
+}
+
+func (mr *MultilineReader) ReadEntry() (ln []byte, ok bool, wasEOF bool, err error) {
+	for {
+		var l []byte
+		var lok bool
+		if l, lok, wasEOF, err = mr.Reader.ReadEntry(); err != nil || !lok {
+			return
+		}
+		if len(mr.buff) > 0 && mr.start.Match(l) {
+			//a new record started, hand back the one we were building
+			ln, ok = mr.buff, true
+			mr.idx = mr.buffed
+			mr.buff = mr.newRecord(l)
+			mr.buffed = mr.Reader.Index()
+			return
+		}
+		if len(mr.buff) > 0 {
+			mr.buff = append(mr.buff, mr.sep...)
+			mr.buff = append(mr.buff, l...)
+		} else {
+			mr.buff = mr.newRecord(l)
+		}
+		mr.buffed = mr.Reader.Index()
+		if mr.max > 0 && len(mr.buff) >= mr.max {
+			//runaway record, emit what we have
+			ln, ok = mr.FlushEntry()
+			return
+		}
+	}
+}
+
+// FlushEntry hands back the record being built even though the next start line has
+// not shown up yet, a partial line flushed out of the underlying reader is grouped
+// like any other line so it may take two calls to drain everything
+func (mr *MultilineReader) FlushEntry() (ln []byte, ok bool) {
+	if ef, isFlusher := mr.Reader.(entryFlusher); isFlusher {
+		if l, lok := ef.FlushEntry(); lok {
+			if len(mr.buff) > 0 && mr.start.Match(l) {
+				ln, ok = mr.buff, true
+				mr.idx = mr.buffed
+				mr.buff = mr.newRecord(l)
+				mr.buffed = mr.Reader.Index()
+				return
+			}
+			if len(mr.buff) > 0 {
+				mr.buff = append(mr.buff, mr.sep...)
+			}
+			mr.buff = append(mr.buff, l...)
+			mr.buffed = mr.Reader.Index()
+		}
+	}
+	if len(mr.buff) == 0 {
+		return
+	}
+	ln, ok = mr.buff, true
+	mr.buff = nil
+	mr.idx = mr.buffed
+	return
+}
+
+// newRecord starts a record with a copy of l, the record gets enough room up front
+// that most continuation lines are appended without growing it
+func (mr *MultilineReader) newRecord(l []byte) []byte {
+	n := mr.hint
+	if n < len(l) {
+		n = len(l)
+	}
+	return append(make([]byte, 0, n), l...)
+}
+
+func (mr *MultilineReader) SeekFile(offset int64) error {
+	mr.buff = nil
+	mr.buffed = offset
+	mr.idx = offset
+	return mr.Reader.SeekFile(offset)
+}
+
+func (mr *MultilineReader) Index() int64 {
+	return mr.idx
+}
@@ -0,0 +1,145 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestMultilineReader(t *testing.T, cfg ReaderConfig) (Reader, *os.File, string) {
+	f, name, err := newFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Fin = f
+	cfg.MaxLineLen = defMaxLine
+	rdr, err := NewReader(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rdr, w, name
+}
+
+func readEntries(t *testing.T, rdr Reader) (r []string) {
+	for {
+		ln, ok, _, err := rdr.ReadEntry()
+		if err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			return
+		}
+		r = append(r, string(ln))
+	}
+}
+
+func TestMultilineReader(t *testing.T) {
+	rdr, w, name := newTestMultilineReader(t, ReaderConfig{MultilineStart: `^\d{4}-`, MaxMultilineBytes: 1024})
+	defer cleanFile(name, t)
+	defer rdr.Close()
+	defer w.Close()
+	first := "2024-01-01 panic: boom\ngoroutine 1:\n\tmain.go:10\n"
+	second := "2024-01-01 recovered\n"
+	if _, err := w.WriteString(first + second); err != nil {
+		t.Fatal(err)
+	}
+	//the second record is held until the next start line shows up
+	if r := readEntries(t, rdr); !reflect.DeepEqual(r, []string{"2024-01-01 panic: boom\ngoroutine 1:\n\tmain.go:10"}) {
+		t.Fatalf("bad records: %q", r)
+	}
+	if rdr.Index() != int64(len(first)) {
+		t.Fatal("index moved past a held record", rdr.Index())
+	}
+	if _, err := w.WriteString("\tmore\n2024-01-02 next\n"); err != nil {
+		t.Fatal(err)
+	}
+	if r := readEntries(t, rdr); !reflect.DeepEqual(r, []string{"2024-01-01 recovered\n\tmore"}) {
+		t.Fatalf("bad records: %q", r)
+	}
+	ef, ok := rdr.(entryFlusher)
+	if !ok {
+		t.Fatal("multiline reader cannot be flushed")
+	}
+	if ln, ok := ef.FlushEntry(); !ok || string(ln) != "2024-01-02 next" {
+		t.Fatalf("bad flushed record: %q", ln)
+	} else if _, ok := ef.FlushEntry(); ok {
+		t.Fatal("flushed record twice")
+	}
+	if fi, err := w.Stat(); err != nil || rdr.Index() != fi.Size() {
+		t.Fatal("bad index after flush", rdr.Index(), err)
+	}
+}
+
+func TestMultilineReaderMaxBytes(t *testing.T) {
+	rdr, w, name := newTestMultilineReader(t, ReaderConfig{MultilineStart: `^START`, MaxMultilineBytes: 10})
+	defer cleanFile(name, t)
+	defer rdr.Close()
+	defer w.Close()
+	//no start markers at all, records are cut at the cap
+	if _, err := w.WriteString("aaaa\nbbbb\ncccc\ndd\n"); err != nil {
+		t.Fatal(err)
+	}
+	if r := readEntries(t, rdr); !reflect.DeepEqual(r, []string{"aaaa\nbbbb\ncccc"}) {
+		t.Fatalf("bad records: %q", r)
+	}
+	if rdr.Index() != 15 {
+		t.Fatal("bad index", rdr.Index())
+	}
+}
+
+func TestMultilineFollower(t *testing.T) {
+	fm, name := newTestFilterManager(t)
+	defer cleanFile(name, t)
+	fname, err := newFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(fname, t)
+	if err := ioutil.WriteFile(fname, []byte("START a\n1\nSTART b\n2\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	fcfg := FilterConfig{
+		FollowerEngineConfig: FollowerEngineConfig{MultilineStart: `^START`},
+		BaseName:             bName,
+		Location:             filepath.Dir(fname),
+		Matches:              []string{filepath.Base(fname)},
+	}
+	lh := &orderedLH{}
+	if err := fm.AddFilterConfig(fcfg, lh); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := fm.LoadFile(fname); err != nil || !ok {
+		t.Fatal("failed to load", ok, err)
+	}
+	ctx, cf := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cf()
+	if err := fm.WaitCaughtUp(ctx, FileName{BaseName: bName, FilePath: fname}); err == nil {
+		t.Fatal("caught up while a record was held")
+	}
+	//closing flushes the held record
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if lines := lh.take(); !reflect.DeepEqual(lines, []string{"START a\n1", "START b\n2"}) {
+		t.Fatalf("bad records: %q", lines)
+	}
+	fcfg.MultilineStart = `(`
+	if err := fm.AddFilterConfig(fcfg, lh); err == nil {
+		t.Fatal("invalid multiline start was accepted")
+	}
+}
@@ -0,0 +1,163 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"os"
+	"time"
+
+	"github.com/gravwell/ingest/v3"
+)
+
+// ManagerOption configures optional behavior of a FilterManager at creation time
+type ManagerOption func(*managerConfig)
+
+const (
+	defaultStateFileMode os.FileMode = 0660
+	corruptStateSuffix   string      = `.corrupt`
+)
+
+type managerConfig struct {
+	codec          StateCodec
+	flushInterval  time.Duration
+	fileMode       os.FileMode
+	logger         ingest.IngestLogger
+	recoverCorrupt bool
+	autoResume     bool
+	idStrat        FileIdStrategy
+	missingGrace   time.Duration
+	missingPolicy  MissingFilePolicy
+	trace          traceConfig
+	hdrCheck       bool
+	maxFiles       int
+}
+
+func newManagerConfig(opts []ManagerOption) managerConfig {
+	mc := managerConfig{
+		codec:    GobCodec{},
+		fileMode: defaultStateFileMode,
+		logger:   ingest.NoLogger(),
+		idStrat:  InodeIdStrategy{},
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&mc)
+		}
+	}
+	return mc
+}
+
+// WithStateCodec sets the codec used to read and write the state file, a nil codec
+// keeps the default gob encoding.  Existing state files must be in the chosen format.
+func WithStateCodec(c StateCodec) ManagerOption {
+	return func(mc *managerConfig) {
+		if c != nil {
+			mc.codec = c
+		}
+	}
+}
+
+// WithFlushInterval periodically writes the state file in the background so that
+// a crash only loses the progress made since the last flush.  A zero interval keeps
+// the default behavior of only writing states on Close and FlushStates.
+func WithFlushInterval(d time.Duration) ManagerOption {
+	return func(mc *managerConfig) {
+		mc.flushInterval = d
+	}
+}
+
+// WithStateFileMode sets the permissions used when the state file is created, the
+// default is 0660.  The process umask still applies and existing files keep their mode.
+func WithStateFileMode(mode os.FileMode) ManagerOption {
+	return func(mc *managerConfig) {
+		mc.fileMode = mode.Perm()
+	}
+}
+
+// WithMaxFilesWatched caps the number of followers from creation time, it is
+// equivalent to SetMaxFilesWatched.  Zero, the default, is no cap.
+func WithMaxFilesWatched(max int) ManagerOption {
+	return func(mc *managerConfig) {
+		mc.maxFiles = max
+	}
+}
+
+// WithLogger sets the logger from creation time so problems loading the state file
+// are reported, it is equivalent to SetLogger for everything after that
+func WithLogger(lgr ingest.IngestLogger) ManagerOption {
+	return func(mc *managerConfig) {
+		if lgr != nil {
+			mc.logger = lgr
+		}
+	}
+}
+
+// WithRecoverFromCorruptState controls what happens when the state file cannot be
+// decoded.  By default creating the manager fails, when recovery is enabled the bad
+// file is renamed to <stateFile>.corrupt and the manager starts with no states.
+func WithRecoverFromCorruptState(v bool) ManagerOption {
+	return func(mc *managerConfig) {
+		mc.recoverCorrupt = v
+	}
+}
+
+// WithAutoResume makes AddFilter scan the location of the new filter and start
+// following every matching file that already exists, picking up at the saved offsets.
+// This is for users of a bare FilterManager, a WatchManager already scans on Start.
+func WithAutoResume(v bool) ManagerOption {
+	return func(mc *managerConfig) {
+		mc.autoResume = v
+	}
+}
+
+// WithFileIdStrategy sets how files are identified across renames and restarts, a nil
+// strategy keeps the default InodeIdStrategy.  See HashIdStrategy for filesystems
+// without stable inodes.
+func WithFileIdStrategy(s FileIdStrategy) ManagerOption {
+	return func(mc *managerConfig) {
+		if s != nil {
+			mc.idStrat = s
+		}
+	}
+}
+
+// WithMissingStateGrace keeps the saved state of a file that does not exist when the
+// manager is created for d rather than dropping it immediately.  If the file comes
+// back within the window it resumes at its old offset, or from the start if a
+// different file took its place.  States still missing after the window are dropped
+// at the next state flush.  Zero, the default, drops missing states at creation.
+func WithMissingStateGrace(d time.Duration) ManagerOption {
+	return func(mc *managerConfig) {
+		mc.missingGrace = d
+	}
+}
+
+// WithMissingFilePolicy sets what happens to the states of files that are missing when
+// the manager is created, the default MissingDrop deletes them unless
+// WithMissingStateGrace is set.  MissingRetain keeps them indefinitely for files that
+// are expected to reappear, such as daily logs recreated at the same path.  A retained
+// state is only resumed if the file that appears is the same file: a different file id
+// or a file smaller than the saved offset is treated as a truncation and read from 0.
+func WithMissingFilePolicy(p MissingFilePolicy) ManagerOption {
+	return func(mc *managerConfig) {
+		mc.missingPolicy = p
+	}
+}
+
+// WithHeaderCheck stores a hash of the first HeaderCheckBytes of every file alongside
+// its offset and checks it whenever the manager starts and when a file that was
+// missing comes back.  A file whose start changed is read again from 0, this catches
+// files replaced on filesystems that reuse inodes, where the id and size checks cannot
+// tell the new file apart.  It is off by default because it adds a read of every
+// file on startup and of every file that moved each time states are flushed.
+func WithHeaderCheck(v bool) ManagerOption {
+	return func(mc *managerConfig) {
+		mc.hdrCheck = v
+	}
+}
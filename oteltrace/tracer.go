@@ -0,0 +1,76 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+// Package oteltrace adapts an OpenTelemetry tracer provider to filewatch.Tracer so
+// handler calls can be traced, it lives in its own package so the core filewatch
+// package does not depend on OpenTelemetry.
+package oteltrace
+
+import (
+	"context"
+
+	"github.com/gravwell/filewatch/v3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	InstrumentationName = `github.com/gravwell/filewatch`
+	SpanName            = `filewatch.deliver`
+
+	AttrFilePath = attribute.Key(`file.path`)
+	AttrFilter   = attribute.Key(`filewatch.filter`)
+	AttrFilterID = attribute.Key(`filewatch.filter_id`)
+	AttrOffset   = attribute.Key(`filewatch.offset`)
+	AttrRecords  = attribute.Key(`filewatch.records`)
+	AttrBytes    = attribute.Key(`filewatch.bytes`)
+)
+
+// Tracer is a filewatch.Tracer starting OpenTelemetry spans
+type Tracer struct {
+	t trace.Tracer
+}
+
+// New builds a tracer using tp, a nil provider uses the global provider
+func New(tp trace.TracerProvider) *Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return &Tracer{
+		t: tp.Tracer(InstrumentationName),
+	}
+}
+
+// StartSpan starts a consumer span tagged with the file path and offset of d
+func (t *Tracer) StartSpan(ctx context.Context, d filewatch.DeliveryInfo) (context.Context, filewatch.Span) {
+	ctx, sp := t.t.Start(ctx, SpanName,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			AttrFilePath.String(d.FilePath),
+			AttrFilter.String(d.BaseName),
+			AttrFilterID.Int(d.FilterID),
+			AttrOffset.Int64(d.Offset),
+			AttrRecords.Int(d.Records),
+			AttrBytes.Int(d.Bytes),
+		))
+	return ctx, span{sp}
+}
+
+type span struct {
+	sp trace.Span
+}
+
+func (s span) End(err error) {
+	if err != nil {
+		s.sp.RecordError(err)
+		s.sp.SetStatus(codes.Error, err.Error())
+	}
+	s.sp.End()
+}
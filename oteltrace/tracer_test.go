@@ -0,0 +1,162 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package oteltrace
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gravwell/filewatch/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var errBad = errors.New("bad record")
+
+type batchLH struct {
+	sync.Mutex
+	batches int
+}
+
+func (b *batchLH) HandleLog(ln []byte, ts time.Time) error {
+	return b.HandleBatch([][]byte{ln}, ts)
+}
+
+func (b *batchLH) HandleBatch(recs [][]byte, ts time.Time) error {
+	b.Lock()
+	b.batches++
+	b.Unlock()
+	return nil
+}
+
+func TestTracer(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `oteltrace`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fpath := filepath.Join(dir, `test.log`)
+	if err := ioutil.WriteFile(fpath, []byte("one\ntwo\nbad\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	//one span per record without batching, carrying the handler error and span context
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	fm, err := filewatch.NewFilterManager(filepath.Join(dir, `state1`),
+		filewatch.WithTracer(New(tp), filewatch.TracePerBatch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fm.SetRetryPolicy(filewatch.RetryPolicy{OnFailure: filewatch.FailDrop})
+	var mtx sync.Mutex
+	var untraced int
+	lh := filewatch.ContextHandlerFunc(func(ctx context.Context, b []byte, ts time.Time) error {
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			mtx.Lock()
+			untraced++
+			mtx.Unlock()
+		}
+		if bytes.Equal(b, []byte(`bad`)) {
+			return errBad
+		}
+		return nil
+	})
+	if err := fm.AddFilter(`test`, dir, []string{`*.log`}, lh, filewatch.FollowerEngineConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fm.LoadFile(fpath); err != nil {
+		t.Fatal(err)
+	}
+	spans := waitSpans(t, sr, 3)
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if untraced != 0 {
+		t.Fatalf("%d handler calls did not get the span context", untraced)
+	}
+	for i, sp := range spans {
+		attrs := attrMap(sp.Attributes())
+		if sp.Name() != SpanName || sp.SpanKind() != trace.SpanKindConsumer {
+			t.Fatalf("bad span %d: %s %v", i, sp.Name(), sp.SpanKind())
+		} else if attrs[AttrFilePath].AsString() != fpath || attrs[AttrFilter].AsString() != `test` {
+			t.Fatalf("bad span %d file attributes: %v", i, attrs)
+		} else if off := attrs[AttrOffset].AsInt64(); off != int64(i*4) {
+			t.Fatalf("span %d offset %d != %d", i, off, i*4)
+		} else if recs := attrs[AttrRecords].AsInt64(); recs != 1 {
+			t.Fatalf("span %d covers %d records", i, recs)
+		}
+		if i < 2 && sp.Status().Code != codes.Unset {
+			t.Fatalf("span %d has status %v", i, sp.Status())
+		} else if i == 2 && (sp.Status().Code != codes.Error || sp.Status().Description != errBad.Error()) {
+			t.Fatalf("failed span has status %v", sp.Status())
+		}
+	}
+
+	//per record spans when batching, each covering the batch call
+	sr = tracetest.NewSpanRecorder()
+	tp = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	if fm, err = filewatch.NewFilterManager(filepath.Join(dir, `state2`),
+		filewatch.WithTracer(New(tp), filewatch.TracePerRecord)); err != nil {
+		t.Fatal(err)
+	}
+	blh := &batchLH{}
+	if err := fm.AddFilter(`test`, dir, []string{`*.log`}, blh, filewatch.FollowerEngineConfig{BatchSize: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fm.LoadFile(fpath); err != nil {
+		t.Fatal(err)
+	}
+	spans = waitSpans(t, sr, 3)
+	if err := fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if blh.batches != 1 {
+		t.Fatalf("expected a single batch, got %d", blh.batches)
+	}
+	for i, sp := range spans {
+		attrs := attrMap(sp.Attributes())
+		if off := attrs[AttrOffset].AsInt64(); off != int64(i*4) {
+			t.Fatalf("span %d offset %d != %d", i, off, i*4)
+		} else if recs := attrs[AttrRecords].AsInt64(); recs != 1 {
+			t.Fatalf("span %d covers %d records", i, recs)
+		}
+	}
+}
+
+func waitSpans(t *testing.T, sr *tracetest.SpanRecorder, n int) []sdktrace.ReadOnlySpan {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if spans := sr.Ended(); len(spans) >= n {
+			if len(spans) != n {
+				t.Fatalf("expected %d spans, got %d", n, len(spans))
+			}
+			return spans
+		}
+	}
+	t.Fatalf("timed out waiting for %d spans, got %d", n, len(sr.Ended()))
+	return nil
+}
+
+func attrMap(kvs []attribute.KeyValue) map[attribute.Key]attribute.Value {
+	m := make(map[attribute.Key]attribute.Value, len(kvs))
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
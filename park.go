@@ -0,0 +1,228 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var (
+	parkPollInterval = time.Second
+)
+
+// park stops the follower routine and releases the reader and notification watcher
+// so the follower no longer holds any descriptors.  The file name and state are kept,
+// unpark resumes reading at the last record the handler accepted.
+func (f *follower) park() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.parked || f.abortCh == nil || atomic.LoadInt32(&f.running) == 0 {
+		return nil
+	}
+	f.suspend = true
+	f.stop()
+	f.suspend = false
+	f.parked = true
+	//a trailing partial line is never read, so only a change to the file wakes us
+	//unless records were left unread
+	f.parkSize = f.offset()
+	if fi, err := os.Stat(f.name().FilePath); err == nil && len(f.batch) == 0 && readIndex(f.lnr) >= fi.Size() {
+		f.parkSize = fi.Size()
+	}
+	f.batch = nil
+	ferr := f.fsn.Close()
+	if err := f.lnr.Close(); err != nil {
+		return err
+	}
+	return ferr
+}
+
+// unpark reopens a parked follower at its current state and restarts its routine
+func (f *follower) unpark() error {
+	f.mtx.Lock()
+	if !f.parked {
+		f.mtx.Unlock()
+		return nil
+	}
+	idx := atomic.LoadInt64(f.state)
+	lnr, id, err := openReader(f.name().FilePath, idx, f.rcfg, f.idStrat)
+	if err != nil {
+		f.mtx.Unlock()
+		return err
+	}
+	wtchr, err := fsnotify.NewWatcher()
+	if err != nil {
+		lnr.Close()
+		f.mtx.Unlock()
+		return err
+	}
+	f.lnr = lnr
+	f.fsn = wtchr
+	f.imtx.Lock()
+	f.id = id
+	f.imtx.Unlock()
+	f.atStart = idx == 0
+	f.parked = false
+	atomic.StoreInt64(&f.lastAct, time.Now().UnixNano())
+	f.mtx.Unlock()
+	return f.Start()
+}
+
+// readIndex returns how far into the file a reader has read, a multiline reader that
+// is holding a record has read past its own index
+func readIndex(r Reader) int64 {
+	if mr, ok := r.(*MultilineReader); ok {
+		return readIndex(mr.Reader)
+	}
+	return r.Index()
+}
+
+// isParked returns whether the follower currently holds no descriptors
+func (f *follower) isParked() bool {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.parked
+}
+
+// pendingData returns whether the file at our path grew, shrank, or was replaced since
+// we were parked
+func (f *follower) pendingData() bool {
+	fpath := f.name().FilePath
+	fi, err := os.Stat(fpath)
+	if err != nil {
+		return false //gone, whoever handles removal will clean us up
+	}
+	f.mtx.Lock()
+	sz := f.parkSize
+	f.mtx.Unlock()
+	if fi.Size() != sz {
+		return true
+	}
+	id, err := fileIdFromName(f.idStrat, fpath)
+	return err == nil && id != f.FileId()
+}
+
+// SetMaxOpenFollowers caps the number of followers holding open files.  When another
+// file needs to be opened the follower that went the longest without delivering a
+// record is parked, its file is closed but its state is kept, and it is transparently
+// reopened when its file grows.  Unlike SetMaxFilesWatched no file is ever dropped.
+// Zero, the default, leaves every follower open.
+func (fm *FilterManager) SetMaxOpenFollowers(max int) {
+	fm.mtx.Lock()
+	defer fm.mtx.Unlock()
+	fm.maxOpen = max
+	if max > 0 && fm.parkStop == nil {
+		fm.startParker(parkPollInterval)
+	}
+	fm.nolockMakeRoom(0)
+}
+
+// OpenFollowers returns the number of followers currently holding an open file
+func (fm *FilterManager) OpenFollowers() int {
+	fm.mtx.RLock()
+	defer fm.mtx.RUnlock()
+	return fm.nolockOpenFollowers()
+}
+
+// caller MUST HOLD THE LOCK
+func (fm *FilterManager) nolockOpenFollowers() (cnt int) {
+	for _, fl := range fm.followers {
+		if !fl.isParked() {
+			cnt++
+		}
+	}
+	return
+}
+
+// nolockMakeRoom parks the idlest followers until opening another n files stays
+// within the open follower cap
+// caller MUST HOLD THE LOCK
+func (fm *FilterManager) nolockMakeRoom(n int) {
+	if fm.maxOpen <= 0 {
+		return
+	}
+	var open []*follower
+	for _, fl := range fm.followers {
+		if fl.Running() {
+			open = append(open, fl)
+		}
+	}
+	for len(open)+n > fm.maxOpen && len(open) > 0 {
+		idx := 0
+		for i, fl := range open {
+			if fl.IdleDuration() > open[idx].IdleDuration() {
+				idx = i
+			}
+		}
+		fl := open[idx]
+		if err := fl.park(); err != nil {
+			fm.logger.Error("Failed to park follower %v: %v", fl.FilePath, err)
+		}
+		open = append(open[:idx], open[idx+1:]...)
+	}
+}
+
+// nolockWake reopens parked followers whose files have new data
+// caller MUST HOLD THE LOCK
+func (fm *FilterManager) nolockWake() {
+	if fm.paused {
+		return //woken followers would start reading
+	}
+	for name, fl := range fm.followers {
+		if !fl.isParked() || !fl.pendingData() {
+			continue
+		}
+		fm.nolockMakeRoom(1)
+		if err := fl.unpark(); err != nil {
+			fm.logger.Error("Failed to reopen parked follower %v: %v", name.FilePath, err)
+		}
+	}
+}
+
+// startParker kicks off a routine that reopens parked followers when their files grow
+// caller MUST HOLD THE LOCK
+func (fm *FilterManager) startParker(interval time.Duration) {
+	fm.parkStop = make(chan struct{})
+	fm.parkWg = &sync.WaitGroup{}
+	fm.parkOnce = &sync.Once{}
+	fm.parkWg.Add(1)
+	go func() {
+		defer fm.parkWg.Done()
+		tckr := time.NewTicker(interval)
+		defer tckr.Stop()
+		for {
+			select {
+			case <-tckr.C:
+			case <-fm.parkStop:
+				return
+			}
+			fm.mtx.Lock()
+			fm.nolockWake()
+			fm.mtx.Unlock()
+		}
+	}()
+}
+
+// stopParker stops the routine that reopens parked followers and waits for it to exit
+// the caller MUST NOT hold the lock
+func (fm *FilterManager) stopParker() {
+	fm.mtx.RLock()
+	stop, wg, once := fm.parkStop, fm.parkWg, fm.parkOnce
+	fm.mtx.RUnlock()
+	if stop == nil {
+		return
+	}
+	once.Do(func() { close(stop) })
+	wg.Wait()
+}
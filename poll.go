@@ -0,0 +1,190 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// polledFile is what the poller saw at a path on its last pass
+type polledFile struct {
+	id   FileId
+	size int64
+	dir  bool //subdirectory of a recursive watch that is not watched yet
+}
+
+// SetPollInterval makes the WatchManager also poll its watched directories every
+// interval, for filesystems such as NFS and some overlay and network mounts that
+// never deliver notifications.  Files that appear are loaded, files that disappear
+// are dropped, and a path whose file id changed is treated as a rotation, so
+// rotations are detected by inode rather than modification time.  Zero, the
+// default, relies on notifications alone.  It must be set before Start.
+func (wm *WatchManager) SetPollInterval(d time.Duration) {
+	wm.mtx.Lock()
+	defer wm.mtx.Unlock()
+	wm.pollIvl = d
+}
+
+// startPoller kicks off the routine polling watched directories
+// caller MUST HOLD THE LOCK
+func (wm *WatchManager) startPoller(interval time.Duration) {
+	stop, wg := make(chan struct{}), &sync.WaitGroup{}
+	wm.pollStop, wm.pollWg = stop, wg
+	known := wm.nolockPollSnapshot()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tckr := time.NewTicker(interval)
+		defer tckr.Stop()
+		for {
+			select {
+			case <-tckr.C:
+			case <-stop:
+				return
+			}
+			known = wm.poll(known)
+		}
+	}()
+}
+
+// stopPoller stops the polling routine and waits for it to exit
+// the caller MUST NOT hold the lock
+func (wm *WatchManager) stopPoller() {
+	wm.mtx.Lock()
+	stop, wg := wm.pollStop, wm.pollWg
+	wm.pollStop = nil
+	wm.mtx.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	wg.Wait()
+}
+
+// nolockPollSnapshot lists the regular files in every watched directory
+// caller MUST HOLD THE LOCK
+func (wm *WatchManager) nolockPollSnapshot() map[string]polledFile {
+	files := map[string]polledFile{}
+	for dir, cfgs := range wm.watched {
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue //removed directories are noticed by the filter manager
+		}
+		for _, fi := range fis {
+			fpath := filepath.Join(dir, fi.Name())
+			if fi.IsDir() {
+				if _, ok := wm.watched[fpath]; !ok && anyRecursive(cfgs) {
+					files[fpath] = polledFile{dir: true}
+				}
+				continue
+			} else if !fi.Mode().IsRegular() {
+				continue
+			}
+			id, err := wm.fman.fileId(fpath)
+			if err != nil {
+				continue //gone already
+			}
+			files[fpath] = polledFile{id: id, size: fi.Size()}
+		}
+	}
+	return files
+}
+
+// poll diffs the watched directories against the previous pass and hands the
+// differences to the filter manager the same way notifications would
+func (wm *WatchManager) poll(known map[string]polledFile) map[string]polledFile {
+	wm.mtx.Lock()
+	curr := wm.nolockPollSnapshot()
+	wm.mtx.Unlock()
+
+	prev := make(map[FileId]string, len(known))
+	for fpath, pf := range known {
+		if !pf.dir {
+			prev[pf.id] = fpath
+		}
+	}
+	seen := make(map[FileId]bool, len(curr))
+	handled := map[string]bool{}
+	//renames first, loading the new name moves the follower over before anything
+	//is created at the old name
+	for fpath, pf := range curr {
+		if pf.dir {
+			continue
+		}
+		seen[pf.id] = true
+		if old, ok := prev[pf.id]; ok && old != fpath {
+			handled[fpath] = true
+			wm.pollLoad(fpath, true)
+		}
+	}
+	//files that are gone for good
+	for fpath, old := range known {
+		if old.dir || seen[old.id] {
+			continue
+		}
+		if _, ok := curr[fpath]; ok {
+			//replaced by a new file and the old one was moved out of sight
+			if err := wm.renameWatchedFile(fpath); err != nil {
+				wm.logger.Error("file_follower failed to track renamed file %s due to %v", fpath, err)
+			}
+		} else if ok, err := wm.deleteWatchedFile(fpath); err != nil {
+			wm.logger.Error("file_follower failed to stop watching %s due to %v", fpath, err)
+		} else if ok {
+			wm.logger.Info("file_follower stopped watching %s", fpath)
+		}
+	}
+	//new files and new subdirectories
+	for fpath, pf := range curr {
+		old, ok := known[fpath]
+		if pf.dir {
+			if !ok {
+				wm.watchSubdirectory(fpath)
+			}
+		} else if handled[fpath] {
+			continue
+		} else if !ok || old.id != pf.id {
+			if !wm.fman.IsWatched(fpath) {
+				wm.pollLoad(fpath, true)
+			}
+		} else if old.size != pf.size && !wm.fman.IsWatched(fpath) {
+			//files that were turned away may be admitted once they have content
+			wm.pollLoad(fpath, false)
+		}
+	}
+	return curr
+}
+
+// pollLoad loads a file found by the poller, created files start without a state
+func (wm *WatchManager) pollLoad(fpath string, created bool) {
+	var ok bool
+	var err error
+	if created {
+		ok, err = wm.watchNewFile(fpath)
+	} else {
+		ok, err = wm.fman.LoadFile(fpath)
+	}
+	if err != nil {
+		wm.logger.Error("file_follower failed to watch file %s due to %v", fpath, err)
+	} else if ok {
+		wm.logger.Info("file_follower now watching %s", fpath)
+	}
+}
+
+// anyRecursive returns true if any of the configs watches subdirectories
+func anyRecursive(cfgs []WatchConfig) bool {
+	for _, c := range cfgs {
+		if c.Recursive {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+// +build linux
+
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+package filewatch
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const procDir = `/proc`
+
+// WrittenByProcess returns a FilePredicate which only admits files that are
+// currently held open for writing by a process whose name (as reported in
+// /proc/<pid>/comm) matches one of the provided names.
+// The check walks every process in /proc, so it is not cheap; processes we
+// are not allowed to inspect are silently skipped.
+func WrittenByProcess(names ...string) FilePredicate {
+	return func(fpath string) (bool, error) {
+		return openForWriteBy(fpath, names)
+	}
+}
+
+func openForWriteBy(fpath string, names []string) (bool, error) {
+	id, err := getFileIdFromName(fpath)
+	if err != nil {
+		return false, err
+	}
+	pids, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range pids {
+		if _, err := strconv.Atoi(p.Name()); err != nil || !p.IsDir() {
+			continue //not a process directory
+		}
+		pdir := filepath.Join(procDir, p.Name())
+		comm, err := ioutil.ReadFile(filepath.Join(pdir, `comm`))
+		if err != nil || !nameMatches(strings.TrimSpace(string(comm)), names) {
+			continue
+		}
+		fds, err := ioutil.ReadDir(filepath.Join(pdir, `fd`))
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			//stat follows the fd link so we can compare the device and inode
+			var sc syscall.Stat_t
+			if err := syscall.Stat(filepath.Join(pdir, `fd`, fd.Name()), &sc); err != nil {
+				continue
+			}
+			if sc.Dev != id.Major || sc.Ino != id.Minor {
+				continue
+			}
+			if fdWritable(filepath.Join(pdir, `fdinfo`, fd.Name())) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func nameMatches(comm string, names []string) bool {
+	for _, n := range names {
+		if n == comm {
+			return true
+		}
+	}
+	return false
+}
+
+// fdWritable parses the flags field of a /proc/<pid>/fdinfo/<fd> file
+// and reports if the descriptor was opened for writing
+func fdWritable(p string) bool {
+	fin, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer fin.Close()
+	scn := bufio.NewScanner(fin)
+	for scn.Scan() {
+		ln := scn.Text()
+		if !strings.HasPrefix(ln, `flags:`) {
+			continue
+		}
+		flags, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(ln, `flags:`)), 8, 64)
+		if err != nil {
+			return false
+		}
+		return flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+// +build linux
+
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+package filewatch
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestWrittenByProcess(t *testing.T) {
+	comm, err := ioutil.ReadFile(`/proc/self/comm`)
+	if err != nil {
+		t.Skip("no procfs available", err)
+	}
+	self := strings.TrimSpace(string(comm))
+
+	f, name, err := newFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanFile(name, t)
+
+	//we hold it open RW, so we should be admitted
+	if ok, err := WrittenByProcess(self)(name); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("file held open for writing was not admitted")
+	}
+	//some other process name should not be
+	if ok, err := WrittenByProcess(`not` + self)(name); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("file admitted for the wrong process")
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	//nobody has it open anymore
+	if ok, err := WrittenByProcess(self)(name); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("closed file was admitted")
+	}
+}
@@ -0,0 +1,92 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+// Package promcollector exports filewatch manager metrics to Prometheus, it lives
+// in its own package so the core filewatch package does not depend on the client.
+package promcollector
+
+import (
+	"github.com/gravwell/filewatch/v3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	DefaultNamespace = `filewatch`
+)
+
+// Source is implemented by both filewatch.FilterManager and filewatch.WatchManager
+type Source interface {
+	Stats() filewatch.ManagerStats
+	FollowerStatuses() []filewatch.FollowerStatus
+}
+
+// Collector is a prometheus.Collector reading a manager on every scrape
+type Collector struct {
+	src        Source
+	follows    *prometheus.Desc
+	files      *prometheus.Desc
+	bytesRead  *prometheus.Desc
+	records    *prometheus.Desc
+	readErrors *prometheus.Desc
+	rotations  *prometheus.Desc
+}
+
+// NewCollector builds a collector for src, metric names are prefixed with namespace
+// or DefaultNamespace if it is empty.  Follows carry a filter label holding the
+// filter name, the other metrics are manager wide.
+func NewCollector(src Source, namespace string) *Collector {
+	if namespace == `` {
+		namespace = DefaultNamespace
+	}
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, ``, name), help, labels, nil)
+	}
+	return &Collector{
+		src:        src,
+		follows:    desc(`follows`, `Number of active followers.`, `filter`),
+		files:      desc(`files`, `Number of distinct files being followed.`),
+		bytesRead:  desc(`bytes_read_total`, `Raw bytes consumed from followed files.`),
+		records:    desc(`records_total`, `Records accepted by handlers.`),
+		readErrors: desc(`read_errors_total`, `Failed reads from followed files.`),
+		rotations:  desc(`rotations_total`, `Renames and truncations of followed files that were handled.`),
+	}
+}
+
+// Register builds a collector for src and registers it with r
+func Register(r prometheus.Registerer, src Source, namespace string) (*Collector, error) {
+	c := NewCollector(src, namespace)
+	if err := r.Register(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.follows
+	ch <- c.files
+	ch <- c.bytesRead
+	ch <- c.records
+	ch <- c.readErrors
+	ch <- c.rotations
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	follows := map[string]int{}
+	for _, st := range c.src.FollowerStatuses() {
+		follows[st.Name.BaseName]++
+	}
+	for filter, cnt := range follows {
+		ch <- prometheus.MustNewConstMetric(c.follows, prometheus.GaugeValue, float64(cnt), filter)
+	}
+	ms := c.src.Stats()
+	ch <- prometheus.MustNewConstMetric(c.files, prometheus.GaugeValue, float64(ms.Files))
+	ch <- prometheus.MustNewConstMetric(c.bytesRead, prometheus.CounterValue, float64(ms.BytesRead))
+	ch <- prometheus.MustNewConstMetric(c.records, prometheus.CounterValue, float64(ms.Records))
+	ch <- prometheus.MustNewConstMetric(c.readErrors, prometheus.CounterValue, float64(ms.ReadErrors))
+	ch <- prometheus.MustNewConstMetric(c.rotations, prometheus.CounterValue, float64(ms.Rotations))
+}
@@ -0,0 +1,80 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package promcollector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gravwell/filewatch/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type fakeSource struct {
+	stats    filewatch.ManagerStats
+	statuses []filewatch.FollowerStatus
+}
+
+func (fs *fakeSource) Stats() filewatch.ManagerStats                { return fs.stats }
+func (fs *fakeSource) FollowerStatuses() []filewatch.FollowerStatus { return fs.statuses }
+
+func TestCollector(t *testing.T) {
+	src := &fakeSource{
+		stats: filewatch.ManagerStats{
+			Files:      2,
+			BytesRead:  1024,
+			Records:    10,
+			ReadErrors: 1,
+			Rotations:  3,
+		},
+		statuses: []filewatch.FollowerStatus{
+			{Name: filewatch.FileName{BaseName: `syslog`, FilePath: `/var/log/syslog`}},
+			{Name: filewatch.FileName{BaseName: `syslog`, FilePath: `/var/log/messages`}},
+			{Name: filewatch.FileName{BaseName: `apache`, FilePath: `/var/log/messages`}},
+		},
+	}
+	reg := prometheus.NewPedanticRegistry()
+	if _, err := Register(reg, src, `test`); err != nil {
+		t.Fatal(err)
+	}
+	expected := `
+# HELP test_bytes_read_total Raw bytes consumed from followed files.
+# TYPE test_bytes_read_total counter
+test_bytes_read_total 1024
+# HELP test_files Number of distinct files being followed.
+# TYPE test_files gauge
+test_files 2
+# HELP test_follows Number of active followers.
+# TYPE test_follows gauge
+test_follows{filter="apache"} 1
+test_follows{filter="syslog"} 2
+# HELP test_read_errors_total Failed reads from followed files.
+# TYPE test_read_errors_total counter
+test_read_errors_total 1
+# HELP test_records_total Records accepted by handlers.
+# TYPE test_records_total counter
+test_records_total 10
+# HELP test_rotations_total Renames and truncations of followed files that were handled.
+# TYPE test_rotations_total counter
+test_rotations_total 3
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+
+	//a second collector for the same namespace collides
+	if _, err := Register(reg, src, `test`); err == nil {
+		t.Fatal("duplicate registration did not fail")
+	}
+
+	//a live manager satisfies the source interface
+	var _ Source = &filewatch.WatchManager{}
+	var _ Source = &filewatch.FilterManager{}
+}
@@ -9,7 +9,9 @@
 package filewatch
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"os"
 )
 
@@ -18,6 +20,18 @@ const (
 	buffBlockSize int = 4096
 )
 
+// Read buffer sizes, see ReaderConfig.BufferSize.  Small buffers suit many small
+// files, large ones cut the read calls made on big high rate files.
+const (
+	DefaultReadBufferSize int = buffBlockSize
+	MinReadBufferSize     int = 512
+	MaxReadBufferSize     int = 4 * 1024 * 1024
+)
+
+var (
+	ErrReadBufferSize = errors.New("Read buffer size must be between 512 bytes and 4MB")
+)
+
 const (
 	LineEngine  int = 0
 	RegexEngine int = 1
@@ -31,21 +45,57 @@ type Reader interface {
 }
 
 type ReaderConfig struct {
-	Fin        *os.File
-	MaxLineLen int
-	StartIndex int64
-	Engine     int
-	EngineArgs string
+	Fin           *os.File
+	MaxLineLen    int
+	StartIndex    int64
+	Engine        int
+	EngineArgs    string
+	KeepDelimiter bool   //line engine only, deliver the raw line including its delimiter
+	Delimiter     string //line engine only, the single byte ending each record, empty is a newline
+	// MultilineStart groups records into multiline records that begin with a record
+	// matching this expression, MaxMultilineBytes caps the size of a grouped record
+	MultilineStart    string
+	MaxMultilineBytes int
+	// FlushPartial lets a line reader hand back a trailing partial line through
+	// FlushEntry, otherwise partial lines are only returned once they are completed
+	FlushPartial bool
+	Gzip         bool   //decompress the file with a GzipReader, line engine only
+	Encoding     string //convert from this character encoding with an EncodedReader, line engine only
+	BufferSize   int    //bytes read from the file at a time, zero is DefaultReadBufferSize
 }
 
 func NewReader(cfg ReaderConfig) (Reader, error) {
+	var rdr Reader
+	var err error
+	if err = checkReadBufferSize(cfg.BufferSize); err != nil {
+		return nil, err
+	}
 	switch cfg.Engine {
-	case RegexEngine:
-		return NewRegexReader(cfg)
 	case LineEngine: //default/empty is line reader
-		return NewLineReader(cfg)
+		switch {
+		case cfg.Gzip && cfg.Encoding != ``:
+			return nil, ErrEncodingEngine
+		case cfg.Gzip:
+			rdr, err = NewGzipReader(cfg)
+		case cfg.Encoding != ``:
+			rdr, err = NewEncodedReader(cfg)
+		default:
+			rdr, err = NewLineReader(cfg)
+		}
+	case RegexEngine:
+		if cfg.Gzip {
+			return nil, ErrGzipEngine
+		} else if cfg.Encoding != `` {
+			return nil, ErrEncodingEngine
+		}
+		rdr, err = NewRegexReader(cfg)
+	default:
+		return nil, errors.New("Unknown engine")
+	}
+	if err != nil || cfg.MultilineStart == `` {
+		return rdr, err
 	}
-	return nil, errors.New("Unknown engine")
+	return NewMultilineReader(rdr, cfg)
 }
 
 type baseReader struct {
@@ -95,3 +145,86 @@ func (br *baseReader) Close() error {
 	br.f = nil
 	return nil
 }
+
+// checkReadBufferSize ensures a read buffer size is zero or within the allowed range
+func checkReadBufferSize(n int) error {
+	if n != 0 && (n < MinReadBufferSize || n > MaxReadBufferSize) {
+		return ErrReadBufferSize
+	}
+	return nil
+}
+
+// readBufferSize resolves a read buffer size, zero is the default
+func readBufferSize(n int) int {
+	if n <= 0 {
+		return DefaultReadBufferSize
+	}
+	return n
+}
+
+// parseDelimiter returns the record delimiter byte, defaulting to a newline
+func parseDelimiter(s string) (byte, error) {
+	switch len(s) {
+	case 0:
+		return '\n', nil
+	case 1:
+		return s[0], nil
+	}
+	return 0, fmt.Errorf("%w: %q", ErrInvalidDelimiter, s)
+}
+
+// trimDelim strips the delimiter from the end of a record, the newline delimiter also
+// strips carriage returns so that \r\n terminated lines come out clean
+func trimDelim(b []byte, delim byte) []byte {
+	if delim == '\n' {
+		return bytes.TrimRight(b, "\r\n")
+	}
+	return bytes.TrimSuffix(b, []byte{delim})
+}
+
+// tailOffset returns the offset of the start of the nth record from the end of the file,
+// a trailing partial record counts as the last record.  Zero is returned if the file has
+// n or fewer records.
+func tailOffset(fpath string, n int, delim byte) (int64, error) {
+	fin, err := os.Open(fpath)
+	if err != nil {
+		return 0, err
+	}
+	defer fin.Close()
+	fi, err := fin.Stat()
+	if err != nil {
+		return 0, err
+	}
+	end := fi.Size()
+	if n <= 0 {
+		return end, nil
+	}
+	buff := make([]byte, buffBlockSize)
+	var cnt int
+	last := true //the final byte terminates the last line rather than starting a new one
+	for end > 0 {
+		start := end - int64(len(buff))
+		if start < 0 {
+			start = 0
+		}
+		b := buff[:end-start]
+		if _, err := fin.ReadAt(b, start); err != nil {
+			return 0, err
+		}
+		for i := len(b) - 1; i >= 0; i-- {
+			if last {
+				last = false
+				if b[i] == delim {
+					continue
+				}
+			}
+			if b[i] == delim {
+				if cnt++; cnt == n {
+					return start + int64(i) + 1, nil
+				}
+			}
+		}
+		end = start
+	}
+	return 0, nil
+}
@@ -34,7 +34,12 @@ func NewRegexReader(cfg ReaderConfig) (*RegexReader, error) {
 		scn:        bufio.NewScanner(cfg.Fin),
 	}
 	rr.scn.Split(rr.splitter)
-	rr.scn.Buffer(make([]byte, cfg.MaxLineLen), 2*cfg.MaxLineLen)
+	if cfg.BufferSize > 0 {
+		//start small and let the scanner grow the buffer for long records
+		rr.scn.Buffer(make([]byte, cfg.BufferSize), 2*cfg.MaxLineLen)
+	} else {
+		rr.scn.Buffer(make([]byte, cfg.MaxLineLen), 2*cfg.MaxLineLen)
+	}
 	return rr, nil
 }
 
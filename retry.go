@@ -0,0 +1,154 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// FailureMode selects what a follower does with a record the handler still rejects
+// after every retry
+type FailureMode int
+
+const (
+	FailStop FailureMode = iota //stop the follower, the record is read again on restart
+	FailDrop                    //skip the record and keep going, see ManagerStats.DroppedRecords
+)
+
+// PanicPolicy selects what a follower does with a record its handler panicked on,
+// the panic is always recovered, logged, and counted in ManagerStats.HandlerPanics
+type PanicPolicy int
+
+const (
+	PanicAsError PanicPolicy = iota //treat the panic like any handler error, the retry policy applies
+	PanicSkip                       //hand the record to the dead letter sink without retrying, or drop it if there is none
+	PanicStop                       //stop the follower without retrying, the record is read again on restart
+)
+
+// RetryPolicy controls how followers retry records their handler returns an error
+// for.  The state never moves past a record until the handler accepts it or the
+// record is dropped.  The zero value makes a single attempt and stops the follower.
+type RetryPolicy struct {
+	MaxAttempts int           //attempts per record including the first, less than 2 never retries
+	Backoff     time.Duration //wait before the first retry
+	Multiplier  float64       //growth of the wait after each retry, less than 1 keeps it constant
+	OnFailure   FailureMode
+}
+
+// deadLetterHandler is implemented by dead letter sinks that want to know where a
+// rejected record came from and why, see DeadLetterFunc
+type deadLetterHandler interface {
+	HandleDeadLetter(FileName, []byte, time.Time, error) error
+}
+
+// DeadLetterFunc adapts a function into a dead letter sink for SetDeadLetter, it is
+// given the file each rejected record came from and the last handler error
+type DeadLetterFunc func(FileName, []byte, time.Time, error) error
+
+func (fn DeadLetterFunc) HandleLog(b []byte, ts time.Time) error {
+	return fn(FileName{}, b, ts, nil)
+}
+
+func (fn DeadLetterFunc) HandleDeadLetter(name FileName, b []byte, ts time.Time, err error) error {
+	return fn(name, b, ts, err)
+}
+
+// SetDeadLetter sets a sink for records the handler still rejects once the retry
+// policy gives up, so they can be captured and replayed later.  Records taken by the
+// sink count as handled and the follower moves on regardless of the failure mode, if
+// the sink fails too the failure mode applies.  Plain handlers get the record through
+// HandleLog, DeadLetterFunc also receives the source file and the handler error.  A
+// nil sink, the default, drops or stops as the retry policy says.  Followers started
+// before the call keep the sink they were started with.
+func (fm *FilterManager) SetDeadLetter(lh handler) {
+	fm.mtx.Lock()
+	defer fm.mtx.Unlock()
+	fm.deadLetter = lh
+}
+
+// SetPanicPolicy sets what followers do with records their handler panics on, the
+// default PanicAsError applies the retry policy.  Followers started before the call
+// keep the policy they were started with.
+func (fm *FilterManager) SetPanicPolicy(p PanicPolicy) {
+	fm.mtx.Lock()
+	defer fm.mtx.Unlock()
+	fm.panics = p
+}
+
+// SetRetryPolicy sets the retry policy for handler errors, followers started before
+// the call keep the policy they were started with
+func (fm *FilterManager) SetRetryPolicy(p RetryPolicy) {
+	fm.mtx.Lock()
+	defer fm.mtx.Unlock()
+	fm.retry = p
+}
+
+// handle runs a handler call carrying recs under the retry policy.  A nil error with
+// delivered false means the handler never took the records, they went to the dead
+// letter sink or were dropped.
+// only the follower routine may call this
+func (f *follower) handle(recs [][]byte, fn func() error) (delivered bool, err error) {
+	wait := f.retry.Backoff
+	var panicked bool
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil {
+			return true, nil
+		} else if errors.Is(err, context.Canceled) || f.ctx.Err() != nil {
+			return false, err //shutting down, the records are read again on restart
+		} else if panicked = errors.Is(err, ErrHandlerPanic); panicked && f.panics == PanicStop {
+			return false, err
+		} else if attempt >= f.retry.MaxAttempts || (panicked && f.panics == PanicSkip) {
+			break
+		}
+		select {
+		case <-time.After(wait):
+		case <-f.ctx.Done():
+			return false, f.ctx.Err()
+		}
+		if f.retry.Multiplier > 1 {
+			wait = time.Duration(float64(wait) * f.retry.Multiplier)
+		}
+	}
+	if f.dlq != nil {
+		n, derr := f.toDeadLetter(recs, err)
+		if derr == nil {
+			return false, nil
+		} else if errors.Is(derr, context.Canceled) {
+			return false, derr
+		}
+		recs = recs[n:]
+	}
+	if f.retry.OnFailure != FailDrop && !(panicked && f.panics == PanicSkip) {
+		return false, err
+	}
+	f.counters.dropRecords(len(recs))
+	return false, nil
+}
+
+// toDeadLetter hands records the handler rejected with herr to the dead letter sink
+// and returns how many the sink took
+// only the follower routine may call this
+func (f *follower) toDeadLetter(recs [][]byte, herr error) (n int, err error) {
+	for _, rec := range recs {
+		err = f.guard(func() error {
+			if dl, ok := f.dlq.(deadLetterHandler); ok {
+				return dl.HandleDeadLetter(f.name(), rec, time.Now(), herr)
+			}
+			return f.dlq.HandleLog(rec, time.Now())
+		})
+		if err != nil {
+			return
+		}
+		f.counters.deadLetter(1)
+		n++
+	}
+	return
+}
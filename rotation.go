@@ -0,0 +1,127 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"time"
+)
+
+// RotationAction is what the manager does with a follower whose file showed up
+// under a new path
+type RotationAction int
+
+const (
+	RotationFollow  RotationAction = iota //keep following the file under its new name if the filter still matches
+	RotationRelease                       //stop following the file, drop its state, and do not pick it up under the new name
+)
+
+// RotationChange describes a followed file that was observed under a new path
+type RotationChange struct {
+	Name    FileName //current name of the follower
+	Id      FileId   //id shared by the follower and the new path
+	NewPath string   //path the file now lives at
+	Matches bool     //the new path still matches the filter that owns the follower
+}
+
+// RotationDetector decides what a rename of a followed file means.  Truncation
+// based rotation (copytruncate, O_TRUNC reuse) keeps the same path and FileId and
+// is handled by the follower itself, so detectors only see renames.
+type RotationDetector interface {
+	Detect(RotationChange) RotationAction
+}
+
+// RenameRotation is the default detector for rename and create rotation, a renamed
+// file keeps being followed for as long as its new name matches the filter.
+type RenameRotation struct{}
+
+func (RenameRotation) Detect(RotationChange) RotationAction {
+	return RotationFollow
+}
+
+// ReleaseRotation never follows a file after it is renamed.  It is intended for
+// schemes where rotated files are archives that still match the filter, such as
+// app.log being rotated to app.log.1 with a filter of app.log*.
+type ReleaseRotation struct{}
+
+func (ReleaseRotation) Detect(RotationChange) RotationAction {
+	return RotationRelease
+}
+
+// SetRotationDetector installs the detector consulted when a followed file is renamed,
+// a nil detector restores the default RenameRotation behavior
+func (fm *FilterManager) SetRotationDetector(rd RotationDetector) {
+	fm.mtx.Lock()
+	defer fm.mtx.Unlock()
+	fm.rotation = rd
+}
+
+// rotationDetector returns the installed detector or the default
+// caller MUST HOLD THE LOCK
+func (fm *FilterManager) rotationDetector() RotationDetector {
+	if fm.rotation == nil {
+		return RenameRotation{}
+	}
+	return fm.rotation
+}
+
+// SetRotationDrain keeps following a file that was rotated out from under its filters
+// until no new records show up for the given period.  Writers often keep appending to
+// the old file for a moment after it is renamed, and those lines are lost if the
+// follower is closed as soon as the rename is seen.  Zero closes the follower right
+// away, after it delivered what was already written.
+func (fm *FilterManager) SetRotationDrain(d time.Duration) {
+	fm.mtx.Lock()
+	defer fm.mtx.Unlock()
+	fm.rotationDrain = d
+}
+
+// retire closes a follower whose file was rotated away, honoring the rotation drain
+// caller MUST HOLD THE LOCK
+func (fm *FilterManager) retire(name FileName, fl *follower) error {
+	fm.events.emit(FollowerEvent{
+		Type: EventStopped,
+		Name: name,
+	})
+	fm.counters.rotation()
+	if fm.rotationDrain <= 0 {
+		return fl.Close()
+	}
+	if fm.draining == nil {
+		fm.draining = map[*follower]FileName{}
+	}
+	fm.draining[fl] = name
+	go fm.drain(fl, fm.rotationDrain)
+	return nil
+}
+
+// drain waits for a retired follower to go quiet and then closes it, followers that
+// were already closed by the manager are left alone
+func (fm *FilterManager) drain(fl *follower, quiet time.Duration) {
+	tckr := time.NewTicker(waitPollInterval)
+	defer tckr.Stop()
+	last, since := fl.offset(), time.Now()
+	for range tckr.C {
+		if off := fl.offset(); off != last {
+			last, since = off, time.Now()
+			continue
+		} else if time.Since(since) < quiet && fl.Running() {
+			continue
+		}
+		fm.mtx.Lock()
+		name, ok := fm.draining[fl]
+		delete(fm.draining, fl)
+		fm.mtx.Unlock()
+		if ok {
+			if err := fl.Close(); err != nil {
+				fm.logger.Error("Failed to close drained follower %v: %v", name, err)
+			}
+		}
+		return
+	}
+}
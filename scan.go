@@ -0,0 +1,151 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	defaultScanBatchSize   int = 256
+	defaultScanConcurrency int = 1
+)
+
+// ScanConfig controls the initial scan of watched directories performed when the
+// WatchManager starts.  Directories are read by Concurrency workers and the files
+// found are loaded BatchSize at a time, the filter manager lock is released between
+// batches so that other operations are not starved while scanning huge trees.
+type ScanConfig struct {
+	BatchSize   int
+	Concurrency int
+}
+
+func (sc ScanConfig) withDefaults() ScanConfig {
+	if sc.BatchSize <= 0 {
+		sc.BatchSize = defaultScanBatchSize
+	}
+	if sc.Concurrency <= 0 {
+		sc.Concurrency = defaultScanConcurrency
+	}
+	return sc
+}
+
+// SetScanConfig sets the batch size and concurrency used by the initial scan
+func (wm *WatchManager) SetScanConfig(sc ScanConfig) {
+	wm.mtx.Lock()
+	defer wm.mtx.Unlock()
+	wm.scan = sc
+}
+
+func (wm *WatchManager) initExisting() (err error) {
+	//ready all files in the directory, this COULD potentially be millions
+	//if someone is dumb enough to drop that many files for follwing in a single directory
+	//we will slow down and most likely puke when we attempt to register fsnotify handlers
+	//this is an OS/user problem, not ours
+	sc := wm.scan.withDefaults()
+	dirCh := make(chan string)
+	batchCh := make(chan []string, sc.Concurrency)
+	errCh := make(chan error, sc.Concurrency)
+	done := make(chan struct{})
+	var once sync.Once
+	abort := func() {
+		once.Do(func() { close(done) })
+	}
+	defer abort()
+
+	//feed directories to the readers
+	go func() {
+		defer close(dirCh)
+		for k := range wm.watched {
+			select {
+			case dirCh <- k:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	//fire up the directory readers
+	var wg sync.WaitGroup
+	for i := 0; i < sc.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dir := range dirCh {
+				if err := scanDir(dir, sc.BatchSize, batchCh, done); err != nil {
+					errCh <- err
+					abort()
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(batchCh)
+	}()
+
+	//load batches as they come in, draining the channel on error so readers can exit
+	var cnt int
+	for batch := range batchCh {
+		if err != nil {
+			continue
+		}
+		if err = wm.fman.loadBatch(batch); err != nil {
+			abort()
+			continue
+		}
+		cnt += len(batch)
+		wm.fman.events.emit(FollowerEvent{
+			Type:  EventScanProgress,
+			Count: cnt,
+		})
+	}
+	if err == nil {
+		select {
+		case err = <-errCh:
+		default:
+		}
+	}
+	return
+}
+
+// scanDir reads a directory and pushes the regular files it contains out in batches
+func scanDir(dir string, batchSize int, out chan []string, done chan struct{}) error {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("Failed to initialize %v: %v", dir, err)
+	}
+	var batch []string
+	for i := range fis {
+		if !fis[i].Mode().IsRegular() {
+			continue
+		}
+		batch = append(batch, filepath.Join(dir, fis[i].Name()))
+		if len(batch) < batchSize {
+			continue
+		}
+		select {
+		case out <- batch:
+		case <-done:
+			return nil
+		}
+		batch = nil
+	}
+	if len(batch) > 0 {
+		select {
+		case out <- batch:
+		case <-done:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+/*************************************************************************
+ * Copyright 2017 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"context"
+)
+
+// TraceMode selects what a span covers when a tracer is set, see WithTracer
+type TraceMode int
+
+const (
+	TracePerBatch  TraceMode = iota //one span per handler call
+	TracePerRecord                  //one span per record, records in a batch each get a span covering the batch
+)
+
+// Tracer starts the spans covering handler calls, the oteltrace package provides an
+// OpenTelemetry implementation.  StartSpan is called from follower routines and
+// must be safe for concurrent use.
+type Tracer interface {
+	StartSpan(ctx context.Context, d DeliveryInfo) (context.Context, Span)
+}
+
+// Span is ended once the handler call it covers returns, err is the handler error
+// and is nil on success.  Every attempt made under the retry policy gets its own span.
+type Span interface {
+	End(err error)
+}
+
+// DeliveryInfo describes the records carried by a traced handler call
+type DeliveryInfo struct {
+	FileName
+	FilterID int
+	Offset   int64 //offset in the file of the first record
+	Records  int
+	Bytes    int //record bytes as handed to the handler
+}
+
+type traceConfig struct {
+	tracer Tracer
+	mode   TraceMode
+}
+
+// WithTracer wraps every handler call in spans started by t, ContextHandlers receive
+// the span context so they can start child spans.  A nil tracer, the default, leaves
+// handler calls untraced.
+func WithTracer(t Tracer, mode TraceMode) ManagerOption {
+	return func(mc *managerConfig) {
+		mc.trace = traceConfig{tracer: t, mode: mode}
+	}
+}
+
+// traced runs fn, a handler call carrying recs that start at offs, inside spans from
+// the tracer.  Without a tracer fn gets the follower context and nothing else happens.
+// only the follower routine may call this
+func (f *follower) traced(recs [][]byte, offs []int64, fn func(context.Context) error) error {
+	if f.trace.tracer == nil {
+		return fn(f.ctx)
+	} else if f.trace.mode != TracePerRecord || len(recs) == 1 {
+		ctx, sp := f.trace.tracer.StartSpan(f.ctx, f.deliveryInfo(recs, offs))
+		err := fn(ctx)
+		sp.End(err)
+		return err
+	}
+	spans := make([]Span, 0, len(recs))
+	for i := range recs {
+		_, sp := f.trace.tracer.StartSpan(f.ctx, f.deliveryInfo(recs[i:i+1], offs[i:i+1]))
+		spans = append(spans, sp)
+	}
+	err := fn(f.ctx)
+	for _, sp := range spans {
+		sp.End(err)
+	}
+	return err
+}
+
+func (f *follower) deliveryInfo(recs [][]byte, offs []int64) (d DeliveryInfo) {
+	d = DeliveryInfo{
+		FileName: f.name(),
+		FilterID: f.FilterId(),
+		Records:  len(recs),
+	}
+	if len(offs) > 0 {
+		d.Offset = offs[0]
+	}
+	for _, rec := range recs {
+		d.Bytes += len(rec)
+	}
+	return
+}